@@ -0,0 +1,310 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/docdb"
+	awsec2 "github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+// docdbPort is the port documentDB clusters listen on
+const docdbPort int64 = 27017
+
+// securityGroupActions are the actions needed to manage the dedicated security group of a cluster
+var securityGroupActions = []string{
+	"ec2:AuthorizeSecurityGroupIngress",
+	"ec2:CreateSecurityGroup",
+	"ec2:CreateTags",
+	"ec2:DeleteSecurityGroup",
+	"ec2:DescribeSecurityGroups",
+	"ec2:RevokeSecurityGroupIngress",
+}
+
+// managedSecurityGroupName returns the name of the dedicated security group of a cluster
+func managedSecurityGroupName(org, cluster string) string {
+	return fmt.Sprintf("spinup-%s-docdb-%s", org, cluster)
+}
+
+// normalize validates the allowed sources and returns them sorted, with the cidrs in canonical form
+func (a *DocDBAccess) normalize() (*DocDBAccess, error) {
+	access := &DocDBAccess{
+		Cidrs:            []string{},
+		SecurityGroupIds: []string{},
+	}
+
+	for _, c := range a.Cidrs {
+		_, ipnet, err := net.ParseCIDR(c)
+		if err != nil || ipnet.IP.To4() == nil {
+			msg := fmt.Sprintf("invalid ipv4 cidr %q", c)
+			return nil, apierror.New(apierror.ErrBadRequest, msg, err)
+		}
+		access.Cidrs = appendUnique(access.Cidrs, ipnet.String())
+	}
+
+	for _, g := range a.SecurityGroupIds {
+		if !strings.HasPrefix(g, "sg-") {
+			msg := fmt.Sprintf("invalid security group id %q", g)
+			return nil, apierror.New(apierror.ErrBadRequest, msg, nil)
+		}
+		access.SecurityGroupIds = appendUnique(access.SecurityGroupIds, g)
+	}
+
+	sort.Strings(access.Cidrs)
+	sort.Strings(access.SecurityGroupIds)
+
+	return access, nil
+}
+
+// appendUnique appends the value to the list if it's not in it yet
+func appendUnique(list []string, v string) []string {
+	for _, l := range list {
+		if l == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// difference returns the values of a that aren't in b
+func difference(a, b []string) []string {
+	diff := []string{}
+	for _, v := range a {
+		found := false
+		for _, w := range b {
+			if v == w {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			diff = append(diff, v)
+		}
+	}
+	return diff
+}
+
+// accessFromPermissions returns the sources allowed to connect to the docdb port by the security group permissions
+func accessFromPermissions(permissions []*awsec2.IpPermission) *DocDBAccess {
+	access := &DocDBAccess{
+		Cidrs:            []string{},
+		SecurityGroupIds: []string{},
+	}
+
+	for _, p := range permissions {
+		if aws.StringValue(p.IpProtocol) != "tcp" || aws.Int64Value(p.FromPort) != docdbPort || aws.Int64Value(p.ToPort) != docdbPort {
+			continue
+		}
+
+		for _, r := range p.IpRanges {
+			access.Cidrs = appendUnique(access.Cidrs, aws.StringValue(r.CidrIp))
+		}
+
+		for _, g := range p.UserIdGroupPairs {
+			access.SecurityGroupIds = appendUnique(access.SecurityGroupIds, aws.StringValue(g.GroupId))
+		}
+	}
+
+	sort.Strings(access.Cidrs)
+	sort.Strings(access.SecurityGroupIds)
+
+	return access
+}
+
+// createClusterSecurityGroup creates the dedicated security group of a new cluster in the vpc, allowing
+// the given, normalized, sources to connect to the docdb port
+func (o *docDBOrchestrator) createClusterSecurityGroup(ctx context.Context, cluster, vpcId string, tags Tags, access *DocDBAccess) (string, error) {
+	name := managedSecurityGroupName(o.server.org, cluster)
+	id, err := o.ec2Client.CreateSecurityGroup(ctx, name, fmt.Sprintf("access to docdb cluster %s", cluster), vpcId, tags.toEC2Tags())
+	if err != nil {
+		return "", err
+	}
+
+	if err := o.ec2Client.AuthorizeIngress(ctx, id, docdbPort, access.Cidrs, access.SecurityGroupIds); err != nil {
+		o.deleteClusterSecurityGroup(ctx, id)
+		return "", err
+	}
+
+	return id, nil
+}
+
+// deleteClusterSecurityGroup deletes the dedicated security group after a failed create
+func (o *docDBOrchestrator) deleteClusterSecurityGroup(ctx context.Context, id string) {
+	if err := o.ec2Client.DeleteSecurityGroup(context.WithoutCancel(ctx), id); err != nil {
+		common.Logger(ctx).Errorf("failed to delete security group %s: %s", id, err)
+	}
+}
+
+// clusterSecurityGroup returns the dedicated security group of the cluster, or nil if it doesn't have one
+func (o *docDBOrchestrator) clusterSecurityGroup(ctx context.Context, cluster *docdb.DBCluster) (*awsec2.SecurityGroup, error) {
+	ids := []string{}
+	for _, g := range cluster.VpcSecurityGroups {
+		ids = append(ids, aws.StringValue(g.VpcSecurityGroupId))
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	groups, err := o.ec2Client.GetSecurityGroups(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	name := managedSecurityGroupName(o.server.org, aws.StringValue(cluster.DBClusterIdentifier))
+	for _, g := range groups {
+		if aws.StringValue(g.GroupName) != name {
+			continue
+		}
+
+		tags := Tags{}
+		for _, t := range g.Tags {
+			tags = append(tags, Tag{Key: aws.StringValue(t.Key), Value: aws.StringValue(t.Value)})
+		}
+
+		if tags.inOrg(o.server.org) {
+			return g, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// documentDBAccess returns the sources allowed to connect to the cluster through its dedicated security group
+func (o *docDBOrchestrator) documentDBAccess(ctx context.Context, name string) (*DocDBAccessResponse, error) {
+	if name == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	group, err := o.managedSecurityGroup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	access := accessFromPermissions(group.IpPermissions)
+
+	return &DocDBAccessResponse{
+		SecurityGroupId:  aws.StringValue(group.GroupId),
+		Cidrs:            access.Cidrs,
+		SecurityGroupIds: access.SecurityGroupIds,
+	}, nil
+}
+
+// documentDBAccessUpdate replaces the sources allowed to connect to the cluster through its dedicated security group
+func (o *docDBOrchestrator) documentDBAccessUpdate(ctx context.Context, name string, req *DocDBAccess) (*DocDBAccessResponse, error) {
+	if name == "" || req == nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	desired, err := req.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := o.lockClusterForRequest(ctx, operationModify, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	group, err := o.managedSecurityGroup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	id := aws.StringValue(group.GroupId)
+	current := accessFromPermissions(group.IpPermissions)
+
+	common.Logger(ctx).Infof("updating access to docdb cluster %s in security group %s", name, id)
+
+	// authorize the additions before revoking the removals, so a failed update doesn't cut off sources that
+	// keep their access
+	if err := o.ec2Client.AuthorizeIngress(ctx, id, docdbPort, difference(desired.Cidrs, current.Cidrs), difference(desired.SecurityGroupIds, current.SecurityGroupIds)); err != nil {
+		return nil, err
+	}
+
+	if err := o.ec2Client.RevokeIngress(ctx, id, docdbPort, difference(current.Cidrs, desired.Cidrs), difference(current.SecurityGroupIds, desired.SecurityGroupIds)); err != nil {
+		return nil, err
+	}
+
+	return &DocDBAccessResponse{
+		SecurityGroupId:  id,
+		Cidrs:            desired.Cidrs,
+		SecurityGroupIds: desired.SecurityGroupIds,
+	}, nil
+}
+
+// managedSecurityGroup returns the dedicated security group of the cluster, or not found if it doesn't have one
+func (o *docDBOrchestrator) managedSecurityGroup(ctx context.Context, name string) (*awsec2.SecurityGroup, error) {
+	cluster, _, err := o.orgCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := o.clusterSecurityGroup(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if group == nil {
+		msg := fmt.Sprintf("docdb cluster %s doesn't have a security group managed by the api", name)
+		return nil, apierror.New(apierror.ErrNotFound, msg, nil)
+	}
+
+	return group, nil
+}
+
+// deleteWaitForDeleted is the task step waiting for a deleted docdb cluster to be gone before deleting
+// its dedicated security group
+func deleteWaitForDeleted(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+	if err := o.waitForDeleted(ctx, state.Cluster, msgChan); err != nil {
+		return fmt.Errorf("failed to delete docdb cluster %s, timeout waiting to be deleted: %s", state.Cluster, err.Error())
+	}
+
+	if state.SecurityGroupID == "" {
+		return nil
+	}
+
+	msgChan <- fmt.Sprintf("deleting security group %s of docdb cluster %s", state.SecurityGroupID, state.Cluster)
+
+	if err := o.ec2Client.DeleteSecurityGroup(ctx, state.SecurityGroupID); err != nil {
+		return fmt.Errorf("failed to delete security group %s of docdb cluster %s: %s", state.SecurityGroupID, state.Cluster, err.Error())
+	}
+
+	return nil
+}
+
+// waitForDeleted waits for a docdb cluster to be gone
+func (o *docDBOrchestrator) waitForDeleted(ctx context.Context, cl string, msgChan chan<- string) error {
+	return retry(20, 3, 10*time.Second, func() error {
+		msgChan <- fmt.Sprintf("checking if docdb cluster %s is deleted before continuing", cl)
+
+		if err := o.refreshSession(ctx); err != nil {
+			msgChan <- fmt.Sprintf("unable to refresh orchestrator session: %s", err)
+			return err
+		}
+
+		cluster, err := o.docdbClient.GetDocDBDetails(ctx, cl)
+		if err != nil {
+			if aerr, ok := errors.Cause(err).(apierror.Error); ok && aerr.Code == apierror.ErrNotFound {
+				msgChan <- fmt.Sprintf("docdb cluster %s is deleted", cl)
+				return nil
+			}
+
+			msgChan <- fmt.Sprintf("got error checking if docdb cluster %s is deleted: %s", cl, err)
+			return err
+		}
+
+		msgChan <- fmt.Sprintf("docdb cluster %s is not yet deleted (%s)", cl, aws.StringValue(cluster.Status))
+		return fmt.Errorf("docdb cluster %s not yet deleted", cl)
+	})
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/YaleSpinup/docdb-api/docdb"
+	"github.com/YaleSpinup/docdb-api/ec2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awsdocdb "github.com/aws/aws-sdk-go/service/docdb"
+	"github.com/aws/aws-sdk-go/service/docdb/docdbiface"
+	awsec2 "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// mockDocDBClient is a fake docdb client returning fixed clusters, instances and tags by resource ARN
+type mockDocDBClient struct {
+	docdbiface.DocDBAPI
+	clusters  []*awsdocdb.DBCluster
+	instances []*awsdocdb.DBInstance
+	tags      map[string][]*awsdocdb.Tag
+	snapshots []*awsdocdb.DBClusterSnapshot
+}
+
+func (m *mockDocDBClient) DescribeDBClustersWithContext(ctx context.Context, input *awsdocdb.DescribeDBClustersInput, opts ...request.Option) (*awsdocdb.DescribeDBClustersOutput, error) {
+	for _, c := range m.clusters {
+		if aws.StringValue(c.DBClusterIdentifier) == aws.StringValue(input.DBClusterIdentifier) {
+			return &awsdocdb.DescribeDBClustersOutput{DBClusters: []*awsdocdb.DBCluster{c}}, nil
+		}
+	}
+	return &awsdocdb.DescribeDBClustersOutput{}, nil
+}
+
+func (m *mockDocDBClient) ListTagsForResourceWithContext(ctx context.Context, input *awsdocdb.ListTagsForResourceInput, opts ...request.Option) (*awsdocdb.ListTagsForResourceOutput, error) {
+	return &awsdocdb.ListTagsForResourceOutput{TagList: m.tags[aws.StringValue(input.ResourceName)]}, nil
+}
+
+// orgTags returns the docdb tags of a cluster in the org
+func orgTags(org string) []*awsdocdb.Tag {
+	return []*awsdocdb.Tag{{Key: aws.String("spinup:org"), Value: aws.String(org)}}
+}
+
+func (m *mockEC2Client) DescribeSecurityGroupsWithContext(ctx context.Context, input *awsec2.DescribeSecurityGroupsInput, opts ...request.Option) (*awsec2.DescribeSecurityGroupsOutput, error) {
+	out := []*awsec2.SecurityGroup{}
+	for _, id := range aws.StringValueSlice(input.GroupIds) {
+		for _, g := range m.groups {
+			if aws.StringValue(g.GroupId) == id {
+				out = append(out, g)
+			}
+		}
+	}
+	return &awsec2.DescribeSecurityGroupsOutput{SecurityGroups: out}, nil
+}
+
+func (m *mockEC2Client) AuthorizeSecurityGroupIngressWithContext(ctx context.Context, input *awsec2.AuthorizeSecurityGroupIngressInput, opts ...request.Option) (*awsec2.AuthorizeSecurityGroupIngressOutput, error) {
+	m.authorized = input.IpPermissions
+	return &awsec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (m *mockEC2Client) RevokeSecurityGroupIngressWithContext(ctx context.Context, input *awsec2.RevokeSecurityGroupIngressInput, opts ...request.Option) (*awsec2.RevokeSecurityGroupIngressOutput, error) {
+	m.revoked = input.IpPermissions
+	return &awsec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func TestDocDBAccessNormalize(t *testing.T) {
+	access, err := (&DocDBAccess{
+		Cidrs:            []string{"10.1.2.3/16", "192.168.0.0/24", "10.1.0.0/16"},
+		SecurityGroupIds: []string{"sg-b", "sg-a"},
+	}).normalize()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := &DocDBAccess{
+		Cidrs:            []string{"10.1.0.0/16", "192.168.0.0/24"},
+		SecurityGroupIds: []string{"sg-a", "sg-b"},
+	}
+	if !reflect.DeepEqual(access, expected) {
+		t.Errorf("expected %+v, got %+v", expected, access)
+	}
+
+	for _, a := range []*DocDBAccess{
+		{Cidrs: []string{"10.0.0.1"}},
+		{Cidrs: []string{"::/0"}},
+		{SecurityGroupIds: []string{"app"}},
+	} {
+		if _, err := a.normalize(); !isBadRequest(err) {
+			t.Errorf("expected bad request for %+v, got %v", a, err)
+		}
+	}
+}
+
+func TestAccessFromPermissions(t *testing.T) {
+	permissions := []*awsec2.IpPermission{
+		{
+			FromPort:         aws.Int64(27017),
+			ToPort:           aws.Int64(27017),
+			IpProtocol:       aws.String("tcp"),
+			IpRanges:         []*awsec2.IpRange{{CidrIp: aws.String("10.1.0.0/16")}},
+			UserIdGroupPairs: []*awsec2.UserIdGroupPair{{GroupId: aws.String("sg-app")}},
+		},
+		{
+			FromPort:   aws.Int64(22),
+			ToPort:     aws.Int64(22),
+			IpProtocol: aws.String("tcp"),
+			IpRanges:   []*awsec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}},
+		},
+	}
+
+	expected := &DocDBAccess{Cidrs: []string{"10.1.0.0/16"}, SecurityGroupIds: []string{"sg-app"}}
+	if access := accessFromPermissions(permissions); !reflect.DeepEqual(access, expected) {
+		t.Errorf("expected %+v, got %+v", expected, access)
+	}
+}
+
+func TestDocumentDBAccessUpdate(t *testing.T) {
+	ec2Mock := &mockEC2Client{
+		groups: []*awsec2.SecurityGroup{
+			{
+				GroupId:   aws.String("sg-managed"),
+				GroupName: aws.String("spinup-localdev-docdb-mydocdb"),
+				Tags:      []*awsec2.Tag{{Key: aws.String("spinup:org"), Value: aws.String("localdev")}},
+				IpPermissions: []*awsec2.IpPermission{
+					{
+						FromPort:   aws.Int64(27017),
+						ToPort:     aws.Int64(27017),
+						IpProtocol: aws.String("tcp"),
+						IpRanges:   []*awsec2.IpRange{{CidrIp: aws.String("10.1.0.0/16")}, {CidrIp: aws.String("10.2.0.0/16")}},
+					},
+				},
+			},
+			{
+				GroupId:   aws.String("sg-other"),
+				GroupName: aws.String("spinup-otherorg-docdb-other"),
+			},
+		},
+	}
+
+	docdbMock := &mockDocDBClient{
+		clusters: []*awsdocdb.DBCluster{
+			{
+				DBClusterArn:        aws.String("arn:aws:rds:us-east-1:123456789012:cluster:mydocdb"),
+				DBClusterIdentifier: aws.String("mydocdb"),
+				VpcSecurityGroups:   []*awsdocdb.VpcSecurityGroupMembership{{VpcSecurityGroupId: aws.String("sg-managed")}},
+			},
+			{
+				DBClusterArn:        aws.String("arn:aws:rds:us-east-1:123456789012:cluster:other"),
+				DBClusterIdentifier: aws.String("other"),
+				VpcSecurityGroups:   []*awsdocdb.VpcSecurityGroupMembership{{VpcSecurityGroupId: aws.String("sg-other")}},
+			},
+		},
+		tags: map[string][]*awsdocdb.Tag{
+			"arn:aws:rds:us-east-1:123456789012:cluster:mydocdb": orgTags("localdev"),
+			"arn:aws:rds:us-east-1:123456789012:cluster:other":   orgTags("localdev"),
+		},
+	}
+
+	o := &docDBOrchestrator{
+		server:      &server{org: "localdev", store: newMemoryStore()},
+		sp:          &sessionParams{role: "arn:aws:iam::123456789012:role/SpinupRole", region: "us-east-1"},
+		docdbClient: docdb.DocDB{Service: docdbMock},
+		ec2Client:   &ec2.EC2{Service: ec2Mock},
+	}
+
+	resp, err := o.documentDBAccessUpdate(context.TODO(), "mydocdb", &DocDBAccess{
+		Cidrs:            []string{"10.2.0.0/16", "10.3.0.0/16"},
+		SecurityGroupIds: []string{"sg-app"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := &DocDBAccessResponse{
+		SecurityGroupId:  "sg-managed",
+		Cidrs:            []string{"10.2.0.0/16", "10.3.0.0/16"},
+		SecurityGroupIds: []string{"sg-app"},
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Errorf("expected %+v, got %+v", expected, resp)
+	}
+
+	if len(ec2Mock.revoked) != 1 || len(ec2Mock.revoked[0].IpRanges) != 1 || aws.StringValue(ec2Mock.revoked[0].IpRanges[0].CidrIp) != "10.1.0.0/16" {
+		t.Errorf("expected 10.1.0.0/16 to be revoked, got %+v", ec2Mock.revoked)
+	}
+
+	if len(ec2Mock.authorized) != 1 || len(ec2Mock.authorized[0].IpRanges) != 1 || aws.StringValue(ec2Mock.authorized[0].IpRanges[0].CidrIp) != "10.3.0.0/16" ||
+		len(ec2Mock.authorized[0].UserIdGroupPairs) != 1 {
+		t.Errorf("expected 10.3.0.0/16 and sg-app to be authorized, got %+v", ec2Mock.authorized)
+	}
+
+	// a cluster without a security group managed by the api in this org isn't found
+	if _, err := o.documentDBAccess(context.TODO(), "other"); !isNotFound(err) {
+		t.Errorf("expected not found for unmanaged security group, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/gorilla/mux"
+)
+
+// accountSettings are the settings used to manage an account, the global account configuration
+// with the overrides of the account applied
+type accountSettings struct {
+	id                     string
+	roleName               string
+	externalID             string
+	region                 string
+	defaultKMSKeyId        string
+	defaultSubnets         []string
+	defaultSecurityGroups  []string
+	subnetTags             map[string]string
+	securityGroupTags      map[string]string
+	allowedInstanceClasses []string
+	maxClusters            int
+	maxInstances           int
+	maxVCPU                int
+}
+
+// accountSettings returns the settings of the account.  if account overrides are configured, only
+// the configured accounts are managed and other accounts aren't found.
+func (s *server) accountSettings(account string) (*accountSettings, error) {
+	settings := &accountSettings{
+		id:         account,
+		roleName:   s.session.RoleName,
+		externalID: s.session.ExternalID,
+		region:     s.defaultRegion,
+
+		defaultSubnets:        s.accountDefaults.DefaultSubnets,
+		defaultSecurityGroups: s.accountDefaults.DefaultSecurityGroups,
+		subnetTags:            s.accountDefaults.SubnetTags,
+		securityGroupTags:     s.accountDefaults.SecurityGroupTags,
+
+		allowedInstanceClasses: s.quotas.AllowedInstanceClasses,
+		maxClusters:            s.quotas.MaxClusters,
+		maxInstances:           s.quotas.MaxInstances,
+		maxVCPU:                s.quotas.MaxVCPU,
+	}
+
+	if len(s.accounts) == 0 {
+		return settings, nil
+	}
+
+	override, ok := s.accounts[account]
+	if !ok {
+		msg := fmt.Sprintf("account %s not found", account)
+		return nil, apierror.New(apierror.ErrNotFound, msg, nil)
+	}
+
+	if override.Role != "" {
+		settings.roleName = override.Role
+	}
+
+	if override.ExternalID != "" {
+		settings.externalID = override.ExternalID
+	}
+
+	if override.Region != "" {
+		settings.region = override.Region
+	}
+
+	if len(override.DefaultSubnets) > 0 {
+		settings.defaultSubnets = override.DefaultSubnets
+	}
+
+	if len(override.DefaultSecurityGroups) > 0 {
+		settings.defaultSecurityGroups = override.DefaultSecurityGroups
+	}
+
+	if len(override.SubnetTags) > 0 {
+		settings.subnetTags = override.SubnetTags
+	}
+
+	if len(override.SecurityGroupTags) > 0 {
+		settings.securityGroupTags = override.SecurityGroupTags
+	}
+
+	if len(override.AllowedInstanceClasses) > 0 {
+		settings.allowedInstanceClasses = override.AllowedInstanceClasses
+	}
+
+	if override.MaxClusters > 0 {
+		settings.maxClusters = override.MaxClusters
+	}
+
+	if override.MaxInstances > 0 {
+		settings.maxInstances = override.MaxInstances
+	}
+
+	if override.MaxVCPU > 0 {
+		settings.maxVCPU = override.MaxVCPU
+	}
+
+	settings.defaultKMSKeyId = override.DefaultKMSKeyId
+
+	return settings, nil
+}
+
+// roleArn returns the arn of the role assumed to manage the account
+func (a *accountSettings) roleArn() string {
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", a.id, a.roleName)
+}
+
+// instanceClassAllowed returns true if the instance class can be used in the account
+func (a *accountSettings) instanceClassAllowed(class string) bool {
+	if len(a.allowedInstanceClasses) == 0 {
+		return true
+	}
+
+	for _, c := range a.allowedInstanceClasses {
+		if c == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyCreateDefaults sets the default subnets and security groups of the account on the create request
+// if none are given, and validates the instance class
+func (a *accountSettings) applyCreateDefaults(req *DocDBCreateRequest) error {
+	if len(req.SubnetIds) == 0 && len(a.defaultSubnets) > 0 {
+		req.SubnetIds = a.defaultSubnets
+	}
+
+	if len(req.VpcSecurityGroupIds) == 0 && len(a.defaultSecurityGroups) > 0 {
+		req.VpcSecurityGroupIds = aws.StringSlice(a.defaultSecurityGroups)
+	}
+
+	return a.checkInstanceClass(aws.StringValue(req.DBInstanceClass))
+}
+
+// roleArn returns the arn of the role assumed to manage the account
+func (s *server) roleArn(account string) string {
+	settings, err := s.accountSettings(account)
+	if err != nil {
+		return fmt.Sprintf("arn:aws:iam::%s:role/%s", account, s.session.RoleName)
+	}
+	return settings.roleArn()
+}
+
+// accountFromRole returns the account id of the role arn
+func accountFromRole(role string) string {
+	a, err := arn.Parse(role)
+	if err != nil {
+		return ""
+	}
+	return a.AccountID
+}
+
+// accountMiddleware rejects requests for accounts that aren't managed by the API
+func (s *server) accountMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if account, ok := mux.Vars(r)["account"]; ok {
+			if _, err := s.accountSettings(account); err != nil {
+				handleError(w, err)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
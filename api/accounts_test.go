@@ -0,0 +1,189 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/YaleSpinup/docdb-api/session"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/gorilla/mux"
+)
+
+func TestAccountSettings(t *testing.T) {
+	s := &server{
+		session:       session.Session{RoleName: "SpinupRole", ExternalID: "global-id"},
+		defaultRegion: "us-east-1",
+	}
+
+	// without overrides every account uses the global configuration
+	settings, err := s.accountSettings("123456789012")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if settings.roleArn() != "arn:aws:iam::123456789012:role/SpinupRole" || settings.externalID != "global-id" || settings.region != "us-east-1" {
+		t.Errorf("expected global settings, got %+v", settings)
+	}
+
+	s.accounts = map[string]common.AccountOverride{
+		"123456789012": {},
+		"000000000000": {
+			Role:                   "OtherRole",
+			ExternalID:             "other-id",
+			Region:                 "us-west-2",
+			DefaultKMSKeyId:        "other-key",
+			AllowedInstanceClasses: []string{"db.t3.medium"},
+		},
+	}
+
+	settings, err = s.accountSettings("123456789012")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if settings.roleArn() != "arn:aws:iam::123456789012:role/SpinupRole" || settings.externalID != "global-id" || settings.region != "us-east-1" {
+		t.Errorf("expected global settings for account without overrides, got %+v", settings)
+	}
+
+	settings, err = s.accountSettings("000000000000")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := &accountSettings{
+		id:                     "000000000000",
+		roleName:               "OtherRole",
+		externalID:             "other-id",
+		region:                 "us-west-2",
+		defaultKMSKeyId:        "other-key",
+		allowedInstanceClasses: []string{"db.t3.medium"},
+	}
+
+	if !reflect.DeepEqual(settings, expected) {
+		t.Errorf("expected %+v, got %+v", expected, settings)
+	}
+
+	if s.roleArn("000000000000") != "arn:aws:iam::000000000000:role/OtherRole" {
+		t.Errorf("unexpected role arn %s", s.roleArn("000000000000"))
+	}
+
+	if _, err := s.accountSettings("111111111111"); err == nil {
+		t.Error("expected error for unknown account, got nil")
+	}
+}
+
+func TestApplyCreateDefaults(t *testing.T) {
+	settings := &accountSettings{
+		id:                     "123456789012",
+		defaultSubnets:         []string{"subnet-1", "subnet-2"},
+		defaultSecurityGroups:  []string{"sg-1"},
+		allowedInstanceClasses: []string{"db.t3.medium", "db.r5.large"},
+	}
+
+	req := &DocDBCreateRequest{DBInstanceClass: aws.String("db.t3.medium")}
+	if err := settings.applyCreateDefaults(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(req.SubnetIds, []string{"subnet-1", "subnet-2"}) || !reflect.DeepEqual(aws.StringValueSlice(req.VpcSecurityGroupIds), []string{"sg-1"}) {
+		t.Errorf("expected default subnets and security groups, got %v, %v", req.SubnetIds, aws.StringValueSlice(req.VpcSecurityGroupIds))
+	}
+
+	// empty lists are the same as none given
+	req = &DocDBCreateRequest{DBInstanceClass: aws.String("db.t3.medium"), SubnetIds: []string{}, VpcSecurityGroupIds: []*string{}}
+	if err := settings.applyCreateDefaults(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(req.SubnetIds, []string{"subnet-1", "subnet-2"}) || !reflect.DeepEqual(aws.StringValueSlice(req.VpcSecurityGroupIds), []string{"sg-1"}) {
+		t.Errorf("expected default subnets and security groups for empty lists, got %v, %v", req.SubnetIds, aws.StringValueSlice(req.VpcSecurityGroupIds))
+	}
+
+	req = &DocDBCreateRequest{
+		DBInstanceClass:     aws.String("db.r5.large"),
+		SubnetIds:           []string{"subnet-a", "subnet-b"},
+		VpcSecurityGroupIds: aws.StringSlice([]string{"sg-a"}),
+	}
+	if err := settings.applyCreateDefaults(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(req.SubnetIds, []string{"subnet-a", "subnet-b"}) || !reflect.DeepEqual(aws.StringValueSlice(req.VpcSecurityGroupIds), []string{"sg-a"}) {
+		t.Errorf("expected given subnets and security groups, got %v, %v", req.SubnetIds, aws.StringValueSlice(req.VpcSecurityGroupIds))
+	}
+
+	if err := settings.applyCreateDefaults(&DocDBCreateRequest{DBInstanceClass: aws.String("db.r5.24xlarge")}); err == nil {
+		t.Error("expected error for instance class not allowed, got nil")
+	}
+
+	if err := (&accountSettings{}).applyCreateDefaults(&DocDBCreateRequest{DBInstanceClass: aws.String("db.r5.24xlarge")}); err != nil {
+		t.Errorf("expected any instance class to be allowed without a list, got %s", err)
+	}
+}
+
+func TestAccountMiddleware(t *testing.T) {
+	s := &server{
+		accounts: map[string]common.AccountOverride{"123456789012": {}},
+	}
+
+	router := mux.NewRouter()
+	router.Use(s.accountMiddleware)
+	router.HandleFunc("/v1/docdb/ping", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/v1/docdb/{account}", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := map[string]int{
+		"/v1/docdb/ping":         http.StatusOK,
+		"/v1/docdb/123456789012": http.StatusOK,
+		"/v1/docdb/111111111111": http.StatusNotFound,
+	}
+
+	for url, status := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+
+		if w.Code != status {
+			t.Errorf("%s: expected status %d, got %d", url, status, w.Code)
+		}
+	}
+}
+
+func TestAccountSettingsNetworking(t *testing.T) {
+	s := &server{
+		accountDefaults: common.Account{
+			DefaultSubnets: []string{"subnet-1", "subnet-2"},
+			SubnetTags:     map[string]string{"tier": "database"},
+		},
+		accounts: map[string]common.AccountOverride{
+			"123456789012": {},
+			"000000000000": {
+				DefaultSubnets:    []string{"subnet-a", "subnet-b"},
+				SecurityGroupTags: map[string]string{"tier": "other"},
+			},
+		},
+	}
+
+	settings, err := s.accountSettings("123456789012")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(settings.defaultSubnets, []string{"subnet-1", "subnet-2"}) || !reflect.DeepEqual(settings.subnetTags, map[string]string{"tier": "database"}) {
+		t.Errorf("expected global networking, got %+v", settings)
+	}
+
+	settings, err = s.accountSettings("000000000000")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(settings.defaultSubnets, []string{"subnet-a", "subnet-b"}) || !reflect.DeepEqual(settings.securityGroupTags, map[string]string{"tier": "other"}) {
+		t.Errorf("expected account networking, got %+v", settings)
+	}
+
+	if !reflect.DeepEqual(settings.subnetTags, map[string]string{"tier": "database"}) {
+		t.Errorf("expected global subnet tags, got %v", settings.subnetTags)
+	}
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	// auditWebhookTimeout bounds posting an audit event to the webhook sink
+	auditWebhookTimeout = 5 * time.Second
+	// auditQueueSize is how many audit events can wait for the webhook sink before new events are dropped
+	auditQueueSize = 1000
+	// redacted replaces secrets in audited request payloads
+	redacted = "REDACTED"
+)
+
+// auditEvent is the record of a mutating request
+type auditEvent struct {
+	Time      time.Time       `json:"time"`
+	RequestID string          `json:"requestId"`
+	Caller    string          `json:"caller"`
+	Operation string          `json:"operation"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Account   string          `json:"account,omitempty"`
+	Region    string          `json:"region,omitempty"`
+	Cluster   string          `json:"cluster,omitempty"`
+	Request   json.RawMessage `json:"request,omitempty"`
+	Status    int             `json:"status"`
+	Result    string          `json:"result"`
+	Error     string          `json:"error,omitempty"`
+	TaskID    string          `json:"taskId,omitempty"`
+	DryRun    bool            `json:"dryRun,omitempty"`
+}
+
+// auditSink receives audit events
+type auditSink interface {
+	Write(ctx context.Context, event *auditEvent) error
+}
+
+// newAuditSink returns the configured audit sink, or nil if auditing is disabled
+func newAuditSink(config common.Audit) (auditSink, error) {
+	switch strings.ToLower(config.Sink) {
+	case "":
+		return nil, nil
+	case "stdout":
+		return &writerSink{w: os.Stdout}, nil
+	case "file":
+		if config.Path == "" {
+			return nil, errors.New("audit file sink requires a path")
+		}
+
+		f, err := os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open audit file")
+		}
+
+		return &writerSink{w: f}, nil
+	case "webhook":
+		if config.URL == "" {
+			return nil, errors.New("audit webhook sink requires a url")
+		}
+
+		// the webhook is posted in the background, so a slow endpoint doesn't hold up responses
+		return newQueuedSink(&webhookSink{
+			url:     config.URL,
+			headers: config.Headers,
+			client:  &http.Client{Timeout: auditWebhookTimeout},
+		}, auditQueueSize), nil
+	}
+
+	return nil, fmt.Errorf("unknown audit sink %q", config.Sink)
+}
+
+// writerSink writes audit events as JSON lines, to stdout or a file
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerSink) Write(ctx context.Context, event *auditEvent) error {
+	j, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(j, '\n'))
+	return err
+}
+
+// webhookSink posts audit events as JSON to a URL
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (s *webhookSink) Write(ctx context.Context, event *auditEvent) error {
+	j, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d from audit webhook", res.StatusCode)
+	}
+
+	return nil
+}
+
+// queuedAuditEvent is an audit event waiting for the sink, with the context of its request
+type queuedAuditEvent struct {
+	ctx   context.Context
+	event *auditEvent
+}
+
+// queuedSink writes audit events to a sink in the background.  events are dropped when the queue is full.
+type queuedSink struct {
+	sink    auditSink
+	queue   chan queuedAuditEvent
+	stopped chan struct{}
+}
+
+func newQueuedSink(sink auditSink, size int) *queuedSink {
+	q := &queuedSink{
+		sink:    sink,
+		queue:   make(chan queuedAuditEvent, size),
+		stopped: make(chan struct{}),
+	}
+
+	go q.run()
+
+	return q
+}
+
+// Write queues the event, or returns an error if the queue is full and the event is dropped
+func (q *queuedSink) Write(ctx context.Context, event *auditEvent) error {
+	select {
+	case q.queue <- queuedAuditEvent{ctx: ctx, event: event}:
+		return nil
+	default:
+		return fmt.Errorf("audit queue is full, dropped %s event of request %s", event.Operation, event.RequestID)
+	}
+}
+
+// run writes the queued events to the sink until the queue is closed
+func (q *queuedSink) run() {
+	defer close(q.stopped)
+
+	for e := range q.queue {
+		if err := q.sink.Write(e.ctx, e.event); err != nil {
+			common.Logger(e.ctx).Errorf("failed to write audit event: %s", err)
+		}
+	}
+}
+
+// Close stops accepting events and waits until the queued events are written, or the context is done
+func (q *queuedSink) Close(ctx context.Context) error {
+	close(q.queue)
+
+	select {
+	case <-q.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d audit events weren't written: %w", len(q.queue), ctx.Err())
+	}
+}
+
+// redact returns the JSON payload with the values of secret fields replaced, or nil if it's not JSON
+func redact(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+
+	j, err := json.Marshal(redactValue(payload))
+	if err != nil {
+		return nil
+	}
+
+	return j
+}
+
+// redactValue replaces the values of secret fields in the decoded JSON value
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if isSecretField(k) {
+				t[k] = redacted
+				continue
+			}
+			t[k] = redactValue(val)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redactValue(val)
+		}
+	}
+	return v
+}
+
+// isSecretField returns true if the field name looks like it holds a secret
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"password", "secret", "token"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditRecorder is an http.ResponseWriter that keeps the status code and the body of error responses
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code and writes it to the underlying response writer
+func (r *auditRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write keeps the body of error responses and writes it to the underlying response writer
+func (r *auditRecorder) Write(p []byte) (int, error) {
+	if r.status >= 400 {
+		r.body.Write(p)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying response writer
+func (r *auditRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// audited records the request in the audit log after it's handled
+func (s *server) audited(op string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+			body = b
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		vars := mux.Vars(r)
+		event := &auditEvent{
+			Time:      time.Now().UTC(),
+			RequestID: common.RequestID(r.Context()),
+			Caller:    "psk",
+			Operation: op,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Account:   vars["account"],
+			Region:    regionFromContext(r.Context()),
+			Cluster:   vars["name"],
+			Request:   redact(body),
+			Status:    rec.status,
+			Result:    "success",
+			TaskID:    rec.Header().Get("X-Flywheel-Task"),
+			DryRun:    isDryRun(r.Context()),
+		}
+
+		if token := tokenFromContext(r.Context()); token != nil {
+			event.Caller = token.Name
+		}
+
+		if rec.status >= 400 {
+			event.Result = "failure"
+
+			resp := ErrorResponse{}
+			if err := json.Unmarshal(rec.body.Bytes(), &resp); err == nil {
+				event.Error = resp.Message
+			}
+		}
+
+		if err := s.audit.Write(context.WithoutCancel(r.Context()), event); err != nil {
+			common.Logger(r.Context()).Errorf("failed to write audit event: %s", err)
+		}
+	})
+}
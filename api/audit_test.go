@@ -0,0 +1,267 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/gorilla/mux"
+)
+
+// memorySink keeps audit events in memory
+type memorySink struct {
+	events []*auditEvent
+}
+
+func (m *memorySink) Write(ctx context.Context, event *auditEvent) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+func TestRedact(t *testing.T) {
+	body := `{"DBClusterIdentifier":"mydocdb","MasterUserPassword":"supersecret","Nested":{"clientSecret":"x","Tags":[{"Key":"a","Value":"b"}]},"Items":[{"api_token":"y"}]}`
+
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(redact([]byte(body)), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got["DBClusterIdentifier"] != "mydocdb" || got["MasterUserPassword"] != redacted {
+		t.Errorf("expected password to be redacted, got %v", got)
+	}
+
+	nested := got["Nested"].(map[string]interface{})
+	if nested["clientSecret"] != redacted || nested["Tags"] == nil {
+		t.Errorf("expected nested secret to be redacted, got %v", nested)
+	}
+
+	if item := got["Items"].([]interface{})[0].(map[string]interface{}); item["api_token"] != redacted {
+		t.Errorf("expected secret in list to be redacted, got %v", item)
+	}
+
+	if redact([]byte("not json")) != nil || redact(nil) != nil {
+		t.Error("expected nil for a payload that isn't json")
+	}
+}
+
+func TestNewAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	tests := []struct {
+		config  common.Audit
+		wantNil bool
+		wantErr bool
+	}{
+		{config: common.Audit{}, wantNil: true},
+		{config: common.Audit{Sink: "stdout"}},
+		{config: common.Audit{Sink: "file", Path: path}},
+		{config: common.Audit{Sink: "file"}, wantErr: true},
+		{config: common.Audit{Sink: "webhook", URL: "http://127.0.0.1/audit"}},
+		{config: common.Audit{Sink: "webhook"}, wantErr: true},
+		{config: common.Audit{Sink: "syslog"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		sink, err := newAuditSink(tt.config)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%+v: expected error, got nil", tt.config)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", tt.config, err)
+		}
+
+		if (sink == nil) != tt.wantNil {
+			t.Errorf("%+v: expected nil sink %t, got %+v", tt.config, tt.wantNil, sink)
+		}
+	}
+
+	// the file sink appends json lines
+	sink, _ := newAuditSink(common.Audit{Sink: "file", Path: path})
+	for _, op := range []string{opCreate, opDelete} {
+		if err := sink.Write(context.TODO(), &auditEvent{Operation: op}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], `"operation":"delete"`) {
+		t.Errorf("expected 2 json lines, got %q", string(b))
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got auditEvent
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	sink, err := newAuditSink(common.Audit{Sink: "webhook", URL: ts.URL, Headers: map[string]string{"Authorization": "Bearer abc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the webhook is posted in the background
+	q, ok := sink.(*queuedSink)
+	if !ok {
+		t.Fatalf("expected a queued sink, got %T", sink)
+	}
+
+	if err := sink.Write(context.TODO(), &auditEvent{Operation: opPower, Cluster: "mydocdb"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := q.Close(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got.Operation != opPower || got.Cluster != "mydocdb" || auth != "Bearer abc" {
+		t.Errorf("unexpected event %+v with authorization %q", got, auth)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	sink, _ = newAuditSink(common.Audit{Sink: "webhook", URL: failing.URL})
+	if err := sink.(*queuedSink).sink.Write(context.TODO(), &auditEvent{}); err == nil {
+		t.Error("expected error for failing webhook, got nil")
+	}
+}
+
+// blockingSink keeps audit events in memory once it's unblocked
+type blockingSink struct {
+	memorySink
+	unblock chan struct{}
+}
+
+func (b *blockingSink) Write(ctx context.Context, event *auditEvent) error {
+	<-b.unblock
+	return b.memorySink.Write(ctx, event)
+}
+
+func TestQueuedSink(t *testing.T) {
+	sink := &blockingSink{unblock: make(chan struct{})}
+	q := newQueuedSink(sink, 1)
+
+	// the first event is taken by the writer, which is blocked
+	if err := q.Write(context.TODO(), &auditEvent{Operation: opCreate}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the second event waits in the queue, without blocking the caller
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := q.Write(context.TODO(), &auditEvent{Operation: opModify})
+		if err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the third event is dropped
+	if err := q.Write(context.TODO(), &auditEvent{Operation: opDelete, RequestID: "abc"}); err == nil || !strings.Contains(err.Error(), "dropped delete event of request abc") {
+		t.Errorf("expected error for a full queue, got %v", err)
+	}
+
+	// closing times out while the sink is blocked
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := q.Close(ctx); err == nil {
+		t.Error("expected error closing a blocked queue, got nil")
+	}
+
+	close(sink.unblock)
+	<-q.stopped
+
+	if len(sink.events) != 2 || sink.events[0].Operation != opCreate || sink.events[1].Operation != opModify {
+		t.Errorf("expected the queued events to be written, got %+v", sink.events)
+	}
+}
+
+func TestAudited(t *testing.T) {
+	sink := &memorySink{}
+	s := &server{audit: sink}
+
+	router := mux.NewRouter()
+	router.Handle("/v1/docdb/{account}", s.audited(opCreate, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the handler can still read the body
+		req := DocDBCreateRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			handleError(w, apierror.New(apierror.ErrBadRequest, "bad body", err))
+			return
+		}
+
+		if aws := req.DBClusterIdentifier; aws == nil || *aws != "mydocdb" {
+			handleError(w, apierror.New(apierror.ErrBadRequest, "missing identifier", nil))
+			return
+		}
+
+		w.Header().Set("X-Flywheel-Task", "task-1")
+		w.WriteHeader(http.StatusAccepted)
+	}))).Methods(http.MethodPost)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/docdb/123456789012", bytes.NewBufferString(`{"DBClusterIdentifier":"mydocdb","MasterUserPassword":"supersecret"}`))
+	req = req.WithContext(withToken(common.WithRequestID(req.Context(), "req-1"), &common.APIToken{Name: "portal"}))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, rr.Code)
+	}
+
+	if len(sink.events) != 1 {
+		t.Fatalf("expected 1 audit event, got %d", len(sink.events))
+	}
+
+	event := sink.events[0]
+	if event.Caller != "portal" || event.Operation != opCreate || event.Account != "123456789012" || event.RequestID != "req-1" ||
+		event.TaskID != "task-1" || event.Result != "success" || event.Status != http.StatusAccepted {
+		t.Errorf("unexpected audit event %+v", event)
+	}
+
+	if strings.Contains(string(event.Request), "supersecret") || !strings.Contains(string(event.Request), redacted) {
+		t.Errorf("expected password to be redacted, got %s", event.Request)
+	}
+
+	// failures are audited with the error message
+	req = httptest.NewRequest(http.MethodPost, "/v1/docdb/123456789012", bytes.NewBufferString(`{}`))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(sink.events) != 2 {
+		t.Fatalf("expected 2 audit events, got %d", len(sink.events))
+	}
+
+	event = sink.events[1]
+	if event.Caller != "psk" || event.Result != "failure" || event.Status != http.StatusBadRequest || event.Error != "missing identifier" {
+		t.Errorf("unexpected audit event %+v", event)
+	}
+}
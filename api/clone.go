@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/YaleSpinup/flywheel"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/docdb"
+	"github.com/pkg/errors"
+)
+
+const (
+	// cloneRestorePointInTime clones a cluster at a point in time, sharing its storage until either changes it
+	cloneRestorePointInTime = "pointInTime"
+	// cloneRestoreSnapshot clones a cluster from its latest snapshot
+	cloneRestoreSnapshot = "snapshot"
+	// clonedFromTag is the tag of a clone naming its source cluster
+	clonedFromTag = "spinup:clonedFrom"
+)
+
+// validate returns an error if the clone request is invalid, and sets the default restore type
+func (req *DocDBCloneRequest) validate() error {
+	if aws.StringValue(req.DBClusterIdentifier) == "" {
+		return apierror.New(apierror.ErrBadRequest, "DBClusterIdentifier is required", nil)
+	}
+
+	if req.RestoreType == nil {
+		req.RestoreType = aws.String(cloneRestorePointInTime)
+	}
+
+	switch *req.RestoreType {
+	case cloneRestorePointInTime:
+	case cloneRestoreSnapshot:
+		if req.RestoreToTime != nil {
+			return apierror.New(apierror.ErrBadRequest, "RestoreToTime can only be set with RestoreType pointInTime", nil)
+		}
+	default:
+		msg := fmt.Sprintf("unknown RestoreType %q, must be %s or %s", *req.RestoreType, cloneRestorePointInTime, cloneRestoreSnapshot)
+		return apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	if req.InstanceCount != nil && *req.InstanceCount < 1 {
+		return apierror.New(apierror.ErrBadRequest, "InstanceCount must be at least 1", nil)
+	}
+
+	return nil
+}
+
+// cloneTags returns the tags of a clone, the tags of its source and the name of the source in the cloned from tag
+func cloneTags(source Tags, name, org string) Tags {
+	tags := Tags{}
+	for _, t := range source {
+		if t.Key != clonedFromTag {
+			tags = append(tags, t)
+		}
+	}
+	tags = append(tags, Tag{Key: clonedFromTag, Value: name})
+
+	return tags.normalize(org)
+}
+
+// documentDBClone creates a new cluster from a point in time or the latest snapshot of an existing cluster, with
+// the tags of the source and, unless overridden, its instance class and count.  when the source has a dedicated
+// security group, the clone gets its own with the same access.
+func (o *docDBOrchestrator) documentDBClone(ctx context.Context, name string, req *DocDBCloneRequest) (*DocDBResponse, *flywheel.Task, error) {
+	if name == "" || req == nil {
+		return nil, nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	if err := req.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	cl := aws.StringValue(req.DBClusterIdentifier)
+	ctx = common.WithLogger(ctx, common.Logger(ctx).WithField("cluster", cl))
+	common.Logger(ctx).Infof("cloning documentDB cluster %s to %s from %s", name, cl, aws.StringValue(req.RestoreType))
+
+	task := flywheel.NewTask()
+
+	// lock the clone until the clone task is done
+	lock := clusterLock{ID: task.ID, Operation: operationClone, Task: true}
+	if err := o.server.lockCluster(ctx, o.account(), o.sp.region, cl, lock, taskStateTTL); err != nil {
+		return nil, nil, err
+	}
+
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+
+		if err := o.server.unlockCluster(ctx, o.account(), o.sp.region, cl, task.ID); err != nil {
+			common.Logger(ctx).Errorf("failed to unlock docdb cluster %s: %s", cl, err)
+		}
+	}()
+
+	source, sourceTags, err := o.orgCluster(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instances, err := o.docdbClient.GetDocDBInstances(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	class := aws.StringValue(req.DBInstanceClass)
+	if class == "" && len(instances) > 0 {
+		class = aws.StringValue(instances[0].DBInstanceClass)
+	}
+
+	count := len(instances)
+	if req.InstanceCount != nil {
+		count = *req.InstanceCount
+	}
+
+	if class == "" || count < 1 {
+		msg := fmt.Sprintf("docdb cluster %s doesn't have any instances, DBInstanceClass and InstanceCount are required", name)
+		return nil, nil, apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	if err := o.settings.checkInstanceClass(class); err != nil {
+		return nil, nil, err
+	}
+
+	if err := o.checkCreateQuotas(ctx, &DocDBCreateRequest{DBInstanceClass: aws.String(class), InstanceCount: aws.Int(count)}); err != nil {
+		return nil, nil, err
+	}
+
+	tags := cloneTags(sourceTags, name, o.server.org)
+
+	group, err := o.clusterSecurityGroup(ctx, source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the dedicated security group of the source is deleted with it, so it's replaced by a group of the clone
+	securityGroupIds := []*string{}
+	for _, g := range source.VpcSecurityGroups {
+		if group == nil || aws.StringValue(g.VpcSecurityGroupId) != aws.StringValue(group.GroupId) {
+			securityGroupIds = append(securityGroupIds, g.VpcSecurityGroupId)
+		}
+	}
+
+	securityGroupId := ""
+	if group != nil {
+		securityGroupId, err = o.createClusterSecurityGroup(ctx, cl, aws.StringValue(group.VpcId), tags, accessFromPermissions(group.IpPermissions))
+		if err != nil {
+			return nil, nil, err
+		}
+		securityGroupIds = append(securityGroupIds, aws.String(securityGroupId))
+	}
+
+	cluster, err := o.restoreClone(ctx, source, req, tags, securityGroupIds)
+	if err != nil {
+		if securityGroupId != "" {
+			o.deleteClusterSecurityGroup(ctx, securityGroupId)
+		}
+		return nil, nil, err
+	}
+
+	allDBInstances, err := o.createInstances(ctx, cl, aws.String(class), count, tags)
+	if err != nil {
+		rolledBack, rerr := o.rollBackCreate(ctx, task, "clone", cl, allDBInstances, securityGroupId)
+		if rerr != nil {
+			msg := fmt.Sprintf("failed to create the instances of docdb cluster %s (%s) and to roll it back, the restored cluster has to be deleted: %s", cl, err, rerr)
+			return nil, nil, apierror.New(apierror.ErrInternalError, msg, err)
+		}
+
+		err = errors.Wrapf(err, "failed to create the instances of docdb cluster %s, rolled back the restored cluster", cl)
+		if !rolledBack {
+			return nil, nil, err
+		}
+
+		// the rollback task keeps the clone locked until its security group is deleted, it's returned with
+		// the error so the rollback can be followed
+		started = true
+		common.Logger(ctx).Infof("docdb cluster %s stays locked by %s until it's rolled back", cl, lock)
+		return nil, task, err
+	}
+
+	// start the async orchestration to wait for the clone to become available
+	o.runTask(ctx, task, &taskState{
+		Operation: operationClone,
+		Cluster:   cl,
+		Step:      stepWaitForAvailable,
+	}, func(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+		msgChan <- fmt.Sprintf("requested clone of docdb cluster %s to %s", name, cl)
+		return createWaitForAvailable(ctx, o, state, msgChan)
+	})
+	started = true
+
+	return &DocDBResponse{
+		Cluster:   cluster,
+		Instances: allDBInstances,
+		Tags:      tags,
+	}, task, nil
+}
+
+// restoreClone creates the cluster of the clone from the latest snapshot of the source, or as a copy-on-write
+// clone of the source at a point in time
+func (o *docDBOrchestrator) restoreClone(ctx context.Context, source *docdb.DBCluster, req *DocDBCloneRequest, tags Tags, securityGroupIds []*string) (*docdb.DBCluster, error) {
+	if aws.StringValue(req.RestoreType) == cloneRestoreSnapshot {
+		snapshot, err := o.docdbClient.GetLatestDBClusterSnapshot(ctx, aws.StringValue(source.DBClusterIdentifier))
+		if err != nil {
+			return nil, err
+		}
+
+		return o.docdbClient.RestoreDBClusterFromSnapshot(ctx, &docdb.RestoreDBClusterFromSnapshotInput{
+			DBClusterIdentifier: req.DBClusterIdentifier,
+			DBSubnetGroupName:   source.DBSubnetGroup,
+			Engine:              aws.String("docdb"),
+			EngineVersion:       snapshot.EngineVersion,
+			SnapshotIdentifier:  snapshot.DBClusterSnapshotIdentifier,
+			Tags:                tags.toDocDBTags(),
+			VpcSecurityGroupIds: securityGroupIds,
+		})
+	}
+
+	input := &docdb.RestoreDBClusterToPointInTimeInput{
+		DBClusterIdentifier:       req.DBClusterIdentifier,
+		DBSubnetGroupName:         source.DBSubnetGroup,
+		RestoreType:               aws.String("copy-on-write"),
+		SourceDBClusterIdentifier: source.DBClusterIdentifier,
+		Tags:                      tags.toDocDBTags(),
+		VpcSecurityGroupIds:       securityGroupIds,
+	}
+
+	if req.RestoreToTime != nil {
+		input.RestoreToTime = req.RestoreToTime
+	} else {
+		input.UseLatestRestorableTime = aws.Bool(true)
+	}
+
+	return o.docdbClient.RestoreDBClusterToPointInTime(ctx, input)
+}
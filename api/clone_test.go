@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/docdb"
+	"github.com/YaleSpinup/docdb-api/ec2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awsdocdb "github.com/aws/aws-sdk-go/service/docdb"
+	awsec2 "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func (m *mockDocDBClient) DescribeDBInstancesWithContext(ctx context.Context, input *awsdocdb.DescribeDBInstancesInput, opts ...request.Option) (*awsdocdb.DescribeDBInstancesOutput, error) {
+	cluster := aws.StringValue(input.Filters[0].Values[0])
+
+	out := []*awsdocdb.DBInstance{}
+	for _, i := range m.instances {
+		if aws.StringValue(i.DBClusterIdentifier) == cluster {
+			out = append(out, i)
+		}
+	}
+	return &awsdocdb.DescribeDBInstancesOutput{DBInstances: out}, nil
+}
+
+func (m *mockDocDBClient) DescribeDBClusterSnapshotsPagesWithContext(ctx context.Context, input *awsdocdb.DescribeDBClusterSnapshotsInput, fn func(*awsdocdb.DescribeDBClusterSnapshotsOutput, bool) bool, opts ...request.Option) error {
+	fn(&awsdocdb.DescribeDBClusterSnapshotsOutput{DBClusterSnapshots: m.snapshots}, true)
+	return nil
+}
+
+func TestDocDBCloneRequestValidate(t *testing.T) {
+	req := &DocDBCloneRequest{DBClusterIdentifier: aws.String("myclone")}
+	if err := req.validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if aws.StringValue(req.RestoreType) != cloneRestorePointInTime {
+		t.Errorf("expected default restore type %s, got %s", cloneRestorePointInTime, aws.StringValue(req.RestoreType))
+	}
+
+	for _, req := range []*DocDBCloneRequest{
+		{},
+		{DBClusterIdentifier: aws.String("myclone"), RestoreType: aws.String("full-copy")},
+		{DBClusterIdentifier: aws.String("myclone"), RestoreType: aws.String(cloneRestoreSnapshot), RestoreToTime: aws.Time(time.Now())},
+		{DBClusterIdentifier: aws.String("myclone"), InstanceCount: aws.Int(0)},
+	} {
+		if err := req.validate(); !isBadRequest(err) {
+			t.Errorf("expected bad request for %+v, got %v", req, err)
+		}
+	}
+}
+
+func TestCloneTags(t *testing.T) {
+	tags := cloneTags(Tags{
+		{Key: "spinup:org", Value: "localdev"},
+		{Key: "spinup:spaceid", Value: "0001"},
+		{Key: clonedFromTag, Value: "original"},
+	}, "mydocdb", "localdev")
+
+	clonedFrom := []string{}
+	for _, t := range tags {
+		if t.Key == clonedFromTag {
+			clonedFrom = append(clonedFrom, t.Value)
+		}
+	}
+
+	if !tags.inOrg("localdev") || len(clonedFrom) != 1 || clonedFrom[0] != "mydocdb" || len(tags) != 5 {
+		t.Errorf("unexpected clone tags %+v", tags)
+	}
+}
+
+func TestDocumentDBCloneDryRun(t *testing.T) {
+	s := &server{org: "localdev", store: newMemoryStore()}
+	o := &docDBOrchestrator{
+		server:   s,
+		sp:       &sessionParams{role: "arn:aws:iam::123456789012:role/SpinupRole", region: "us-east-1"},
+		settings: &accountSettings{},
+		docdbClient: docdb.DocDB{Service: &mockDocDBClient{
+			clusters: []*awsdocdb.DBCluster{
+				{
+					DBClusterArn:        aws.String("arn:aws:rds:us-east-1:123456789012:cluster:mydocdb"),
+					DBClusterIdentifier: aws.String("mydocdb"),
+					DBSubnetGroup:       aws.String("spinup-localdev-docdb-sg-0123"),
+					VpcSecurityGroups: []*awsdocdb.VpcSecurityGroupMembership{
+						{VpcSecurityGroupId: aws.String("sg-shared")},
+						{VpcSecurityGroupId: aws.String("sg-managed")},
+					},
+				},
+			},
+			instances: []*awsdocdb.DBInstance{
+				{DBClusterIdentifier: aws.String("mydocdb"), DBInstanceClass: aws.String("db.r5.large")},
+				{DBClusterIdentifier: aws.String("mydocdb"), DBInstanceClass: aws.String("db.r5.large")},
+			},
+			tags: map[string][]*awsdocdb.Tag{
+				"arn:aws:rds:us-east-1:123456789012:cluster:mydocdb": orgTags("localdev"),
+			},
+			snapshots: []*awsdocdb.DBClusterSnapshot{
+				{DBClusterSnapshotIdentifier: aws.String("rds:mydocdb-2026-10-18"), Status: aws.String("available"), SnapshotCreateTime: aws.Time(time.Now())},
+			},
+		}},
+		ec2Client: &ec2.EC2{Service: &mockEC2Client{
+			groups: []*awsec2.SecurityGroup{
+				{GroupId: aws.String("sg-shared"), GroupName: aws.String("shared")},
+				{
+					GroupId:   aws.String("sg-managed"),
+					GroupName: aws.String(managedSecurityGroupName("localdev", "mydocdb")),
+					VpcId:     aws.String("vpc-0123"),
+					Tags:      []*awsec2.Tag{{Key: aws.String("spinup:org"), Value: aws.String("localdev")}},
+					IpPermissions: []*awsec2.IpPermission{
+						{
+							FromPort:   aws.Int64(27017),
+							ToPort:     aws.Int64(27017),
+							IpProtocol: aws.String("tcp"),
+							IpRanges:   []*awsec2.IpRange{{CidrIp: aws.String("10.1.0.0/16")}},
+						},
+					},
+				},
+			},
+		}},
+	}
+	ctx := withDryRun(context.TODO())
+
+	operations := func() []string {
+		ops := []string{}
+		for _, c := range o.plan.response().Calls {
+			ops = append(ops, c.Operation)
+		}
+		return ops
+	}
+
+	// copy-on-write clone with the instances of the source
+	o.plan = &dryRunPlan{}
+	o.dryRunClients()
+
+	resp, _, err := o.documentDBClone(ctx, "mydocdb", &DocDBCloneRequest{DBClusterIdentifier: aws.String("myclone")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "CreateSecurityGroup,AuthorizeSecurityGroupIngress,RestoreDBClusterToPointInTime,CreateDBInstance,CreateDBInstance,StartTask"
+	if ops := strings.Join(operations(), ","); ops != expected {
+		t.Errorf("expected planned calls %s, got %s", expected, ops)
+	}
+
+	restore := struct {
+		RestoreType               string
+		SourceDBClusterIdentifier string
+		UseLatestRestorableTime   bool
+		VpcSecurityGroupIds       []string
+		Tags                      []*awsdocdb.Tag
+	}{}
+	if err := json.Unmarshal(o.plan.response().Calls[2].Input, &restore); err != nil {
+		t.Fatal(err)
+	}
+
+	if restore.RestoreType != "copy-on-write" || restore.SourceDBClusterIdentifier != "mydocdb" || !restore.UseLatestRestorableTime ||
+		strings.Join(restore.VpcSecurityGroupIds, ",") != "sg-shared,"+dryRunSecurityGroupID {
+		t.Errorf("unexpected restore input %+v", restore)
+	}
+
+	if !resp.Tags.inOrg("localdev") || !strings.Contains(string(o.plan.response().Calls[2].Input), `"Key":"spinup:clonedFrom","Value":"mydocdb"`) {
+		t.Errorf("expected the clone to be tagged with its source, got %+v", resp.Tags)
+	}
+
+	// clone from the latest snapshot with overridden instances
+	o.plan = &dryRunPlan{}
+	o.dryRunClients()
+
+	if _, _, err := o.documentDBClone(ctx, "mydocdb", &DocDBCloneRequest{
+		DBClusterIdentifier: aws.String("myclone"),
+		RestoreType:         aws.String(cloneRestoreSnapshot),
+		DBInstanceClass:     aws.String("db.t3.medium"),
+		InstanceCount:       aws.Int(1),
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	calls := o.plan.response().Calls
+	if len(calls) != 5 || calls[2].Operation != "RestoreDBClusterFromSnapshot" || !strings.Contains(string(calls[2].Input), "rds:mydocdb-2026-10-18") ||
+		!strings.Contains(string(calls[3].Input), "db.t3.medium") {
+		j, _ := json.Marshal(calls)
+		t.Errorf("unexpected planned calls %s", j)
+	}
+
+	// only clusters of our org can be cloned, and instance classes must be allowed
+	if _, _, err := o.documentDBClone(ctx, "missing", &DocDBCloneRequest{DBClusterIdentifier: aws.String("myclone")}); !isNotFound(err) {
+		t.Errorf("expected not found for a missing source, got %v", err)
+	}
+
+	o.settings = &accountSettings{allowedInstanceClasses: []string{"db.t3.medium"}}
+	if _, _, err := o.documentDBClone(ctx, "mydocdb", &DocDBCloneRequest{DBClusterIdentifier: aws.String("myclone")}); !isLimitExceeded(err) {
+		t.Errorf("expected the instance class of the source not to be allowed, got %v", err)
+	}
+}
+
+// failingInstancesDocDBClient is a mock docdb client creating and restoring clusters and failing to create more
+// than one instance, recording the deletions
+type failingInstancesDocDBClient struct {
+	*mockDocDBClient
+	instancesCreated int
+	deleted          []string
+	failDelete       bool
+}
+
+func (f *failingInstancesDocDBClient) DescribeDBSubnetGroupsWithContext(ctx context.Context, input *awsdocdb.DescribeDBSubnetGroupsInput, opts ...request.Option) (*awsdocdb.DescribeDBSubnetGroupsOutput, error) {
+	return &awsdocdb.DescribeDBSubnetGroupsOutput{DBSubnetGroups: []*awsdocdb.DBSubnetGroup{{DBSubnetGroupName: input.DBSubnetGroupName}}}, nil
+}
+
+func (f *failingInstancesDocDBClient) CreateDBClusterWithContext(ctx context.Context, input *awsdocdb.CreateDBClusterInput, opts ...request.Option) (*awsdocdb.CreateDBClusterOutput, error) {
+	return &awsdocdb.CreateDBClusterOutput{DBCluster: &awsdocdb.DBCluster{DBClusterIdentifier: input.DBClusterIdentifier}}, nil
+}
+
+func (f *failingInstancesDocDBClient) RestoreDBClusterToPointInTimeWithContext(ctx context.Context, input *awsdocdb.RestoreDBClusterToPointInTimeInput, opts ...request.Option) (*awsdocdb.RestoreDBClusterToPointInTimeOutput, error) {
+	return &awsdocdb.RestoreDBClusterToPointInTimeOutput{DBCluster: &awsdocdb.DBCluster{DBClusterIdentifier: input.DBClusterIdentifier}}, nil
+}
+
+func (f *failingInstancesDocDBClient) CreateDBInstanceWithContext(ctx context.Context, input *awsdocdb.CreateDBInstanceInput, opts ...request.Option) (*awsdocdb.CreateDBInstanceOutput, error) {
+	if f.instancesCreated > 0 {
+		return nil, awserr.New(awsdocdb.ErrCodeInstanceQuotaExceededFault, "instance quota exceeded", nil)
+	}
+
+	f.instancesCreated++
+	return &awsdocdb.CreateDBInstanceOutput{DBInstance: &awsdocdb.DBInstance{DBInstanceIdentifier: input.DBInstanceIdentifier}}, nil
+}
+
+func (f *failingInstancesDocDBClient) DeleteDBInstanceWithContext(ctx context.Context, input *awsdocdb.DeleteDBInstanceInput, opts ...request.Option) (*awsdocdb.DeleteDBInstanceOutput, error) {
+	f.deleted = append(f.deleted, aws.StringValue(input.DBInstanceIdentifier))
+	return &awsdocdb.DeleteDBInstanceOutput{}, nil
+}
+
+func (f *failingInstancesDocDBClient) DeleteDBClusterWithContext(ctx context.Context, input *awsdocdb.DeleteDBClusterInput, opts ...request.Option) (*awsdocdb.DeleteDBClusterOutput, error) {
+	if f.failDelete {
+		return nil, awserr.New(awsdocdb.ErrCodeInvalidDBClusterStateFault, "cluster is not available", nil)
+	}
+
+	f.deleted = append(f.deleted, aws.StringValue(input.DBClusterIdentifier))
+	return &awsdocdb.DeleteDBClusterOutput{}, nil
+}
+
+func TestDocumentDBCloneRollback(t *testing.T) {
+	docdbMock := &failingInstancesDocDBClient{mockDocDBClient: &mockDocDBClient{
+		clusters: []*awsdocdb.DBCluster{
+			{
+				DBClusterArn:        aws.String("arn:aws:rds:us-east-1:123456789012:cluster:mydocdb"),
+				DBClusterIdentifier: aws.String("mydocdb"),
+			},
+		},
+		instances: []*awsdocdb.DBInstance{
+			{DBClusterIdentifier: aws.String("mydocdb"), DBInstanceClass: aws.String("db.r5.large")},
+			{DBClusterIdentifier: aws.String("mydocdb"), DBInstanceClass: aws.String("db.r5.large")},
+		},
+		tags: map[string][]*awsdocdb.Tag{
+			"arn:aws:rds:us-east-1:123456789012:cluster:mydocdb": orgTags("localdev"),
+		},
+	}}
+
+	s := &server{org: "localdev", store: newMemoryStore()}
+	o := &docDBOrchestrator{
+		server:      s,
+		sp:          &sessionParams{role: "arn:aws:iam::123456789012:role/SpinupRole", region: "us-east-1"},
+		settings:    &accountSettings{},
+		docdbClient: docdb.DocDB{Service: docdbMock},
+		ec2Client:   &ec2.EC2{Service: &mockEC2Client{}},
+	}
+
+	// the created instance and the restored cluster are deleted
+	if _, _, err := o.documentDBClone(context.TODO(), "mydocdb", &DocDBCloneRequest{DBClusterIdentifier: aws.String("myclone")}); err == nil {
+		t.Fatal("expected error creating the instances of the clone, got nil")
+	}
+
+	if strings.Join(docdbMock.deleted, ",") != "myclone-1,myclone" {
+		t.Errorf("expected the instance and the cluster of the clone to be deleted, got %v", docdbMock.deleted)
+	}
+
+	if holder, _ := s.clusterLockHolder(context.TODO(), "123456789012", "us-east-1", "myclone"); holder != nil {
+		t.Errorf("expected the clone to be unlocked, got %s", holder)
+	}
+
+	// a failed rollback names the restored cluster left behind
+	docdbMock.instancesCreated, docdbMock.deleted, docdbMock.failDelete = 0, nil, true
+
+	_, _, err := o.documentDBClone(context.TODO(), "mydocdb", &DocDBCloneRequest{DBClusterIdentifier: aws.String("myclone")})
+	if err == nil || !strings.Contains(err.Error(), "myclone") || !strings.Contains(err.Error(), "has to be deleted") {
+		t.Errorf("expected error naming the restored cluster, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/docdb"
+	"github.com/aws/aws-sdk-go/service/docdb/docdbiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+const (
+	// dryRunQueryParam is the query parameter previewing a mutating request without changing anything
+	dryRunQueryParam = "dryrun"
+	// dryRunSecurityGroupID stands in for the id of a security group created in a dry run
+	dryRunSecurityGroupID = "sg-dryrun"
+)
+
+type dryRunContextKey struct{}
+
+// withDryRun returns a copy of the context marking the request as a dry run
+func withDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunContextKey{}, true)
+}
+
+// isDryRun returns true if the context belongs to a dry run request
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunContextKey{}).(bool)
+	return dryRun
+}
+
+// dryRunMiddleware marks requests with the dryrun query parameter as dry runs in the request context
+func dryRunMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get(dryRunQueryParam)
+		if v == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			handleError(w, apierror.New(apierror.ErrBadRequest, "dryrun must be true or false", err))
+			return
+		}
+
+		if !dryRun {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(withDryRun(r.Context())))
+	})
+}
+
+// dryRunPlan collects the calls that a dry run would make
+type dryRunPlan struct {
+	mu    sync.Mutex
+	calls []*DocDBPlannedCall
+}
+
+// record adds the call with its input to the plan, with the values of secret fields redacted
+func (p *dryRunPlan) record(service, operation string, input interface{}) {
+	call := &DocDBPlannedCall{Service: service, Operation: operation, Input: plannedInput(input)}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, call)
+}
+
+// plannedInput returns the input of a planned call as JSON without the unset fields, with the values of secret
+// fields redacted
+func plannedInput(input interface{}) json.RawMessage {
+	j, err := json.Marshal(input)
+	if err != nil {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(j, &v); err != nil {
+		return nil
+	}
+
+	if j, err = json.Marshal(dropNulls(v)); err != nil {
+		return nil
+	}
+
+	return redact(j)
+}
+
+// dropNulls removes the null fields from the decoded JSON value
+func dropNulls(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if val == nil {
+				delete(t, k)
+				continue
+			}
+			t[k] = dropNulls(val)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = dropNulls(val)
+		}
+	}
+	return v
+}
+
+// response returns the plan as the response of the dry run
+func (p *dryRunPlan) response() *DocDBDryRunResponse {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &DocDBDryRunResponse{
+		DryRun: true,
+		Calls:  append([]*DocDBPlannedCall{}, p.calls...),
+	}
+}
+
+// writeDryRun writes the plan of the dry run as the response
+func writeDryRun(w http.ResponseWriter, plan *dryRunPlan) {
+	j, err := json.Marshal(plan.response())
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// dryRunDocDB is a docdb client recording the calls that change anything instead of making them, other
+// calls are made with the underlying client
+type dryRunDocDB struct {
+	docdbiface.DocDBAPI
+	plan *dryRunPlan
+}
+
+func (d *dryRunDocDB) CreateDBClusterWithContext(ctx context.Context, input *docdb.CreateDBClusterInput, opts ...request.Option) (*docdb.CreateDBClusterOutput, error) {
+	d.plan.record("docdb", "CreateDBCluster", input)
+	return &docdb.CreateDBClusterOutput{DBCluster: &docdb.DBCluster{
+		DBClusterIdentifier: input.DBClusterIdentifier,
+		EngineVersion:       input.EngineVersion,
+		Status:              aws.String("creating"),
+	}}, nil
+}
+
+func (d *dryRunDocDB) CreateDBInstanceWithContext(ctx context.Context, input *docdb.CreateDBInstanceInput, opts ...request.Option) (*docdb.CreateDBInstanceOutput, error) {
+	d.plan.record("docdb", "CreateDBInstance", input)
+	return &docdb.CreateDBInstanceOutput{DBInstance: &docdb.DBInstance{
+		DBClusterIdentifier:  input.DBClusterIdentifier,
+		DBInstanceClass:      input.DBInstanceClass,
+		DBInstanceIdentifier: input.DBInstanceIdentifier,
+		DBInstanceStatus:     aws.String("creating"),
+	}}, nil
+}
+
+func (d *dryRunDocDB) CreateDBSubnetGroupWithContext(ctx context.Context, input *docdb.CreateDBSubnetGroupInput, opts ...request.Option) (*docdb.CreateDBSubnetGroupOutput, error) {
+	d.plan.record("docdb", "CreateDBSubnetGroup", input)
+	return &docdb.CreateDBSubnetGroupOutput{DBSubnetGroup: &docdb.DBSubnetGroup{
+		DBSubnetGroupName: input.DBSubnetGroupName,
+	}}, nil
+}
+
+func (d *dryRunDocDB) ModifyDBClusterWithContext(ctx context.Context, input *docdb.ModifyDBClusterInput, opts ...request.Option) (*docdb.ModifyDBClusterOutput, error) {
+	d.plan.record("docdb", "ModifyDBCluster", input)
+	return &docdb.ModifyDBClusterOutput{DBCluster: &docdb.DBCluster{
+		DBClusterIdentifier: input.DBClusterIdentifier,
+	}}, nil
+}
+
+func (d *dryRunDocDB) ModifyDBInstanceWithContext(ctx context.Context, input *docdb.ModifyDBInstanceInput, opts ...request.Option) (*docdb.ModifyDBInstanceOutput, error) {
+	d.plan.record("docdb", "ModifyDBInstance", input)
+	return &docdb.ModifyDBInstanceOutput{DBInstance: &docdb.DBInstance{
+		DBInstanceClass:      input.DBInstanceClass,
+		DBInstanceIdentifier: input.DBInstanceIdentifier,
+	}}, nil
+}
+
+func (d *dryRunDocDB) DeleteDBClusterWithContext(ctx context.Context, input *docdb.DeleteDBClusterInput, opts ...request.Option) (*docdb.DeleteDBClusterOutput, error) {
+	d.plan.record("docdb", "DeleteDBCluster", input)
+	return &docdb.DeleteDBClusterOutput{DBCluster: &docdb.DBCluster{
+		DBClusterIdentifier: input.DBClusterIdentifier,
+		Status:              aws.String("deleting"),
+	}}, nil
+}
+
+func (d *dryRunDocDB) DeleteDBInstanceWithContext(ctx context.Context, input *docdb.DeleteDBInstanceInput, opts ...request.Option) (*docdb.DeleteDBInstanceOutput, error) {
+	d.plan.record("docdb", "DeleteDBInstance", input)
+	return &docdb.DeleteDBInstanceOutput{DBInstance: &docdb.DBInstance{
+		DBInstanceIdentifier: input.DBInstanceIdentifier,
+		DBInstanceStatus:     aws.String("deleting"),
+	}}, nil
+}
+
+func (d *dryRunDocDB) RestoreDBClusterFromSnapshotWithContext(ctx context.Context, input *docdb.RestoreDBClusterFromSnapshotInput, opts ...request.Option) (*docdb.RestoreDBClusterFromSnapshotOutput, error) {
+	d.plan.record("docdb", "RestoreDBClusterFromSnapshot", input)
+	return &docdb.RestoreDBClusterFromSnapshotOutput{DBCluster: &docdb.DBCluster{
+		DBClusterIdentifier: input.DBClusterIdentifier,
+		EngineVersion:       input.EngineVersion,
+		Status:              aws.String("creating"),
+	}}, nil
+}
+
+func (d *dryRunDocDB) RestoreDBClusterToPointInTimeWithContext(ctx context.Context, input *docdb.RestoreDBClusterToPointInTimeInput, opts ...request.Option) (*docdb.RestoreDBClusterToPointInTimeOutput, error) {
+	d.plan.record("docdb", "RestoreDBClusterToPointInTime", input)
+	return &docdb.RestoreDBClusterToPointInTimeOutput{DBCluster: &docdb.DBCluster{
+		DBClusterIdentifier: input.DBClusterIdentifier,
+		Status:              aws.String("creating"),
+	}}, nil
+}
+
+func (d *dryRunDocDB) StartDBClusterWithContext(ctx context.Context, input *docdb.StartDBClusterInput, opts ...request.Option) (*docdb.StartDBClusterOutput, error) {
+	d.plan.record("docdb", "StartDBCluster", input)
+	return &docdb.StartDBClusterOutput{}, nil
+}
+
+func (d *dryRunDocDB) StopDBClusterWithContext(ctx context.Context, input *docdb.StopDBClusterInput, opts ...request.Option) (*docdb.StopDBClusterOutput, error) {
+	d.plan.record("docdb", "StopDBCluster", input)
+	return &docdb.StopDBClusterOutput{}, nil
+}
+
+// dryRunEC2 is an ec2 client recording the calls that change anything instead of making them, other calls
+// are made with the underlying client
+type dryRunEC2 struct {
+	ec2iface.EC2API
+	plan *dryRunPlan
+}
+
+func (e *dryRunEC2) CreateSecurityGroupWithContext(ctx context.Context, input *ec2.CreateSecurityGroupInput, opts ...request.Option) (*ec2.CreateSecurityGroupOutput, error) {
+	e.plan.record("ec2", "CreateSecurityGroup", input)
+	return &ec2.CreateSecurityGroupOutput{GroupId: aws.String(dryRunSecurityGroupID)}, nil
+}
+
+func (e *dryRunEC2) DeleteSecurityGroupWithContext(ctx context.Context, input *ec2.DeleteSecurityGroupInput, opts ...request.Option) (*ec2.DeleteSecurityGroupOutput, error) {
+	e.plan.record("ec2", "DeleteSecurityGroup", input)
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+func (e *dryRunEC2) AuthorizeSecurityGroupIngressWithContext(ctx context.Context, input *ec2.AuthorizeSecurityGroupIngressInput, opts ...request.Option) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	e.plan.record("ec2", "AuthorizeSecurityGroupIngress", input)
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (e *dryRunEC2) RevokeSecurityGroupIngressWithContext(ctx context.Context, input *ec2.RevokeSecurityGroupIngressInput, opts ...request.Option) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	e.plan.record("ec2", "RevokeSecurityGroupIngress", input)
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/docdb"
+	"github.com/YaleSpinup/docdb-api/ec2"
+	"github.com/aws/aws-sdk-go/aws"
+	awsdocdb "github.com/aws/aws-sdk-go/service/docdb"
+)
+
+func TestDryRunMiddleware(t *testing.T) {
+	var dryRun bool
+	h := dryRunMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dryRun = isDryRun(r.Context())
+	}))
+
+	tests := []struct {
+		query  string
+		status int
+		dryRun bool
+	}{
+		{"", http.StatusOK, false},
+		{"?dryrun=true", http.StatusOK, true},
+		{"?dryrun=false", http.StatusOK, false},
+		{"?dryrun=maybe", http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		dryRun = false
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/docdb/123456789012/mydocdb"+tt.query, nil))
+
+		if rr.Code != tt.status || dryRun != tt.dryRun {
+			t.Errorf("%q: expected status %d and dry run %t, got %d and %t", tt.query, tt.status, tt.dryRun, rr.Code, dryRun)
+		}
+	}
+}
+
+func TestDryRunPlan(t *testing.T) {
+	plan := &dryRunPlan{}
+	d := &dryRunDocDB{plan: plan}
+
+	out, err := d.CreateDBClusterWithContext(context.TODO(), &awsdocdb.CreateDBClusterInput{
+		DBClusterIdentifier: aws.String("mydocdb"),
+		MasterUsername:      aws.String("admin"),
+		MasterUserPassword:  aws.String("hunter22"),
+	})
+	if err != nil || aws.StringValue(out.DBCluster.DBClusterIdentifier) != "mydocdb" {
+		t.Fatalf("unexpected output %+v, %v", out, err)
+	}
+
+	resp := plan.response()
+	if !resp.DryRun || len(resp.Calls) != 1 || resp.Calls[0].Service != "docdb" || resp.Calls[0].Operation != "CreateDBCluster" {
+		t.Fatalf("unexpected plan %+v", resp)
+	}
+
+	input := string(resp.Calls[0].Input)
+	if strings.Contains(input, "hunter22") || !strings.Contains(input, "MasterUserPassword") || !strings.Contains(input, "admin") {
+		t.Errorf("expected only the password to be redacted, got %s", input)
+	}
+
+	if strings.Contains(input, "null") || strings.Contains(input, "EngineVersion") {
+		t.Errorf("expected unset fields to be dropped, got %s", input)
+	}
+}
+
+func TestDocumentDBModifyDryRun(t *testing.T) {
+	s := &server{org: "localdev", store: newMemoryStore()}
+	o := &docDBOrchestrator{
+		server:   s,
+		sp:       &sessionParams{role: "arn:aws:iam::123456789012:role/SpinupRole", region: "us-east-1"},
+		settings: &accountSettings{},
+		docdbClient: docdb.DocDB{Service: &mockDocDBClient{
+			clusters: []*awsdocdb.DBCluster{
+				{
+					DBClusterArn:        aws.String("arn:aws:rds:us-east-1:123456789012:cluster:mydocdb"),
+					DBClusterIdentifier: aws.String("mydocdb"),
+					DBClusterMembers: []*awsdocdb.DBClusterMember{
+						{DBInstanceIdentifier: aws.String("mydocdb-1")},
+						{DBInstanceIdentifier: aws.String("mydocdb-2")},
+					},
+				},
+			},
+			tags: map[string][]*awsdocdb.Tag{
+				"arn:aws:rds:us-east-1:123456789012:cluster:mydocdb": orgTags("localdev"),
+			},
+		}},
+		ec2Client: &ec2.EC2{Service: &mockEC2Client{}},
+		plan:      &dryRunPlan{},
+	}
+	o.dryRunClients()
+
+	ctx := withDryRun(context.TODO())
+	req := &DocDBModifyRequest{
+		DBInstanceClass:    aws.String("db.r5.large"),
+		MasterUserPassword: aws.String("hunter22"),
+	}
+
+	// a locked cluster fails the dry run
+	other := clusterLock{ID: "task-1", Operation: operationPower, Task: true}
+	if err := s.lockCluster(context.TODO(), "123456789012", "us-east-1", "mydocdb", other, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := o.documentDBModify(ctx, "mydocdb", req); !isConflict(err) {
+		t.Errorf("expected conflict for a locked cluster, got %v", err)
+	}
+
+	s.unlockCluster(context.TODO(), "123456789012", "us-east-1", "mydocdb", "task-1")
+
+	if _, err := o.documentDBModify(ctx, "mydocdb", req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	calls := o.plan.response().Calls
+	if len(calls) != 3 || calls[0].Operation != "ModifyDBCluster" || calls[1].Operation != "ModifyDBInstance" || calls[2].Operation != "ModifyDBInstance" {
+		j, _ := json.Marshal(calls)
+		t.Fatalf("unexpected planned calls %s", j)
+	}
+
+	if strings.Contains(string(calls[0].Input), "hunter22") {
+		t.Errorf("expected the password to be redacted, got %s", calls[0].Input)
+	}
+
+	// the dry run doesn't take the lock
+	if holder, _ := s.clusterLockHolder(context.TODO(), "123456789012", "us-east-1", "mydocdb"); holder != nil {
+		t.Errorf("expected the cluster not to be locked, got %s", holder)
+	}
+}
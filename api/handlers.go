@@ -18,9 +18,17 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/flywheel"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -51,27 +59,195 @@ func (s *server) VersionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-// handleError handles standard apierror return codes
+// WebhookDeliveriesHandler returns the delivery log of the notifications of a task
+func (s *server) WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+
+	task := r.URL.Query().Get("task")
+	if task == "" {
+		handleError(w, apierror.New(apierror.ErrBadRequest, "task is required", nil))
+		return
+	}
+
+	deliveries, err := s.webhookDeliveries(r.Context(), task)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "failed to get webhook deliveries"))
+		return
+	}
+
+	if !allowedInAccount(r.Context(), deliveries.Account, opRead) {
+		msg := fmt.Sprintf("not allowed to read the webhook deliveries of task %s", task)
+		handleError(w, apierror.New(apierror.ErrForbidden, msg, nil))
+		return
+	}
+
+	j, err := json.Marshal(deliveries.Deliveries)
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// TaskStreamHandler streams the progress messages and the final status of a task as server-sent events
+func (s *server) TaskStreamHandler(w http.ResponseWriter, r *http.Request) {
+	task := mux.Vars(r)["task"]
+
+	sub, ok, err := s.streams.follow(r.Context(), task)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "failed to get the events of the task"))
+		return
+	}
+
+	if !ok {
+		msg := fmt.Sprintf("task %s not found", task)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+	defer sub.stop()
+
+	if !allowedInAccount(r.Context(), sub.account, opRead) {
+		msg := fmt.Sprintf("not allowed to read the stream of task %s", task)
+		handleError(w, apierror.New(apierror.ErrForbidden, msg, nil))
+		return
+	}
+
+	// the stream outlives the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Debugf("unable to clear write deadline of task stream: %s", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// a reconnecting client only gets the events it missed
+	lastID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+
+	send := func(e taskEvent) error {
+		if e.ID <= lastID {
+			return nil
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	for _, e := range sub.history {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+
+	keepalive := time.NewTicker(taskStreamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.events:
+			if !ok {
+				return
+			}
+
+			if err := send(e); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.done():
+			return
+		}
+	}
+}
+
+// handleError handles standard apierror return codes and writes a JSON error response
 func handleError(w http.ResponseWriter, err error) {
-	log.Error(err.Error())
+	resp := ErrorResponse{
+		Code:      apierror.ErrInternalError,
+		Status:    http.StatusInternalServerError,
+		Message:   err.Error(),
+		RequestID: responseRequestID(w),
+	}
+
+	log.WithField("request_id", resp.RequestID).Error(err.Error())
+
 	if aerr, ok := errors.Cause(err).(apierror.Error); ok {
 		switch aerr.Code {
 		case apierror.ErrForbidden:
-			w.WriteHeader(http.StatusForbidden)
+			resp.Status = http.StatusForbidden
 		case apierror.ErrNotFound:
-			w.WriteHeader(http.StatusNotFound)
+			resp.Status = http.StatusNotFound
 		case apierror.ErrConflict:
-			w.WriteHeader(http.StatusConflict)
+			resp.Status = http.StatusConflict
 		case apierror.ErrBadRequest:
-			w.WriteHeader(http.StatusBadRequest)
+			resp.Status = http.StatusBadRequest
 		case apierror.ErrLimitExceeded:
-			w.WriteHeader(http.StatusTooManyRequests)
+			resp.Status = http.StatusTooManyRequests
+		case apierror.ErrServiceUnavailable:
+			resp.Status = http.StatusServiceUnavailable
 		default:
-			w.WriteHeader(http.StatusInternalServerError)
+			resp.Status = http.StatusInternalServerError
 		}
+
+		resp.Code = aerr.Code
+		// replace the coded apierror string with its plain message, keeping any wrapped context
+		resp.Message = strings.Replace(err.Error(), aerr.Error(), aerr.Message, 1)
+
+		var awsErr awserr.Error
+		if errors.As(aerr.OrigErr, &awsErr) {
+			resp.AWSCode = awsErr.Code()
+			resp.Message = fmt.Sprintf("%s: %s", resp.Message, awsErr.Message())
+		}
+	}
+
+	j, jerr := json.Marshal(resp)
+	if jerr != nil {
+		log.Errorf("failed to marshal error response: %s", jerr)
+		w.WriteHeader(resp.Status)
 		w.Write([]byte(err.Error()))
-	} else {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Status)
+	w.Write(j)
+}
+
+// handleTaskError handles the error of an operation that still started a task, like the rollback of a failed
+// create, and returns the task in the X-Flywheel-Task header so it can be followed
+func handleTaskError(w http.ResponseWriter, task *flywheel.Task, err error) {
+	if task != nil {
+		w.Header().Set("X-Flywheel-Task", task.ID)
+	}
+
+	handleError(w, err)
+}
+
+// responseRequestID returns the request id set in the response headers, generating one if it's missing
+func responseRequestID(w http.ResponseWriter) string {
+	id := w.Header().Get("X-Request-Id")
+	if id == "" {
+		id = uuid.New().String()
+		w.Header().Set("X-Request-Id", id)
 	}
+	return id
 }
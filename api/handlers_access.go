@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// DocumentDBAccessGetHandler returns the sources allowed to connect to a DocumentDB cluster
+func (s *server) DocumentDBAccessGetHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	name := vars["name"]
+
+	orch, err := s.newDocDBOrchestrator(
+		r.Context(),
+		&sessionParams{
+			role:       s.roleArn(account),
+			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
+		},
+	)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "unable to create docdb orchestrator"))
+		return
+	}
+
+	resp, err := orch.documentDBAccess(r.Context(), name)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// DocumentDBAccessUpdateHandler replaces the sources allowed to connect to a DocumentDB cluster
+func (s *server) DocumentDBAccessUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	name := vars["name"]
+
+	req := DocDBAccess{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		msg := fmt.Sprintf("cannot decode body into update access input: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	policy, err := generatePolicy(securityGroupActions)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	orch, err := s.newDocDBOrchestrator(
+		r.Context(),
+		&sessionParams{
+			role:         s.roleArn(account),
+			inlinePolicy: policy,
+			policyArns:   []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
+		},
+	)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "unable to create docdb orchestrator"))
+		return
+	}
+
+	resp, err := orch.documentDBAccessUpdate(r.Context(), name, &req)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if orch.plan != nil {
+		writeDryRun(w, orch.plan)
+		return
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
@@ -3,10 +3,13 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 )
@@ -17,29 +20,50 @@ func (s *server) DocumentDBCreateHandler(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	account := vars["account"]
 
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrBadRequest, "cannot read create documentdb input", err))
+		return
+	}
+
 	// read the input against our struct in api/types.go
 	req := DocDBCreateRequest{}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		msg := fmt.Sprintf("cannot decode body into create documentdb input: %s", err)
 		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
 		return
 	}
 
-	if req.SubnetIds == nil {
-		handleError(w, apierror.New(apierror.ErrBadRequest, "SubnetIds is a required field", nil))
+	settings, err := s.accountSettings(account)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if err := settings.applyCreateDefaults(&req); err != nil {
+		handleError(w, err)
 		return
 	}
 
-	if len(req.SubnetIds) < 2 {
+	// subnets can be discovered when none are given, but a given list must be usable
+	if len(req.SubnetIds) == 1 {
 		handleError(w, apierror.New(apierror.ErrBadRequest, "At least 2 SubnetIds are required", nil))
 		return
 	}
 
+	// allow managing the dedicated security group of the cluster
+	policy, err := generatePolicy(securityGroupActions)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
 	orch, err := s.newDocDBOrchestrator(
 		r.Context(),
 		&sessionParams{
-			role:       fmt.Sprintf("arn:aws:iam::%s:role/%s", account, s.session.RoleName),
-			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBFullAccess"},
+			role:         s.roleArn(account),
+			inlinePolicy: policy,
+			policyArns:   []string{"arn:aws:iam::aws:policy/AmazonDocDBFullAccess"},
 		},
 	)
 	if err != nil {
@@ -47,12 +71,125 @@ func (s *server) DocumentDBCreateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// a retried request with the same idempotency key gets the original response and task, dry runs
+	// aren't idempotent requests
+	idempotencyKey := ""
+	if s.store != nil && orch.plan == nil {
+		idempotencyKey = r.Header.Get(idempotencyKeyHeader)
+	}
+
+	if idempotencyKey != "" {
+		record, err := s.reserveIdempotencyKey(r.Context(), account, idempotencyKey, requestHash(body))
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		if record != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Flywheel-Task", record.TaskID)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write(record.Response)
+			return
+		}
+	}
+
 	resp, task, err := orch.documentDBCreate(r.Context(), &req)
 	if err != nil {
+		if idempotencyKey != "" {
+			if rerr := s.releaseIdempotencyKey(r.Context(), account, idempotencyKey); rerr != nil {
+				common.Logger(r.Context()).Errorf("failed to release idempotency key: %s", rerr)
+			}
+		}
+
+		handleTaskError(w, task, err)
+		return
+	}
+
+	if orch.plan != nil {
+		writeDryRun(w, orch.plan)
+		return
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		// the task was started, retries get the task without the response
+		if idempotencyKey != "" {
+			s.completeIdempotencyKey(r.Context(), account, idempotencyKey, &idempotencyRecord{
+				RequestHash: requestHash(body),
+				TaskID:      task.ID,
+			})
+		}
+
+		handleError(w, errors.Wrap(err, "unable to marshal response from the docdb service"))
+		return
+	}
+
+	if idempotencyKey != "" {
+		s.completeIdempotencyKey(r.Context(), account, idempotencyKey, &idempotencyRecord{
+			RequestHash: requestHash(body),
+			TaskID:      task.ID,
+			Response:    j,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Flywheel-Task", task.ID)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(j)
+}
+
+// DocumentDBCloneHandler clones a documentDB cluster into a new cluster and instance(s)
+func (s *server) DocumentDBCloneHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	name := vars["name"]
+
+	req := DocDBCloneRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		msg := fmt.Sprintf("cannot decode body into clone documentdb input: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	if err := req.validate(); err != nil {
 		handleError(w, err)
 		return
 	}
 
+	// allow managing the dedicated security group of the clone
+	policy, err := generatePolicy(securityGroupActions)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	orch, err := s.newDocDBOrchestrator(
+		r.Context(),
+		&sessionParams{
+			role:         s.roleArn(account),
+			inlinePolicy: policy,
+			policyArns:   []string{"arn:aws:iam::aws:policy/AmazonDocDBFullAccess"},
+		},
+	)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "unable to create docdb orchestrator"))
+		return
+	}
+
+	resp, task, err := orch.documentDBClone(r.Context(), name, &req)
+	if err != nil {
+		handleTaskError(w, task, err)
+		return
+	}
+
+	if orch.plan != nil {
+		writeDryRun(w, orch.plan)
+		return
+	}
+
 	j, err := json.Marshal(resp)
 	if err != nil {
 		handleError(w, errors.Wrap(err, "unable to marshal response from the docdb service"))
@@ -80,11 +217,19 @@ func (s *server) DocumentDBDeleteHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	// allow managing the dedicated security group of the cluster
+	policy, err := generatePolicy(securityGroupActions)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
 	orch, err := s.newDocDBOrchestrator(
 		r.Context(),
 		&sessionParams{
-			role:       fmt.Sprintf("arn:aws:iam::%s:role/%s", account, s.session.RoleName),
-			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBFullAccess"},
+			role:         s.roleArn(account),
+			inlinePolicy: policy,
+			policyArns:   []string{"arn:aws:iam::aws:policy/AmazonDocDBFullAccess"},
 		},
 	)
 	if err != nil {
@@ -92,11 +237,24 @@ func (s *server) DocumentDBDeleteHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := orch.documentDBDelete(r.Context(), name, snapshot); err != nil {
+	task, err := orch.documentDBDelete(r.Context(), name, snapshot)
+	if err != nil {
 		handleError(w, err)
 		return
 	}
 
+	if orch.plan != nil {
+		writeDryRun(w, orch.plan)
+		return
+	}
+
+	// the dedicated security group of the cluster is deleted in a task once the cluster is gone
+	if task != nil {
+		w.Header().Set("X-Flywheel-Task", task.ID)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -110,7 +268,7 @@ func (s *server) DocumentDBListHandler(w http.ResponseWriter, r *http.Request) {
 	orch, err := s.newDocDBOrchestrator(
 		r.Context(),
 		&sessionParams{
-			role: fmt.Sprintf("arn:aws:iam::%s:role/%s", account, s.session.RoleName),
+			role: s.roleArn(account),
 			policyArns: []string{
 				"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess",
 				"arn:aws:iam::aws:policy/ResourceGroupsandTagEditorReadOnlyAccess",
@@ -150,7 +308,7 @@ func (s *server) DocumentDBGetHandler(w http.ResponseWriter, r *http.Request) {
 	orch, err := s.newDocDBOrchestrator(
 		r.Context(),
 		&sessionParams{
-			role:       fmt.Sprintf("arn:aws:iam::%s:role/%s", account, s.session.RoleName),
+			role:       s.roleArn(account),
 			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
 		},
 	)
@@ -190,10 +348,23 @@ func (s *server) DocumentDBModifyHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	settings, err := s.accountSettings(account)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if req.DBInstanceClass != nil {
+		if err := settings.checkInstanceClass(*req.DBInstanceClass); err != nil {
+			handleError(w, err)
+			return
+		}
+	}
+
 	orch, err := s.newDocDBOrchestrator(
 		r.Context(),
 		&sessionParams{
-			role:       fmt.Sprintf("arn:aws:iam::%s:role/%s", account, s.session.RoleName),
+			role:       s.roleArn(account),
 			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBFullAccess"},
 		},
 	)
@@ -208,6 +379,11 @@ func (s *server) DocumentDBModifyHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if orch.plan != nil {
+		writeDryRun(w, orch.plan)
+		return
+	}
+
 	j, err := json.Marshal(resp)
 	if err != nil {
 		handleError(w, apierror.New(apierror.ErrBadRequest, "failed to marshal json", err))
@@ -219,6 +395,126 @@ func (s *server) DocumentDBModifyHandler(w http.ResponseWriter, r *http.Request)
 	w.Write(j)
 }
 
+// DocumentDBCreateEstimateHandler estimates the monthly cost of a documentDB create request
+func (s *server) DocumentDBCreateEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+
+	req := DocDBCreateEstimateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		msg := fmt.Sprintf("cannot decode body into estimate documentdb input: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	pricing, err := s.pricingTable()
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	settings, err := s.accountSettings(account)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if req.DBInstanceClass != nil {
+		if err := settings.checkInstanceClass(*req.DBInstanceClass); err != nil {
+			handleError(w, err)
+			return
+		}
+	}
+
+	estimate, err := pricing.createEstimate(regionFromContext(r.Context()), &req)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(&DocDBEstimateResponse{Estimate: estimate})
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// DocumentDBModifyEstimateHandler estimates the monthly cost of an existing documentDB cluster before and
+// after a modify request
+func (s *server) DocumentDBModifyEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	name := vars["name"]
+
+	req := DocDBModifyEstimateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		msg := fmt.Sprintf("cannot decode body into estimate documentdb input: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	pricing, err := s.pricingTable()
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	settings, err := s.accountSettings(account)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if req.DBInstanceClass != nil {
+		if err := settings.checkInstanceClass(*req.DBInstanceClass); err != nil {
+			handleError(w, err)
+			return
+		}
+	}
+
+	orch, err := s.newDocDBOrchestrator(
+		r.Context(),
+		&sessionParams{
+			role: s.roleArn(account),
+			policyArns: []string{
+				"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess",
+			},
+		},
+	)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "unable to create docdb orchestrator"))
+		return
+	}
+
+	cluster, err := orch.documentDBDetails(r.Context(), name)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	resp, err := pricing.modifyEstimate(regionFromContext(r.Context()), cluster, &req)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
 // DocumentDBStateHandler Starts/Stops a DocumentDB cluster and instance(s)
 func (s *server) DocumentDBStateHandler(w http.ResponseWriter, r *http.Request) {
 	w = LogWriter{w}
@@ -238,6 +534,11 @@ func (s *server) DocumentDBStateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if req.KeepStopped != nil && *req.KeepStopped && strings.ToLower(req.State) != "stop" {
+		handleError(w, apierror.New(apierror.ErrBadRequest, "keepStopped can only be set when stopping a cluster", nil))
+		return
+	}
+
 	policy, err := generatePolicy([]string{"rds:StartDBCluster", "rds:StopDBCluster"})
 	if err != nil {
 		handleError(w, err)
@@ -247,7 +548,7 @@ func (s *server) DocumentDBStateHandler(w http.ResponseWriter, r *http.Request)
 	orch, err := s.newDocDBOrchestrator(
 		r.Context(),
 		&sessionParams{
-			role:         fmt.Sprintf("arn:aws:iam::%s:role/%s", account, s.session.RoleName),
+			role:         s.roleArn(account),
 			inlinePolicy: policy,
 			policyArns:   []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
 		},
@@ -257,10 +558,66 @@ func (s *server) DocumentDBStateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := orch.docDBState(r.Context(), req.State, name); err != nil {
+	task, err := orch.docDBState(r.Context(), req.State, name)
+	if err != nil {
 		handleError(w, err)
 		return
 	}
 
+	if orch.plan != nil {
+		writeDryRun(w, orch.plan)
+		return
+	}
+
+	if req.KeepStopped != nil {
+		if *req.KeepStopped {
+			err = s.setKeepStopped(r.Context(), account, regionFromContext(r.Context()), name)
+		} else {
+			err = s.clearKeepStopped(r.Context(), account, regionFromContext(r.Context()), name)
+		}
+
+		if err != nil {
+			handleError(w, errors.Wrapf(err, "power change started in task %s, but failed to update keep stopped flag", task.ID))
+			return
+		}
+	}
+
+	w.Header().Set("X-Flywheel-Task", task.ID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// DocumentDBStateGetHandler gets the power state of a DocumentDB cluster and instance(s)
+func (s *server) DocumentDBStateGetHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	name := vars["name"]
+
+	orch, err := s.newDocDBOrchestrator(
+		r.Context(),
+		&sessionParams{
+			role:       s.roleArn(account),
+			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
+		},
+	)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "unable to create docdb orchestrator"))
+		return
+	}
+
+	resp, err := orch.documentDBPowerState(r.Context(), name)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write(j)
 }
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// DocumentDBScheduleCreateHandler creates a power schedule for a DocumentDB cluster
+func (s *server) DocumentDBScheduleCreateHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	name := vars["name"]
+
+	if isDryRun(r.Context()) {
+		handleError(w, apierror.New(apierror.ErrBadRequest, "dry run is not supported for power schedules", nil))
+		return
+	}
+
+	req := DocDBPowerScheduleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		msg := fmt.Sprintf("cannot decode body into create power schedule input: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	orch, err := s.newDocDBOrchestrator(
+		r.Context(),
+		&sessionParams{
+			role:       s.roleArn(account),
+			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
+		},
+	)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "unable to create docdb orchestrator"))
+		return
+	}
+
+	schedule, err := orch.documentDBScheduleCreate(r.Context(), name, &req)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(schedule)
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// DocumentDBScheduleListHandler lists the power schedules of a DocumentDB cluster
+func (s *server) DocumentDBScheduleListHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	name := vars["name"]
+
+	orch, err := s.newDocDBOrchestrator(
+		r.Context(),
+		&sessionParams{
+			role:       s.roleArn(account),
+			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
+		},
+	)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "unable to create docdb orchestrator"))
+		return
+	}
+
+	resp, err := orch.documentDBSchedules(r.Context(), name)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		handleError(w, apierror.New(apierror.ErrInternalError, "failed to marshal json", err))
+		return
+	}
+
+	w.Header().Set("X-Items", strconv.Itoa(len(resp)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// DocumentDBScheduleDeleteHandler deletes a power schedule of a DocumentDB cluster
+func (s *server) DocumentDBScheduleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	name := vars["name"]
+	id := vars["id"]
+
+	if isDryRun(r.Context()) {
+		handleError(w, apierror.New(apierror.ErrBadRequest, "dry run is not supported for power schedules", nil))
+		return
+	}
+
+	orch, err := s.newDocDBOrchestrator(
+		r.Context(),
+		&sessionParams{
+			role:       s.roleArn(account),
+			policyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
+		},
+	)
+	if err != nil {
+		handleError(w, errors.Wrap(err, "unable to create docdb orchestrator"))
+		return
+	}
+
+	if err := orch.documentDBScheduleDelete(r.Context(), name, id); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
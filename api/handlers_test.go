@@ -17,9 +17,18 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/flywheel"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/docdb"
+	pkgerrors "github.com/pkg/errors"
 )
 
 func TestPingHandler(t *testing.T) {
@@ -73,3 +82,114 @@ func TestVersionHandler(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+
+func TestHandleError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorResponse
+	}{
+		{
+			name: "plain error",
+			err:  errors.New("boom"),
+			want: ErrorResponse{
+				Code:    apierror.ErrInternalError,
+				Status:  http.StatusInternalServerError,
+				Message: "boom",
+			},
+		},
+		{
+			name: "apierror not found",
+			err:  apierror.New(apierror.ErrNotFound, "cluster not found in our org", nil),
+			want: ErrorResponse{
+				Code:    apierror.ErrNotFound,
+				Status:  http.StatusNotFound,
+				Message: "cluster not found in our org",
+			},
+		},
+		{
+			name: "wrapped apierror with aws error",
+			err: pkgerrors.Wrap(
+				apierror.New(apierror.ErrConflict, "failed to create cluster", awserr.New(docdb.ErrCodeDBClusterAlreadyExistsFault, "DB Cluster already exists", nil)),
+				"unable to create docdb cluster",
+			),
+			want: ErrorResponse{
+				Code:    apierror.ErrConflict,
+				Status:  http.StatusConflict,
+				Message: "unable to create docdb cluster: failed to create cluster: DB Cluster already exists",
+				AWSCode: docdb.ErrCodeDBClusterAlreadyExistsFault,
+			},
+		},
+		{
+			name: "limit exceeded",
+			err:  apierror.New(apierror.ErrLimitExceeded, "too many clusters", nil),
+			want: ErrorResponse{
+				Code:    apierror.ErrLimitExceeded,
+				Status:  http.StatusTooManyRequests,
+				Message: "too many clusters",
+			},
+		},
+		{
+			name: "service unavailable",
+			err:  apierror.New(apierror.ErrServiceUnavailable, "cost estimates are not configured", nil),
+			want: ErrorResponse{
+				Code:    apierror.ErrServiceUnavailable,
+				Status:  http.StatusServiceUnavailable,
+				Message: "cost estimates are not configured",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			rr.Header().Set("X-Request-Id", "abc-123")
+			tt.want.RequestID = "abc-123"
+
+			handleError(rr, tt.err)
+
+			if rr.Code != tt.want.Status {
+				t.Errorf("expected status %d, got %d", tt.want.Status, rr.Code)
+			}
+
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected content type application/json, got %s", ct)
+			}
+
+			got := ErrorResponse{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to unmarshal error response: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	// a request id is generated when one isn't set
+	rr := httptest.NewRecorder()
+	handleError(rr, errors.New("boom"))
+	if rr.Header().Get("X-Request-Id") == "" {
+		t.Error("expected a generated X-Request-Id header, got none")
+	}
+}
+
+func TestHandleTaskError(t *testing.T) {
+	// the task of a rollback is returned with the error
+	task := flywheel.NewTask()
+	rr := httptest.NewRecorder()
+	handleTaskError(rr, task, errors.New("failed to create the instances of docdb cluster mydocdb, rolled back the cluster"))
+
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("X-Flywheel-Task") != task.ID {
+		t.Errorf("expected status %d with task %s, got %d with task %q", http.StatusInternalServerError, task.ID, rr.Code, rr.Header().Get("X-Flywheel-Task"))
+	}
+
+	// without a task, it's a plain error
+	rr = httptest.NewRecorder()
+	handleTaskError(rr, nil, errors.New("boom"))
+
+	if rr.Header().Get("X-Flywheel-Task") != "" {
+		t.Errorf("expected no task, got %q", rr.Header().Get("X-Flywheel-Task"))
+	}
+}
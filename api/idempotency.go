@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+)
+
+const (
+	// idempotencyKeyHeader is the request header clients use to safely retry a create
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyPrefix is the store key prefix for idempotency records
+	idempotencyPrefix = "idempotency:"
+	// idempotencyTTL is how long an idempotency key is remembered
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength is the longest idempotency key accepted from a client
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyRecord is the stored result of a request made with an idempotency key.  while the request
+// is being processed, only the request hash is set.
+type idempotencyRecord struct {
+	RequestHash string
+	TaskID      string          `json:",omitempty"`
+	Response    json.RawMessage `json:",omitempty"`
+}
+
+// pending returns true if the original request hasn't finished yet
+func (i *idempotencyRecord) pending() bool {
+	return i.TaskID == "" && len(i.Response) == 0
+}
+
+// idempotencyStoreKey returns the store key for the idempotency key, scoped to the account
+func idempotencyStoreKey(account, key string) string {
+	return idempotencyPrefix + account + ":" + key
+}
+
+// requestHash returns the hash of a request body, used to detect a key reused with a different payload.
+// a JSON body is hashed in its canonical form, so retries differing only in whitespace or key order match.
+func requestHash(body []byte) string {
+	sum := sha256.Sum256(canonicalJSON(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalJSON returns the compact JSON encoding of the body with sorted object keys.  numbers are kept
+// as written.  a body that isn't valid JSON is returned as is.
+func canonicalJSON(body []byte) []byte {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil || d.More() {
+		return body
+	}
+
+	c, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+
+	return c
+}
+
+// reserveIdempotencyKey reserves the idempotency key for a new request.  if the key was already used,
+// the record of the original request is returned.  a key reused with a different payload, or while the
+// original request is still in progress, is a conflict.
+func (s *server) reserveIdempotencyKey(ctx context.Context, account, idempotencyKey, hash string) (*idempotencyRecord, error) {
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		msg := fmt.Sprintf("%s header can't be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+		return nil, apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	key := idempotencyStoreKey(account, idempotencyKey)
+	j, err := json.Marshal(idempotencyRecord{RequestHash: hash})
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to marshal idempotency record", err)
+	}
+
+	reserved, err := s.store.SetNX(ctx, key, string(j), idempotencyTTL)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrServiceUnavailable, "failed to reserve idempotency key", err)
+	}
+
+	if reserved {
+		return nil, nil
+	}
+
+	v, found, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrServiceUnavailable, "failed to get idempotency key", err)
+	}
+
+	// the key expired or was released in between, let the client retry
+	if !found {
+		return nil, apierror.New(apierror.ErrConflict, "request with the same idempotency key is in progress, retry later", nil)
+	}
+
+	record := &idempotencyRecord{}
+	if err := json.Unmarshal([]byte(v), record); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to unmarshal idempotency record", err)
+	}
+
+	if record.RequestHash != hash {
+		return nil, apierror.New(apierror.ErrConflict, "idempotency key was already used with a different request payload", nil)
+	}
+
+	if record.pending() {
+		return nil, apierror.New(apierror.ErrConflict, "request with the same idempotency key is in progress, retry later", nil)
+	}
+
+	return record, nil
+}
+
+// saveIdempotentResponse stores the result of the request made with the idempotency key
+func (s *server) saveIdempotentResponse(ctx context.Context, account, idempotencyKey string, record *idempotencyRecord) error {
+	j, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Set(ctx, idempotencyStoreKey(account, idempotencyKey), string(j), idempotencyTTL)
+}
+
+// completeIdempotencyKey saves the record of a request that started its task.  if the record can't be saved,
+// the key is released instead, so retries aren't rejected as in progress until the key expires.
+func (s *server) completeIdempotencyKey(ctx context.Context, account, idempotencyKey string, record *idempotencyRecord) {
+	err := s.saveIdempotentResponse(ctx, account, idempotencyKey, record)
+	if err == nil {
+		return
+	}
+
+	common.Logger(ctx).Errorf("failed to save idempotent response, releasing idempotency key: %s", err)
+
+	if err := s.releaseIdempotencyKey(ctx, account, idempotencyKey); err != nil {
+		common.Logger(ctx).Errorf("failed to release idempotency key: %s", err)
+	}
+}
+
+// releaseIdempotencyKey releases the idempotency key of a failed request, so it can be retried
+func (s *server) releaseIdempotencyKey(ctx context.Context, account, idempotencyKey string) error {
+	return s.store.Delete(ctx, idempotencyStoreKey(account, idempotencyKey))
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/pkg/errors"
+)
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore()}
+	account, key := "123456789012", "abc"
+	hash := requestHash([]byte(`{"DBClusterIdentifier":"mydocdb"}`))
+
+	// first request reserves the key
+	record, err := s.reserveIdempotencyKey(ctx, account, key, hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if record != nil {
+		t.Fatalf("expected nil record for a new key, got %+v", record)
+	}
+
+	// retry while the first request is in progress
+	if _, err := s.reserveIdempotencyKey(ctx, account, key, hash); !isConflict(err) {
+		t.Errorf("expected conflict for a pending key, got %v", err)
+	}
+
+	resp := json.RawMessage(`{"Cluster":{}}`)
+	if err := s.saveIdempotentResponse(ctx, account, key, &idempotencyRecord{RequestHash: hash, TaskID: "task-1", Response: resp}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// retry with the same payload replays the original response
+	record, err = s.reserveIdempotencyKey(ctx, account, key, hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if record == nil || record.TaskID != "task-1" || string(record.Response) != string(resp) {
+		t.Errorf("expected original record to be replayed, got %+v", record)
+	}
+
+	// retry with a different payload
+	if _, err := s.reserveIdempotencyKey(ctx, account, key, requestHash([]byte(`{}`))); !isConflict(err) {
+		t.Errorf("expected conflict for a mismatched payload, got %v", err)
+	}
+
+	// the same key in another account is a different request
+	if record, err := s.reserveIdempotencyKey(ctx, "000000000000", key, hash); err != nil || record != nil {
+		t.Errorf("expected new reservation in another account, got %+v, %v", record, err)
+	}
+
+	// a released key can be reused
+	if err := s.releaseIdempotencyKey(ctx, account, key); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if record, err := s.reserveIdempotencyKey(ctx, account, key, requestHash([]byte(`{}`))); err != nil || record != nil {
+		t.Errorf("expected new reservation of a released key, got %+v, %v", record, err)
+	}
+
+	// too long
+	long := make([]byte, maxIdempotencyKeyLength+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+
+	if _, err := s.reserveIdempotencyKey(ctx, account, string(long), hash); err == nil {
+		t.Error("expected error for a too long idempotency key, got nil")
+	}
+}
+
+func TestRequestHash(t *testing.T) {
+	hash := requestHash([]byte(`{"DBClusterIdentifier":"mydocdb","Instances":2,"Tags":[{"Key":"a","Value":"b"}]}`))
+
+	// whitespace and key order don't change the hash
+	same := []string{
+		`{"Instances":2,"DBClusterIdentifier":"mydocdb","Tags":[{"Value":"b","Key":"a"}]}`,
+		"{\n  \"DBClusterIdentifier\": \"mydocdb\",\n  \"Instances\": 2,\n  \"Tags\": [ { \"Key\": \"a\", \"Value\": \"b\" } ]\n}\n",
+	}
+
+	for _, body := range same {
+		if h := requestHash([]byte(body)); h != hash {
+			t.Errorf("expected the same hash for %q", body)
+		}
+	}
+
+	// different values, array order or number formatting do
+	different := []string{
+		`{"DBClusterIdentifier":"otherdocdb","Instances":2,"Tags":[{"Key":"a","Value":"b"}]}`,
+		`{"DBClusterIdentifier":"mydocdb","Instances":2.0,"Tags":[{"Key":"a","Value":"b"}]}`,
+		`{"DBClusterIdentifier":"mydocdb","Instances":2,"Tags":[{"Key":"a","Value":"b"},{"Key":"c","Value":"d"}]}`,
+		`{"DBClusterIdentifier":"mydocdb","Instances":2,"Tags":[{"Key":"a","Value":"b"}]} {}`,
+	}
+
+	for _, body := range different {
+		if h := requestHash([]byte(body)); h == hash {
+			t.Errorf("expected a different hash for %q", body)
+		}
+	}
+
+	// bodies that aren't JSON are hashed as is
+	if requestHash([]byte("not json")) == requestHash([]byte("not  json")) {
+		t.Error("expected different hashes for different invalid bodies")
+	}
+}
+
+// failingSetStore is a store whose Set always fails
+type failingSetStore struct {
+	kvStore
+}
+
+func (f failingSetStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errors.New("store is unavailable")
+}
+
+func TestCompleteIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore()}
+	account, key := "123456789012", "abc"
+	hash := requestHash([]byte(`{"DBClusterIdentifier":"mydocdb"}`))
+
+	if _, err := s.reserveIdempotencyKey(ctx, account, key, hash); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// a record with only the task is replayed
+	s.completeIdempotencyKey(ctx, account, key, &idempotencyRecord{RequestHash: hash, TaskID: "task-1"})
+
+	record, err := s.reserveIdempotencyKey(ctx, account, key, hash)
+	if err != nil || record == nil || record.TaskID != "task-1" {
+		t.Errorf("expected the task to be replayed, got %+v, %v", record, err)
+	}
+
+	// a record that can't be saved releases the key instead of leaving it pending
+	s.store = failingSetStore{newMemoryStore()}
+	if _, err := s.reserveIdempotencyKey(ctx, account, key, hash); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s.completeIdempotencyKey(ctx, account, key, &idempotencyRecord{RequestHash: hash, TaskID: "task-2", Response: json.RawMessage(`{}`)})
+
+	if record, err := s.reserveIdempotencyKey(ctx, account, key, hash); err != nil || record != nil {
+		t.Errorf("expected the key to be released after a failed save, got %+v, %v", record, err)
+	}
+}
+
+// unavailableStore is a store whose SetNX always fails
+type unavailableStore struct {
+	kvStore
+}
+
+func (u unavailableStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return false, errors.New("store is unavailable")
+}
+
+func TestStoreUnavailable(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: unavailableStore{newMemoryStore()}}
+
+	// failures of the store return service unavailable, so clients can tell them from failed operations
+	_, err := s.reserveIdempotencyKey(ctx, "123456789012", "abc", requestHash([]byte(`{}`)))
+	lerr := s.lockCluster(ctx, "123456789012", "us-east-1", "mydocdb", clusterLock{ID: "abc", Operation: operationModify}, syncLockTTL)
+
+	for _, err := range []error{err, lerr} {
+		rr := httptest.NewRecorder()
+		handleError(rr, err)
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status %d for %v, got %d", http.StatusServiceUnavailable, err, rr.Code)
+		}
+	}
+}
+
+func isConflict(err error) bool {
+	aerr, ok := errors.Cause(err).(apierror.Error)
+	return ok && aerr.Code == apierror.ErrConflict
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// jwksRefreshInterval is the minimum time between reloads of the key set for unknown key ids
+	jwksRefreshInterval = 1 * time.Minute
+	// jwksFetchTimeout bounds fetching a key set from a URL
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// bearerValidator validates a bearer token and returns the scope it grants
+type bearerValidator func(ctx context.Context, raw string) (*common.APIToken, error)
+
+// jwk is a JSON web key, only the fields of RSA and EC signing keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwks is a JSON web key set loaded from a URL or a file, reloaded when a token is signed with an
+// unknown key
+type jwks struct {
+	source string
+	client *http.Client
+
+	mu     sync.RWMutex
+	keys   map[string]crypto.PublicKey
+	loaded time.Time
+}
+
+// newJWKS loads the key set from the URL or file
+func newJWKS(source string) (*jwks, error) {
+	j := &jwks{
+		source: source,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+	}
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// load reads and parses the key set
+func (j *jwks) load() error {
+	var data []byte
+	var err error
+
+	if strings.HasPrefix(j.source, "https://") || strings.HasPrefix(j.source, "http://") {
+		data, err = j.fetch()
+	} else {
+		data, err = os.ReadFile(strings.TrimPrefix(j.source, "file://"))
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to read jwks from %s", j.source)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse jwks from %s", j.source)
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.loaded = time.Now()
+	j.mu.Unlock()
+
+	log.Infof("loaded %d key(s) from jwks %s", len(keys), j.source)
+
+	return nil
+}
+
+// fetch gets the key set from the URL
+func (j *jwks) fetch() ([]byte, error) {
+	res, err := j.client.Get(j.source)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+// key returns the key with the id, reloading the key set if the key is unknown and it wasn't reloaded recently
+func (j *jwks) key(kid string) (crypto.PublicKey, error) {
+	j.mu.RLock()
+	key, ok := j.keys[kid]
+	loaded := j.loaded
+	j.mu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if time.Since(loaded) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// parseJWKS returns the RSA and EC signing keys of the key set by key id
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %q", k.Kid)
+		}
+
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys found")
+	}
+
+	return keys, nil
+}
+
+// publicKey returns the public key of the JSON web key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64URLInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64URLInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64URLInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64URLInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// base64URLInt decodes a base64url encoded big endian integer
+func base64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// newJWTValidator returns the validator of bearer JWTs signed by a key of the configured key set.  the
+// claims of a valid token are mapped to the accounts and operations it allows.
+func newJWTValidator(config common.OIDC) (bearerValidator, error) {
+	keys, err := newJWKS(config.JWKS)
+	if err != nil {
+		return nil, err
+	}
+
+	nameClaim := config.NameClaim
+	if nameClaim == "" {
+		nameClaim = "sub"
+	}
+
+	accountsClaim := config.AccountsClaim
+	if accountsClaim == "" {
+		accountsClaim = "accounts"
+	}
+
+	operationsClaim := config.OperationsClaim
+	if operationsClaim == "" {
+		operationsClaim = "operations"
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithExpirationRequired(),
+	}
+
+	if config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(config.Issuer))
+	}
+
+	if config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(config.Audience))
+	}
+
+	parser := jwt.NewParser(opts...)
+
+	return func(ctx context.Context, raw string) (*common.APIToken, error) {
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			return keys.key(kid)
+		}); err != nil {
+			return nil, err
+		}
+
+		name, _ := claims[nameClaim].(string)
+		if name == "" {
+			return nil, fmt.Errorf("missing %s claim", nameClaim)
+		}
+
+		token := &common.APIToken{
+			Name:       "jwt:" + name,
+			Accounts:   claimStrings(claims[accountsClaim]),
+			Operations: claimStrings(claims[operationsClaim]),
+		}
+
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			token.ExpiresAt = &exp.Time
+		}
+
+		return token, nil
+	}, nil
+}
+
+// claimStrings returns the values of a claim that's a list of strings or a space separated string
+func claimStrings(claim interface{}) []string {
+	values := []string{}
+
+	switch c := claim.(type) {
+	case string:
+		values = append(values, strings.Fields(c)...)
+	case []interface{}:
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testJWKS returns a key set with an RSA and an EC key, and the private keys
+func testJWKS(t *testing.T) ([]byte, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+
+	j, err := json.Marshal(map[string]interface{}{
+		"keys": []jwk{
+			{Kty: "RSA", Kid: "rsa-1", Use: "sig", N: enc(rsaKey.N), E: enc(big.NewInt(int64(rsaKey.E)))},
+			{Kty: "EC", Kid: "ec-1", Crv: "P-256", X: enc(ecKey.X), Y: enc(ecKey.Y)},
+			{Kty: "RSA", Kid: "enc-1", Use: "enc", N: enc(rsaKey.N), E: enc(big.NewInt(int64(rsaKey.E)))},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return j, rsaKey, ecKey
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestParseJWKS(t *testing.T) {
+	j, _, _ := testJWKS(t)
+
+	keys, err := parseJWKS(j)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(keys) != 2 || keys["rsa-1"] == nil || keys["ec-1"] == nil {
+		t.Errorf("expected the rsa and ec signing keys, got %+v", keys)
+	}
+
+	for _, data := range []string{`{`, `{"keys":[]}`, `{"keys":[{"kty":"oct","kid":"x"}]}`} {
+		if _, err := parseJWKS([]byte(data)); err == nil {
+			t.Errorf("expected error for %s, got nil", data)
+		}
+	}
+}
+
+func TestJWTValidator(t *testing.T) {
+	j, rsaKey, ecKey := testJWKS(t)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, j, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	validate, err := newJWTValidator(common.OIDC{
+		JWKS:     path,
+		Issuer:   "https://portal.example.edu",
+		Audience: "docdb-api",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":        "jdoe",
+			"iss":        "https://portal.example.edu",
+			"aud":        "docdb-api",
+			"exp":        exp.Unix(),
+			"accounts":   []string{"123456789012"},
+			"operations": "read power",
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	token, err := validate(context.TODO(), signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := &common.APIToken{
+		Name:       "jwt:jdoe",
+		Accounts:   []string{"123456789012"},
+		Operations: []string{"read", "power"},
+		ExpiresAt:  &exp,
+	}
+	if token.Name != expected.Name || !reflect.DeepEqual(token.Accounts, expected.Accounts) ||
+		!reflect.DeepEqual(token.Operations, expected.Operations) || !token.ExpiresAt.Equal(exp) {
+		t.Errorf("expected %+v, got %+v", expected, token)
+	}
+
+	if _, err := validate(context.TODO(), signToken(t, jwt.SigningMethodES256, "ec-1", ecKey, claims(nil))); err != nil {
+		t.Errorf("unexpected error for ec signed token: %s", err)
+	}
+
+	invalid := map[string]string{
+		"wrong issuer":    signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(jwt.MapClaims{"iss": "https://evil.example.com"})),
+		"wrong audience":  signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(jwt.MapClaims{"aud": "other-api"})),
+		"expired":         signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"no expiry":       signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(jwt.MapClaims{"exp": nil})),
+		"no subject":      signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims(jwt.MapClaims{"sub": nil})),
+		"unknown key":     signToken(t, jwt.SigningMethodRS256, "rsa-2", rsaKey, claims(nil)),
+		"encryption key":  signToken(t, jwt.SigningMethodRS256, "enc-1", rsaKey, claims(nil)),
+		"hmac":            signToken(t, jwt.SigningMethodHS256, "rsa-1", []byte("secret"), claims(nil)),
+		"not a jwt":       "foobar",
+		"wrong signature": signToken(t, jwt.SigningMethodES256, "rsa-1", ecKey, claims(nil)),
+	}
+
+	for name, raw := range invalid {
+		if _, err := validate(context.TODO(), raw); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestJWKSFromURL(t *testing.T) {
+	j, rsaKey, _ := testJWKS(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(j)
+	}))
+	defer ts.Close()
+
+	validate, err := newJWTValidator(common.OIDC{JWKS: ts.URL, AccountsClaim: "aws_accounts", OperationsClaim: "scope", NameClaim: "email"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	token, err := validate(context.TODO(), signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{
+		"email":        "jdoe@example.edu",
+		"exp":          time.Now().Add(time.Hour).Unix(),
+		"aws_accounts": "*",
+		"scope":        []string{"read"},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if token.Name != "jwt:jdoe@example.edu" || !tokenAllows(token, "123456789012", opRead) || tokenAllows(token, "123456789012", opDelete) {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	if _, err := newJWTValidator(common.OIDC{JWKS: ts.URL + "/missing\x00"}); err == nil {
+		t.Error("expected error for invalid jwks url, got nil")
+	}
+}
+
+func TestTokenMiddlewareBearer(t *testing.T) {
+	bearer := func(ctx context.Context, raw string) (*common.APIToken, error) {
+		if raw != "goodjwt" {
+			return nil, jwt.ErrTokenMalformed
+		}
+		return &common.APIToken{Name: "jwt:jdoe", Accounts: []string{"*"}, Operations: []string{opRead}}, nil
+	}
+
+	var got *common.APIToken
+	h := TokenMiddleware([]byte("sometesttoken"), nil, bearer, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = tokenFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := map[string]int{
+		"Bearer goodjwt": http.StatusOK,
+		"bearer goodjwt": http.StatusOK,
+		"Bearer badjwt":  http.StatusForbidden,
+		"Basic goodjwt":  http.StatusForbidden,
+	}
+
+	for auth, status := range tests {
+		got = nil
+		req := httptest.NewRequest(http.MethodGet, "/v1/docdb/123456789012", nil)
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != status {
+			t.Errorf("%s: expected status %d, got %d", auth, status, rr.Code)
+		}
+
+		if status == http.StatusOK && (got == nil || got.Name != "jwt:jdoe") {
+			t.Errorf("%s: expected jwt token in the context, got %+v", auth, got)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// keepStoppedPrefix is the store key prefix for keep stopped flags
+	keepStoppedPrefix = "keepstopped:"
+	// keepStoppedRunPrefix is the store key prefix used by instances to claim a reconciler run
+	keepStoppedRunPrefix = "keepstoppedrun:"
+	// keepStoppedInterval is how often the reconciler looks for keep stopped clusters that were started by AWS
+	keepStoppedInterval = 15 * time.Minute
+)
+
+// keepStopped flags a cluster to be stopped again if AWS starts it automatically
+type keepStopped struct {
+	Account   string
+	Region    string
+	Cluster   string
+	CreatedAt time.Time
+}
+
+// keepStoppedKey returns the store key of the keep stopped flag of the cluster in the account and region
+func keepStoppedKey(account, region, cluster string) string {
+	return keepStoppedPrefix + account + ":" + region + ":" + cluster
+}
+
+// setKeepStopped flags the cluster in the account and region to be kept stopped
+func (s *server) setKeepStopped(ctx context.Context, account, region, cluster string) error {
+	if isDryRun(ctx) {
+		return nil
+	}
+
+	j, err := json.Marshal(keepStopped{
+		Account:   account,
+		Region:    region,
+		Cluster:   cluster,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to marshal keep stopped flag", err)
+	}
+
+	if err := s.store.Set(ctx, keepStoppedKey(account, region, cluster), string(j), 0); err != nil {
+		return apierror.New(apierror.ErrServiceUnavailable, "failed to save keep stopped flag", err)
+	}
+
+	return nil
+}
+
+// clearKeepStopped removes the keep stopped flag of the cluster in the account and region
+func (s *server) clearKeepStopped(ctx context.Context, account, region, cluster string) error {
+	if s.store == nil || isDryRun(ctx) {
+		return nil
+	}
+	return s.store.Delete(ctx, keepStoppedKey(account, region, cluster))
+}
+
+// isKeepStopped returns true if the cluster in the account and region is flagged to be kept stopped
+func (s *server) isKeepStopped(ctx context.Context, account, region, cluster string) (bool, error) {
+	if s.store == nil {
+		return false, nil
+	}
+
+	_, found, err := s.store.Get(ctx, keepStoppedKey(account, region, cluster))
+	return found, err
+}
+
+// listKeepStopped returns all clusters flagged to be kept stopped
+func (s *server) listKeepStopped(ctx context.Context) ([]*keepStopped, error) {
+	keys, err := s.store.Keys(ctx, keepStoppedPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := []*keepStopped{}
+	for _, key := range keys {
+		v, found, err := s.store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			continue
+		}
+
+		flag := &keepStopped{}
+		if err := json.Unmarshal([]byte(v), flag); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal keep stopped flag %s", key)
+		}
+
+		flags = append(flags, flag)
+	}
+
+	return flags, nil
+}
+
+// reconcileKeepStopped periodically stops clusters flagged to be kept stopped that AWS started again
+func (s *server) reconcileKeepStopped(ctx context.Context) {
+	ticker := time.NewTicker(keepStoppedInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, flag := range s.claimKeepStopped(ctx, now) {
+				s.reconcileCluster(ctx, flag)
+			}
+		}
+	}
+}
+
+// claimKeepStopped returns the flagged clusters to reconcile in this interval.  each cluster is claimed,
+// so it's only reconciled by one instance when several are running.
+func (s *server) claimKeepStopped(ctx context.Context, now time.Time) []*keepStopped {
+	logger := common.Logger(ctx)
+
+	flags, err := s.listKeepStopped(ctx)
+	if err != nil {
+		logger.Errorf("failed to list keep stopped docdb clusters: %s", err)
+		return nil
+	}
+
+	slot := now.Truncate(keepStoppedInterval).Unix()
+
+	claimed := []*keepStopped{}
+	for _, flag := range flags {
+		key := fmt.Sprintf("%s%s:%s:%s:%d", keepStoppedRunPrefix, flag.Account, flag.Region, flag.Cluster, slot)
+		ok, err := s.store.SetNX(ctx, key, s.instanceID, keepStoppedInterval)
+		if err != nil {
+			logger.Errorf("failed to claim keep stopped docdb cluster %s: %s", flag.Cluster, err)
+			continue
+		}
+
+		if ok {
+			claimed = append(claimed, flag)
+		}
+	}
+
+	return claimed
+}
+
+// reconcileCluster stops the flagged cluster if it's available again
+func (s *server) reconcileCluster(ctx context.Context, flag *keepStopped) {
+	ctx = common.WithRequestID(ctx, uuid.New().String())
+	ctx = common.WithLogger(ctx, common.Logger(ctx).WithFields(map[string]interface{}{
+		"account": flag.Account,
+		"region":  flag.Region,
+		"cluster": flag.Cluster,
+	}))
+	logger := common.Logger(ctx)
+
+	policy, err := generatePolicy([]string{"rds:StopDBCluster"})
+	if err != nil {
+		logger.Errorf("failed to generate power policy: %s", err)
+		return
+	}
+
+	orch, err := s.newDocDBOrchestrator(ctx, &sessionParams{
+		role:         s.roleArn(flag.Account),
+		region:       flag.Region,
+		inlinePolicy: policy,
+		policyArns:   []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
+	})
+	if err != nil {
+		logger.Errorf("unable to create docdb orchestrator to reconcile keep stopped docdb cluster %s: %s", flag.Cluster, err)
+		return
+	}
+
+	cluster, err := orch.docdbClient.GetDocDBDetails(ctx, flag.Cluster)
+	if err != nil {
+		if aerr, ok := errors.Cause(err).(apierror.Error); ok && aerr.Code == apierror.ErrNotFound {
+			logger.Infof("keep stopped docdb cluster %s no longer exists, removing flag", flag.Cluster)
+
+			if err := s.clearKeepStopped(ctx, flag.Account, flag.Region, flag.Cluster); err != nil {
+				logger.Errorf("failed to clear keep stopped flag of docdb cluster %s: %s", flag.Cluster, err)
+			}
+			return
+		}
+
+		logger.Errorf("failed to get keep stopped docdb cluster %s: %s", flag.Cluster, err)
+		return
+	}
+
+	// only act on clusters that finished starting, others are picked up by the next run
+	if status := aws.StringValue(cluster.Status); status != "available" {
+		logger.Debugf("keep stopped docdb cluster %s is %s", flag.Cluster, status)
+		return
+	}
+
+	reason := fmt.Sprintf("docdb cluster %s is flagged keep stopped but was started, most likely automatically by AWS after 7 days stopped, stopping it again", flag.Cluster)
+	task, err := orch.changePowerState(ctx, "stop", flag.Cluster, reason)
+	if err != nil {
+		logger.Errorf("failed to stop keep stopped docdb cluster %s: %s", flag.Cluster, err)
+		return
+	}
+
+	logger.Warnf("stopping keep stopped docdb cluster %s in task %s", flag.Cluster, task.ID)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeepStopped(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore(), instanceID: "me"}
+
+	if keep, err := s.isKeepStopped(ctx, "123456789012", "us-east-1", "mydocdb"); err != nil || keep {
+		t.Errorf("expected cluster not to be kept stopped, got %t, %v", keep, err)
+	}
+
+	for _, cluster := range []string{"mydocdb", "otherdocdb"} {
+		if err := s.setKeepStopped(ctx, "123456789012", "us-east-1", cluster); err != nil {
+			t.Fatalf("unexpected error setting keep stopped: %s", err)
+		}
+	}
+
+	if keep, err := s.isKeepStopped(ctx, "123456789012", "us-east-1", "mydocdb"); err != nil || !keep {
+		t.Errorf("expected cluster to be kept stopped, got %t, %v", keep, err)
+	}
+
+	flags, err := s.listKeepStopped(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing keep stopped: %s", err)
+	}
+
+	if len(flags) != 2 {
+		t.Errorf("expected 2 keep stopped clusters, got %d", len(flags))
+	}
+
+	// each cluster is claimed once per interval
+	now := time.Now()
+	if claimed := s.claimKeepStopped(ctx, now); len(claimed) != 2 {
+		t.Errorf("expected 2 claimed clusters, got %d", len(claimed))
+	}
+
+	if claimed := s.claimKeepStopped(ctx, now); len(claimed) != 0 {
+		t.Errorf("expected no claimed clusters in the same interval, got %d", len(claimed))
+	}
+
+	if claimed := s.claimKeepStopped(ctx, now.Add(keepStoppedInterval)); len(claimed) != 2 {
+		t.Errorf("expected 2 claimed clusters in the next interval, got %d", len(claimed))
+	}
+
+	if err := s.clearKeepStopped(ctx, "123456789012", "us-east-1", "mydocdb"); err != nil {
+		t.Fatalf("unexpected error clearing keep stopped: %s", err)
+	}
+
+	if keep, _ := s.isKeepStopped(ctx, "123456789012", "us-east-1", "mydocdb"); keep {
+		t.Error("expected cleared cluster not to be kept stopped")
+	}
+
+	if flags, _ := s.listKeepStopped(ctx); len(flags) != 1 || flags[0].Cluster != "otherdocdb" {
+		t.Errorf("expected only otherdocdb to be kept stopped, got %+v", flags)
+	}
+}
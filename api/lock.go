@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/google/uuid"
+)
+
+const (
+	// clusterLockPrefix is the store key prefix for cluster locks
+	clusterLockPrefix = "lock:"
+	// syncLockTTL bounds how long a synchronous operation holds a cluster lock if the instance goes away
+	syncLockTTL = 5 * time.Minute
+)
+
+// clusterLock is the holder of the lock on a cluster, either an asynchronous task or a request
+type clusterLock struct {
+	ID        string
+	Operation string
+	Task      bool `json:",omitempty"`
+}
+
+// String describes the holder of the lock
+func (l clusterLock) String() string {
+	if l.Task {
+		return fmt.Sprintf("%s task %s", l.Operation, l.ID)
+	}
+	return fmt.Sprintf("%s request %s", l.Operation, l.ID)
+}
+
+// clusterLockKey returns the store key of the lock on the cluster in the account and region
+func clusterLockKey(account, region, cluster string) string {
+	return clusterLockPrefix + account + ":" + region + ":" + cluster
+}
+
+// lockCluster takes the lock on the cluster for the holder, or returns a conflict naming the current holder
+func (s *server) lockCluster(ctx context.Context, account, region, cluster string, holder clusterLock, ttl time.Duration) error {
+	if s.store == nil {
+		return nil
+	}
+
+	// a dry run fails if the cluster is locked, but doesn't take the lock
+	if isDryRun(ctx) {
+		current, err := s.clusterLockHolder(ctx, account, region, cluster)
+		if err != nil {
+			return apierror.New(apierror.ErrServiceUnavailable, "failed to get docdb cluster lock", err)
+		}
+
+		if current != nil {
+			msg := fmt.Sprintf("docdb cluster %s is locked by %s", cluster, current)
+			return apierror.New(apierror.ErrConflict, msg, nil)
+		}
+
+		return nil
+	}
+
+	j, err := json.Marshal(holder)
+	if err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to marshal cluster lock", err)
+	}
+
+	key := clusterLockKey(account, region, cluster)
+	locked, err := s.store.SetNX(ctx, key, string(j), ttl)
+	if err != nil {
+		return apierror.New(apierror.ErrServiceUnavailable, "failed to lock docdb cluster", err)
+	}
+
+	if locked {
+		common.Logger(ctx).Debugf("locked docdb cluster %s for %s", cluster, holder)
+		return nil
+	}
+
+	current, err := s.clusterLockHolder(ctx, account, region, cluster)
+	if err != nil {
+		return apierror.New(apierror.ErrServiceUnavailable, "failed to get docdb cluster lock", err)
+	}
+
+	// the lock was released in between
+	if current == nil {
+		msg := fmt.Sprintf("docdb cluster %s is busy with another operation, retry later", cluster)
+		return apierror.New(apierror.ErrConflict, msg, nil)
+	}
+
+	msg := fmt.Sprintf("docdb cluster %s is locked by %s", cluster, current)
+	return apierror.New(apierror.ErrConflict, msg, nil)
+}
+
+// unlockCluster releases the lock on the cluster if it's still held by the holder.  it's not atomic, but
+// the lock can only be taken over by someone else after it expired.
+func (s *server) unlockCluster(ctx context.Context, account, region, cluster, holderID string) error {
+	if s.store == nil || isDryRun(ctx) {
+		return nil
+	}
+
+	// release the lock even if the request was canceled
+	ctx = context.WithoutCancel(ctx)
+
+	current, err := s.clusterLockHolder(ctx, account, region, cluster)
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		return nil
+	}
+
+	if current.ID != holderID {
+		common.Logger(ctx).Warnf("not unlocking docdb cluster %s, it's still locked by %s", cluster, current)
+		return nil
+	}
+
+	common.Logger(ctx).Debugf("unlocking docdb cluster %s from %s", cluster, current)
+
+	return s.store.Delete(ctx, clusterLockKey(account, region, cluster))
+}
+
+// clusterLockHolder returns the current holder of the lock on the cluster, or nil if it's not locked
+func (s *server) clusterLockHolder(ctx context.Context, account, region, cluster string) (*clusterLock, error) {
+	v, found, err := s.store.Get(ctx, clusterLockKey(account, region, cluster))
+	if err != nil || !found {
+		return nil, err
+	}
+
+	holder := &clusterLock{}
+	if err := json.Unmarshal([]byte(v), holder); err != nil {
+		return nil, err
+	}
+
+	return holder, nil
+}
+
+// lockClusterForRequest locks the cluster for the duration of a synchronous operation and returns the
+// function releasing it
+func (o *docDBOrchestrator) lockClusterForRequest(ctx context.Context, operation, cluster string) (func(), error) {
+	id := common.RequestID(ctx)
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	account, region := o.account(), o.sp.region
+	if err := o.server.lockCluster(ctx, account, region, cluster, clusterLock{ID: id, Operation: operation}, syncLockTTL); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := o.server.unlockCluster(ctx, account, region, cluster, id); err != nil {
+			common.Logger(ctx).Errorf("failed to unlock docdb cluster %s: %s", cluster, err)
+		}
+	}, nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+)
+
+func TestLockCluster(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore()}
+	account, cluster := "123456789012", "mydocdb"
+
+	task := clusterLock{ID: "task-1", Operation: operationCreate, Task: true}
+	if err := s.lockCluster(ctx, account, "us-east-1", cluster, task, time.Minute); err != nil {
+		t.Fatalf("unexpected error locking cluster: %s", err)
+	}
+
+	// a conflicting operation gets the holder of the lock
+	err := s.lockCluster(ctx, account, "us-east-1", cluster, clusterLock{ID: "req-1", Operation: operationDelete}, time.Minute)
+	if !isConflict(err) {
+		t.Fatalf("expected conflict locking a locked cluster, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "create task task-1") {
+		t.Errorf("expected conflict to name the holding task, got %s", err)
+	}
+
+	// the same cluster name in another account isn't locked
+	if err := s.lockCluster(ctx, "000000000000", "us-east-1", cluster, clusterLock{ID: "req-2", Operation: operationModify}, time.Minute); err != nil {
+		t.Errorf("unexpected error locking cluster in another account: %s", err)
+	}
+
+	// the same cluster name in another region isn't locked
+	if err := s.lockCluster(ctx, account, "us-west-2", cluster, clusterLock{ID: "req-3", Operation: operationModify}, time.Minute); err != nil {
+		t.Errorf("unexpected error locking cluster in another region: %s", err)
+	}
+
+	// only the holder can unlock
+	if err := s.unlockCluster(ctx, account, "us-east-1", cluster, "req-1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if holder, _ := s.clusterLockHolder(ctx, account, "us-east-1", cluster); holder == nil || holder.ID != "task-1" {
+		t.Errorf("expected cluster to still be locked by task-1, got %+v", holder)
+	}
+
+	if err := s.unlockCluster(ctx, account, "us-east-1", cluster, "task-1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if holder, _ := s.clusterLockHolder(ctx, account, "us-east-1", cluster); holder != nil {
+		t.Errorf("expected cluster to be unlocked, got %+v", holder)
+	}
+
+	// the server without a store doesn't lock
+	if err := (&server{}).lockCluster(ctx, account, "us-east-1", cluster, task, time.Minute); err != nil {
+		t.Errorf("unexpected error without a store: %s", err)
+	}
+}
+
+func TestLockClusterForRequest(t *testing.T) {
+	ctx := common.WithRequestID(context.Background(), "req-1")
+	o := &docDBOrchestrator{
+		server: &server{store: newMemoryStore()},
+		sp:     &sessionParams{role: "arn:aws:iam::123456789012:role/SpinupRole", region: "us-east-1"},
+	}
+
+	unlock, err := o.lockClusterForRequest(ctx, operationModify, "mydocdb")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := o.lockClusterForRequest(ctx, operationPower, "mydocdb"); !isConflict(err) {
+		t.Errorf("expected conflict, got %v", err)
+	}
+
+	holder, _ := o.server.clusterLockHolder(ctx, "123456789012", "us-east-1", "mydocdb")
+	if holder == nil || holder.ID != "req-1" || holder.Operation != operationModify || holder.Task {
+		t.Errorf("expected cluster to be locked by modify request req-1, got %+v", holder)
+	}
+
+	unlock()
+
+	if _, err := o.lockClusterForRequest(ctx, operationPower, "mydocdb"); err != nil {
+		t.Errorf("unexpected error locking an unlocked cluster: %s", err)
+	}
+}
+
+func TestFinishTaskUnlocksCluster(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore()}
+	state := &taskState{TaskID: "task-1", Account: "123456789012", Region: "us-east-1", Cluster: "mydocdb", Operation: operationCreate}
+
+	if err := s.lockCluster(ctx, state.Account, state.Region, state.Cluster, clusterLock{ID: state.TaskID, Operation: operationCreate, Task: true}, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := s.saveTaskState(ctx, state); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s.finishTask(ctx, state, nil)
+
+	if holder, _ := s.clusterLockHolder(ctx, state.Account, state.Region, state.Cluster); holder != nil {
+		t.Errorf("expected cluster to be unlocked, got %+v", holder)
+	}
+
+	if keys, _ := s.store.Keys(ctx, taskStatePrefix); len(keys) != 0 {
+		t.Errorf("expected task state to be deleted, got %v", keys)
+	}
+}
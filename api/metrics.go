@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "docdb_api",
+		Name:      "http_requests_total",
+		Help:      "Number of handled API requests by route, method, status and account (other for accounts that aren't configured).",
+	}, []string{"route", "method", "status", "account"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "docdb_api",
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of handled API requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	assumeRoleTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "docdb_api",
+		Name:      "assume_role_total",
+		Help:      "Number of assume role calls by session cache result (hit or miss).",
+	}, []string{"cache"})
+
+	assumeRoleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "docdb_api",
+		Name:      "assume_role_duration_seconds",
+		Help:      "Duration of assume role calls by session cache result (hit or miss).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"cache"})
+
+	flywheelTasksRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "docdb_api",
+		Name:      "flywheel_tasks_running",
+		Help:      "Number of flywheel tasks currently running in this instance.",
+	})
+)
+
+// statusRecorder is an http.ResponseWriter that keeps the status code written
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and writes it to the underlying response writer
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap returns the underlying response writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// metricsAccount returns the account label of the request.  only accounts listed in the configuration are
+// labeled by ID, any other account is labeled "other" so callers can't add series for arbitrary accounts.
+func (s *server) metricsAccount(r *http.Request) string {
+	account, ok := mux.Vars(r)["account"]
+	if !ok {
+		return ""
+	}
+
+	if _, ok := s.accounts[account]; ok {
+		return account
+	}
+	return "other"
+}
+
+// metricsMiddleware records the count and duration of requests to matched routes
+func (s *server) metricsMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h.ServeHTTP(rec, r)
+
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		status := strconv.Itoa(rec.status)
+
+		httpRequestsTotal.WithLabelValues(route, r.Method, status, s.metricsAccount(r)).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
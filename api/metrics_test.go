@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	s := &server{accounts: map[string]common.AccountOverride{"123456789012": {}}}
+
+	router := mux.NewRouter()
+	router.Use(s.metricsMiddleware)
+	router.HandleFunc("/v1/docdb/{account}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/v1/docdb/{account}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}).Methods(http.MethodGet)
+
+	tests := []struct {
+		path    string
+		route   string
+		status  string
+		account string
+	}{
+		{path: "/v1/docdb/123456789012/mydocdb", route: "/v1/docdb/{account}/{name}", status: "404", account: "123456789012"},
+		{path: "/v1/docdb/123456789012", route: "/v1/docdb/{account}", status: "200", account: "123456789012"},
+		{path: "/v1/docdb/000000000000", route: "/v1/docdb/{account}", status: "200", account: "other"},
+		{path: "/v1/docdb/random-account", route: "/v1/docdb/{account}", status: "200", account: "other"},
+	}
+
+	for _, tt := range tests {
+		counter := httpRequestsTotal.WithLabelValues(tt.route, http.MethodGet, tt.status, tt.account)
+		before := testutil.ToFloat64(counter)
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if got := testutil.ToFloat64(counter); got != before+1 {
+			t.Errorf("expected request count for %s %s to be %f, got %f", tt.route, tt.status, before+1, got)
+		}
+	}
+}
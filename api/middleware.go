@@ -17,23 +17,74 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	cache "github.com/patrickmn/go-cache"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// TokenMiddleware checks the tokens for non-public URLs
-func TokenMiddleware(psk []byte, public map[string]string, h http.Handler) http.Handler {
+// maxRequestIDLength is the longest client supplied X-Request-Id we will accept
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware assigns an id to each request, or accepts the one passed in the X-Request-Id header.
+// The id is returned in the response headers and a logger carrying it is stored in the request context.
+func RequestIDMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.New().String()
+		}
+
+		w.Header().Set("X-Request-Id", id)
+
+		h.ServeHTTP(w, r.WithContext(common.WithRequestID(r.Context(), id)))
+	})
+}
+
+// contextLoggerMiddleware adds the account and cluster from the matched route to the request logger
+func contextLoggerMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		logger := common.Logger(r.Context())
+
+		if account, ok := vars["account"]; ok {
+			logger = logger.WithField("account", account)
+		}
+
+		if name, ok := vars["name"]; ok {
+			logger = logger.WithField("cluster", name)
+		}
+
+		h.ServeHTTP(w, r.WithContext(common.WithLogger(r.Context(), logger)))
+	})
+}
+
+// TokenMiddleware checks the tokens for non-public URLs.  requests are authenticated with the pre-shared
+// key, which allows everything, or with one of the scoped tokens, which is carried in the request context
+// and limits the accounts and operations of the request.  if a bearer validator is given, requests can
+// also be authenticated with an Authorization: Bearer token, scoped the same way.  the name of the token
+// is logged with the request.
+func TokenMiddleware(psk []byte, tokens tokenLookup, bearer bearerValidator, public map[string]string, h http.Handler) http.Handler {
+	// bcrypt is slow on purpose, remember which scoped token a header authenticated
+	authenticated := cache.New(tokenCacheTTL, 2*tokenCacheTTL)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := common.Logger(r.Context())
 		log.Debug("Processing token middleware for protected URLs")
 
 		// Handle CORS preflight checks
 		if r.Method == "OPTIONS" {
 			log.Info("Setting CORS preflight options and returning")
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Headers", "X-Auth-Token")
+			w.Header().Set("Access-Control-Allow-Headers", "X-Auth-Token, Authorization")
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte{})
 			return
@@ -48,19 +99,96 @@ func TokenMiddleware(psk []byte, public map[string]string, h http.Handler) http.
 
 		if _, ok := public[uri.Path]; ok {
 			log.Debugf("Not authenticating for '%s'", uri.Path)
-		} else {
-			log.Debugf("Authenticating token for protected URL '%s'", r.URL)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		log.Debugf("Authenticating token for protected URL '%s'", r.URL)
 
-			htoken := r.Header.Get("X-Auth-Token")
-			if err := bcrypt.CompareHashAndPassword([]byte(htoken), psk); err != nil {
-				log.Warnf("Unable to authenticate session for URL '%s': '%s'", r.URL, err)
+		if raw, ok := bearerToken(r); ok && bearer != nil {
+			token, err := bearer(r.Context(), raw)
+			if err != nil {
+				log.Warnf("Unable to authenticate bearer token for URL '%s': '%s'", r.URL, err)
 				w.WriteHeader(http.StatusForbidden)
 				return
 			}
 
+			log = log.WithField("token", token.Name)
+			log.Infof("Successfully authenticated bearer token %s for URL '%s'", token.Name, r.URL)
+
+			ctx := common.WithLogger(withToken(r.Context(), token), log)
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		htoken := r.Header.Get("X-Auth-Token")
+		if err := bcrypt.CompareHashAndPassword([]byte(htoken), psk); err == nil {
+			log = log.WithField("token", "psk")
 			log.Infof("Successfully authenticated token for URL '%s'", r.URL)
+			h.ServeHTTP(w, r.WithContext(common.WithLogger(r.Context(), log)))
+			return
 		}
 
-		h.ServeHTTP(w, r)
+		token := scopedToken(r, htoken, tokens, authenticated)
+		if token == nil {
+			log.Warnf("Unable to authenticate session for URL '%s'", r.URL)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		log = log.WithField("token", token.Name)
+		log.Infof("Successfully authenticated token %s for URL '%s'", token.Name, r.URL)
+
+		ctx := common.WithLogger(withToken(r.Context(), token), log)
+		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// bearerToken returns the token of the Authorization: Bearer header, if any
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// scopedToken returns the unexpired scoped token matching the header, or nil
+func scopedToken(r *http.Request, htoken string, tokens tokenLookup, authenticated *cache.Cache) *common.APIToken {
+	if tokens == nil || htoken == "" {
+		return nil
+	}
+
+	log := common.Logger(r.Context())
+
+	sum := sha256.Sum256([]byte(htoken))
+	key := hex.EncodeToString(sum[:])
+
+	if t, ok := authenticated.Get(key); ok {
+		token := t.(*common.APIToken)
+		if !tokenExpired(token, time.Now()) {
+			return token
+		}
+		authenticated.Delete(key)
+		return nil
+	}
+
+	list, err := tokens(r.Context())
+	if err != nil {
+		log.Errorf("Unable to list api tokens: %s", err)
+		return nil
+	}
+
+	for _, token := range list {
+		if token.Token == "" || tokenExpired(token, time.Now()) {
+			continue
+		}
+
+		if err := bcrypt.CompareHashAndPassword([]byte(htoken), []byte(token.Token)); err == nil {
+			authenticated.SetDefault(key, token)
+			return token
+		}
+	}
+
+	return nil
+}
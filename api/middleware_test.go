@@ -17,11 +17,16 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -42,7 +47,7 @@ func TestTokenMiddleware(t *testing.T) {
 	}
 
 	// Start a new server with our token middleware and test handler
-	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, okHandler))
+	server := httptest.NewServer(TokenMiddleware(psk, nil, nil, pubUrls, okHandler))
 	defer server.Close()
 
 	// Test some public urls
@@ -98,7 +103,7 @@ func TestTokenMiddleware(t *testing.T) {
 
 	testHeaders := map[string]string{
 		"Access-Control-Allow-Origin":  "*",
-		"Access-Control-Allow-Headers": "X-Auth-Token",
+		"Access-Control-Allow-Headers": "X-Auth-Token, Authorization",
 	}
 
 	for k, v := range testHeaders {
@@ -107,3 +112,148 @@ func TestTokenMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenMiddlewareScopedTokens(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tokens := []*common.APIToken{
+		{Name: "reader", Token: "readertoken", Accounts: []string{"*"}, Operations: []string{opRead}},
+		{Name: "expired", Token: "expiredtoken", Accounts: []string{"*"}, Operations: []string{"*"}, ExpiresAt: &past},
+	}
+
+	lookups := 0
+	lookup := func(ctx context.Context) ([]*common.APIToken, error) {
+		lookups++
+		return tokens, nil
+	}
+
+	var got *common.APIToken
+	h := TokenMiddleware([]byte("sometesttoken"), lookup, nil, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = tokenFromContext(r.Context())
+		if name := common.Logger(r.Context()).Data["token"]; got != nil && name != got.Name {
+			t.Errorf("expected logger token field %s, got %v", got.Name, name)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		token  string
+		status int
+		name   string
+	}{
+		{token: "sometesttoken", status: http.StatusOK},
+		{token: "readertoken", status: http.StatusOK, name: "reader"},
+		{token: "readertoken", status: http.StatusOK, name: "reader"},
+		{token: "expiredtoken", status: http.StatusForbidden},
+		{token: "unknowntoken", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		got = nil
+		req := httptest.NewRequest(http.MethodGet, "/v1/docdb/123456789012", nil)
+		req.Header.Set("X-Auth-Token", tokenHeader(t, tt.token))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.token, tt.status, rr.Code)
+		}
+
+		if tt.name != "" && (got == nil || got.Name != tt.name) {
+			t.Errorf("%s: expected token %s in the context, got %+v", tt.token, tt.name, got)
+		}
+
+		if tt.name == "" && got != nil {
+			t.Errorf("%s: expected no scoped token in the context, got %+v", tt.token, got)
+		}
+	}
+
+	// the psk and the repeated reader header, authenticated from the cache, don't look up tokens
+	if lookups != 3 {
+		t.Errorf("expected 3 token lookups, got %d", lookups)
+	}
+}
+
+var tokenHeaders = map[string]string{}
+
+// tokenHeader returns the same bcrypt hash of the token on every call
+func tokenHeader(t *testing.T, token string) string {
+	if h, ok := tokenHeaders[token]; ok {
+		return h
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenHeaders[token] = string(hash)
+	return tokenHeaders[token]
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var gotID string
+	h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = common.RequestID(r.Context())
+		if f := common.Logger(r.Context()).Data["request_id"]; f != gotID {
+			t.Errorf("expected logger request_id field %s, got %v", gotID, f)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// generate an id when none is passed
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	if gotID == "" {
+		t.Error("expected a generated request id, got none")
+	}
+	if hid := rr.Header().Get("X-Request-Id"); hid != gotID {
+		t.Errorf("expected X-Request-Id response header %s, got %s", gotID, hid)
+	}
+
+	// accept the passed id
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.Header.Set("X-Request-Id", "my-request-123")
+	h.ServeHTTP(rr, req)
+	if gotID != "my-request-123" {
+		t.Errorf("expected request id my-request-123, got %s", gotID)
+	}
+	if hid := rr.Header().Get("X-Request-Id"); hid != "my-request-123" {
+		t.Errorf("expected X-Request-Id response header my-request-123, got %s", hid)
+	}
+
+	// replace an overly long id
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.Header.Set("X-Request-Id", strings.Repeat("x", maxRequestIDLength+1))
+	h.ServeHTTP(rr, req)
+	if len(gotID) > maxRequestIDLength {
+		t.Errorf("expected long request id to be replaced, got %s", gotID)
+	}
+}
+
+func TestContextLoggerMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(contextLoggerMiddleware)
+	router.HandleFunc("/v1/docdb/{account}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		fields := common.Logger(r.Context()).Data
+		if fields["account"] != "123456789012" {
+			t.Errorf("expected account field 123456789012, got %v", fields["account"])
+		}
+		if fields["cluster"] != "mydocdb" {
+			t.Errorf("expected cluster field mydocdb, got %v", fields["cluster"])
+		}
+		if fields["request_id"] != "abc" {
+			t.Errorf("expected request_id field abc, got %v", fields["request_id"])
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/docdb/123456789012/mydocdb", nil)
+	req = req.WithContext(common.WithRequestID(req.Context(), "abc"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+}
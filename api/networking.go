@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/aws/aws-sdk-go/aws"
+	awsec2 "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// resolveNetworking sets the subnets and security groups of a create request that doesn't specify them,
+// discovering them by the tags configured for the account, and validates the subnets span at least two
+// availability zones of the same VPC.  it returns the VPC of the subnets.
+func (o *docDBOrchestrator) resolveNetworking(ctx context.Context, req *DocDBCreateRequest) (string, error) {
+	var subnets []*awsec2.Subnet
+
+	if len(req.SubnetIds) == 0 && len(o.settings.subnetTags) > 0 {
+		out, err := o.ec2Client.ListSubnetsWithTags(ctx, o.settings.subnetTags)
+		if err != nil {
+			return "", err
+		}
+
+		// sort the discovered subnets so the same subnet group is used for every cluster
+		sort.Slice(out, func(i, j int) bool {
+			return aws.StringValue(out[i].SubnetId) < aws.StringValue(out[j].SubnetId)
+		})
+
+		subnets = out
+		req.SubnetIds = []string{}
+		for _, s := range subnets {
+			req.SubnetIds = append(req.SubnetIds, aws.StringValue(s.SubnetId))
+		}
+
+		common.Logger(ctx).Infof("discovered subnets %v with tags %v", req.SubnetIds, o.settings.subnetTags)
+	}
+
+	if len(req.SubnetIds) < 2 {
+		return "", apierror.New(apierror.ErrBadRequest, "At least 2 SubnetIds are required", nil)
+	}
+
+	if subnets == nil {
+		out, err := o.ec2Client.GetSubnets(ctx, req.SubnetIds)
+		if err != nil {
+			return "", err
+		}
+		subnets = out
+	}
+
+	vpcId, err := subnetsVpc(subnets)
+	if err != nil {
+		return "", err
+	}
+
+	if len(req.VpcSecurityGroupIds) == 0 && len(o.settings.securityGroupTags) > 0 {
+		groups, err := o.ec2Client.ListSecurityGroupsWithTags(ctx, vpcId, o.settings.securityGroupTags)
+		if err != nil {
+			return "", err
+		}
+
+		if len(groups) == 0 {
+			msg := fmt.Sprintf("no security groups found in vpc %s with tags %v", vpcId, o.settings.securityGroupTags)
+			return "", apierror.New(apierror.ErrBadRequest, msg, nil)
+		}
+
+		ids := []string{}
+		for _, g := range groups {
+			ids = append(ids, aws.StringValue(g.GroupId))
+		}
+		sort.Strings(ids)
+
+		req.VpcSecurityGroupIds = aws.StringSlice(ids)
+
+		common.Logger(ctx).Infof("discovered security groups %v with tags %v", ids, o.settings.securityGroupTags)
+	}
+
+	return vpcId, nil
+}
+
+// subnetsVpc returns the VPC of the subnets, after validating they all belong to the same VPC and span
+// at least two availability zones, as required by a DBSubnetGroup
+func subnetsVpc(subnets []*awsec2.Subnet) (string, error) {
+	vpcs := map[string]struct{}{}
+	zones := map[string]struct{}{}
+	for _, s := range subnets {
+		vpcs[aws.StringValue(s.VpcId)] = struct{}{}
+		zones[aws.StringValue(s.AvailabilityZone)] = struct{}{}
+	}
+
+	if len(vpcs) != 1 {
+		return "", apierror.New(apierror.ErrBadRequest, "SubnetIds must all belong to the same vpc", nil)
+	}
+
+	if len(zones) < 2 {
+		return "", apierror.New(apierror.ErrBadRequest, "SubnetIds must span at least 2 availability zones", nil)
+	}
+
+	return aws.StringValue(subnets[0].VpcId), nil
+}
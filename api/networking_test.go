@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/ec2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awsec2 "github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/pkg/errors"
+)
+
+// mockEC2Client is a fake ec2 client returning fixed subnets and security groups
+type mockEC2Client struct {
+	ec2iface.EC2API
+	subnets    []*awsec2.Subnet
+	groups     []*awsec2.SecurityGroup
+	authorized []*awsec2.IpPermission
+	revoked    []*awsec2.IpPermission
+}
+
+func (m *mockEC2Client) DescribeSubnetsWithContext(ctx context.Context, input *awsec2.DescribeSubnetsInput, opts ...request.Option) (*awsec2.DescribeSubnetsOutput, error) {
+	out := []*awsec2.Subnet{}
+	for _, id := range aws.StringValueSlice(input.SubnetIds) {
+		for _, s := range m.subnets {
+			if aws.StringValue(s.SubnetId) == id {
+				out = append(out, s)
+			}
+		}
+	}
+	return &awsec2.DescribeSubnetsOutput{Subnets: out}, nil
+}
+
+func (m *mockEC2Client) DescribeSubnetsPagesWithContext(ctx context.Context, input *awsec2.DescribeSubnetsInput, fn func(*awsec2.DescribeSubnetsOutput, bool) bool, opts ...request.Option) error {
+	fn(&awsec2.DescribeSubnetsOutput{Subnets: m.subnets}, true)
+	return nil
+}
+
+func (m *mockEC2Client) DescribeSecurityGroupsPagesWithContext(ctx context.Context, input *awsec2.DescribeSecurityGroupsInput, fn func(*awsec2.DescribeSecurityGroupsOutput, bool) bool, opts ...request.Option) error {
+	fn(&awsec2.DescribeSecurityGroupsOutput{SecurityGroups: m.groups}, true)
+	return nil
+}
+
+func testSubnet(id, vpc, az string) *awsec2.Subnet {
+	return &awsec2.Subnet{SubnetId: aws.String(id), VpcId: aws.String(vpc), AvailabilityZone: aws.String(az)}
+}
+
+func TestResolveNetworking(t *testing.T) {
+	mock := &mockEC2Client{
+		subnets: []*awsec2.Subnet{
+			testSubnet("subnet-2", "vpc-1", "us-east-1b"),
+			testSubnet("subnet-1", "vpc-1", "us-east-1a"),
+		},
+		groups: []*awsec2.SecurityGroup{{GroupId: aws.String("sg-1")}},
+	}
+
+	o := &docDBOrchestrator{
+		settings: &accountSettings{
+			subnetTags:        map[string]string{"tier": "database"},
+			securityGroupTags: map[string]string{"tier": "database"},
+		},
+		ec2Client: &ec2.EC2{Service: mock},
+	}
+
+	// subnets and security groups are discovered by tags
+	req := &DocDBCreateRequest{}
+	vpc, err := o.resolveNetworking(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if vpc != "vpc-1" {
+		t.Errorf("expected vpc-1, got %s", vpc)
+	}
+
+	if !reflect.DeepEqual(req.SubnetIds, []string{"subnet-1", "subnet-2"}) || !reflect.DeepEqual(aws.StringValueSlice(req.VpcSecurityGroupIds), []string{"sg-1"}) {
+		t.Errorf("expected discovered subnets and security groups, got %v, %v", req.SubnetIds, aws.StringValueSlice(req.VpcSecurityGroupIds))
+	}
+
+	// given subnets and security groups are kept
+	req = &DocDBCreateRequest{SubnetIds: []string{"subnet-2", "subnet-1"}, VpcSecurityGroupIds: aws.StringSlice([]string{"sg-a"})}
+	if _, err := o.resolveNetworking(context.TODO(), req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(req.SubnetIds, []string{"subnet-2", "subnet-1"}) || !reflect.DeepEqual(aws.StringValueSlice(req.VpcSecurityGroupIds), []string{"sg-a"}) {
+		t.Errorf("expected given subnets and security groups, got %v, %v", req.SubnetIds, aws.StringValueSlice(req.VpcSecurityGroupIds))
+	}
+
+	// without subnets or tags to discover them, create fails
+	o.settings = &accountSettings{}
+	if _, err := o.resolveNetworking(context.TODO(), &DocDBCreateRequest{}); !isBadRequest(err) {
+		t.Errorf("expected bad request without subnets, got %v", err)
+	}
+
+	// subnets in a single availability zone are rejected
+	mock.subnets = []*awsec2.Subnet{
+		testSubnet("subnet-1", "vpc-1", "us-east-1a"),
+		testSubnet("subnet-2", "vpc-1", "us-east-1a"),
+	}
+	if _, err := o.resolveNetworking(context.TODO(), &DocDBCreateRequest{SubnetIds: []string{"subnet-1", "subnet-2"}}); !isBadRequest(err) {
+		t.Errorf("expected bad request for subnets in one availability zone, got %v", err)
+	}
+}
+
+func TestSubnetsVpc(t *testing.T) {
+	tests := []struct {
+		subnets []*awsec2.Subnet
+		vpc     string
+		wantErr bool
+	}{
+		{
+			subnets: []*awsec2.Subnet{testSubnet("subnet-1", "vpc-1", "us-east-1a"), testSubnet("subnet-2", "vpc-1", "us-east-1b")},
+			vpc:     "vpc-1",
+		},
+		{
+			subnets: []*awsec2.Subnet{testSubnet("subnet-1", "vpc-1", "us-east-1a"), testSubnet("subnet-2", "vpc-1", "us-east-1a")},
+			wantErr: true,
+		},
+		{
+			subnets: []*awsec2.Subnet{testSubnet("subnet-1", "vpc-1", "us-east-1a"), testSubnet("subnet-2", "vpc-2", "us-east-1b")},
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		vpc, err := subnetsVpc(tt.subnets)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%d: expected error, got nil", i)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+
+		if vpc != tt.vpc {
+			t.Errorf("%d: expected vpc %s, got %s", i, tt.vpc, vpc)
+		}
+	}
+}
+
+func isBadRequest(err error) bool {
+	aerr, ok := errors.Cause(err).(apierror.Error)
+	return ok && aerr.Code == apierror.ErrBadRequest
+}
+
+func isNotFound(err error) bool {
+	aerr, ok := errors.Cause(err).(apierror.Error)
+	return ok && aerr.Code == apierror.ErrNotFound
+}
@@ -8,20 +8,66 @@ import (
 	"time"
 
 	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
 	"github.com/YaleSpinup/flywheel"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/docdb"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 )
 
 // documentDBCreate creates documentDB cluster and instances
 func (o *docDBOrchestrator) documentDBCreate(ctx context.Context, req *DocDBCreateRequest) (*DocDBResponse, *flywheel.Task, error) {
-	log.Infof("creating documentDB cluster %s with %d instance(s)", aws.StringValue(req.DBClusterIdentifier), aws.IntValue(req.InstanceCount))
+	ctx = common.WithLogger(ctx, common.Logger(ctx).WithField("cluster", aws.StringValue(req.DBClusterIdentifier)))
+	common.Logger(ctx).Infof("creating documentDB cluster %s with %d instance(s)", aws.StringValue(req.DBClusterIdentifier), aws.IntValue(req.InstanceCount))
+
+	cl := aws.StringValue(req.DBClusterIdentifier)
+	task := flywheel.NewTask()
+
+	// lock the cluster until the create task is done
+	lock := clusterLock{ID: task.ID, Operation: operationCreate, Task: true}
+	if err := o.server.lockCluster(ctx, o.account(), o.sp.region, cl, lock, taskStateTTL); err != nil {
+		return nil, nil, err
+	}
+
+	// the dedicated security group of the cluster, deleted again if the create fails after it's created
+	securityGroupId := ""
+
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+
+		if securityGroupId != "" {
+			o.deleteClusterSecurityGroup(ctx, securityGroupId)
+		}
+
+		if err := o.server.unlockCluster(ctx, o.account(), o.sp.region, cl, task.ID); err != nil {
+			common.Logger(ctx).Errorf("failed to unlock docdb cluster %s: %s", cl, err)
+		}
+	}()
+
+	if err := o.checkCreateQuotas(ctx, req); err != nil {
+		return nil, nil, err
+	}
 
 	req.Tags = req.Tags.normalize(o.server.org)
 
+	if req.Access != nil {
+		access, err := req.Access.normalize()
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Access = access
+	}
+
+	vpcId, err := o.resolveNetworking(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	sgName := dbSubnetGroupName(o.server.org, req.SubnetIds)
 
 	// check if a DBSubnetGroup exists, and create it if needed
@@ -35,10 +81,18 @@ func (o *docDBOrchestrator) documentDBCreate(ctx context.Context, req *DocDBCrea
 			return nil, nil, err
 		}
 	} else {
-		log.Infof("subnet group %s already exists, will use it for this docdb cluster", sgName)
+		common.Logger(ctx).Infof("subnet group %s already exists, will use it for this docdb cluster", sgName)
 	}
 
-	task := flywheel.NewTask()
+	// create the dedicated security group of the cluster once the subnet group is ready
+	if req.Access != nil {
+		id, err := o.createClusterSecurityGroup(ctx, cl, vpcId, req.Tags, req.Access)
+		if err != nil {
+			return nil, nil, err
+		}
+		securityGroupId = id
+		req.VpcSecurityGroupIds = append(req.VpcSecurityGroupIds, aws.String(securityGroupId))
+	}
 
 	cluster, err := o.docdbClient.CreateDBCluster(ctx, &docdb.CreateDBClusterInput{
 		BackupRetentionPeriod: req.BackupRetentionPeriod,
@@ -56,88 +110,167 @@ func (o *docDBOrchestrator) documentDBCreate(ctx context.Context, req *DocDBCrea
 		return nil, nil, err
 	}
 
-	allDBInstances := []*docdb.DBInstance{}
-	for i := 1; i <= aws.IntValue(req.InstanceCount); i++ {
-		instanceName := fmt.Sprintf("%s-%d", aws.StringValue(req.DBClusterIdentifier), i)
+	allDBInstances, err := o.createInstances(ctx, cl, req.DBInstanceClass, aws.IntValue(req.InstanceCount), req.Tags)
+	if err != nil {
+		rolledBack, rerr := o.rollBackCreate(ctx, task, "create", cl, allDBInstances, securityGroupId)
+		if rerr != nil {
+			// the security group is still used by the cluster, so it's kept until the cluster is deleted
+			securityGroupId = ""
+
+			msg := fmt.Sprintf("failed to create the instances of docdb cluster %s (%s) and to roll it back, the cluster has to be deleted: %s", cl, err, rerr)
+			return nil, nil, apierror.New(apierror.ErrInternalError, msg, err)
+		}
+
+		err = errors.Wrapf(err, "failed to create the instances of docdb cluster %s, rolled back the cluster", cl)
+		if !rolledBack {
+			return nil, nil, err
+		}
+
+		// the rollback task keeps the cluster locked until its security group is deleted, it's returned
+		// with the error so the rollback can be followed
+		started = true
+		common.Logger(ctx).Infof("docdb cluster %s stays locked by %s until it's rolled back", cl, lock)
+		return nil, task, err
+	}
+
+	// start the async orchestration to wait for docdb cluster to become available
+	o.runTask(ctx, task, &taskState{
+		Operation: operationCreate,
+		Cluster:   cl,
+		Step:      stepWaitForAvailable,
+	}, func(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+		msgChan <- fmt.Sprintf("requested creation of docdb cluster %s", cl)
+		return createWaitForAvailable(ctx, o, state, msgChan)
+	})
+	started = true
+
+	return &DocDBResponse{
+		Cluster:   cluster,
+		Instances: allDBInstances,
+	}, task, nil
+}
 
-		dbInstance, err := o.docdbClient.CreateDBInstance(ctx, &docdb.CreateDBInstanceInput{
+// createInstances creates the instances of a new cluster, named after the cluster.  on error, it returns the
+// instances created so far.
+func (o *docDBOrchestrator) createInstances(ctx context.Context, cl string, class *string, count int, tags Tags) ([]*docdb.DBInstance, error) {
+	instances := []*docdb.DBInstance{}
+	for i := 1; i <= count; i++ {
+		instance, err := o.docdbClient.CreateDBInstance(ctx, &docdb.CreateDBInstanceInput{
 			AutoMinorVersionUpgrade: aws.Bool(true),
-			DBInstanceClass:         req.DBInstanceClass,
-			DBClusterIdentifier:     req.DBClusterIdentifier,
-			DBInstanceIdentifier:    aws.String(instanceName),
+			DBInstanceClass:         class,
+			DBClusterIdentifier:     aws.String(cl),
+			DBInstanceIdentifier:    aws.String(fmt.Sprintf("%s-%d", cl, i)),
 			Engine:                  aws.String("docdb"),
-			Tags:                    req.Tags.toDocDBTags(),
+			Tags:                    tags.toDocDBTags(),
 		})
 		if err != nil {
-			// TODO: Rollback
-			return nil, nil, err
+			return instances, err
 		}
 
-		allDBInstances = append(allDBInstances, dbInstance)
+		instances = append(instances, instance)
 	}
 
-	// start the async orchestration to wait for docdb cluster to become available
-	go func() {
-		cl := aws.StringValue(req.DBClusterIdentifier)
+	return instances, nil
+}
 
-		taskCtx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+// rollBackCreate deletes the instances and the cluster of a create or clone whose instances couldn't all be
+// created.  the dedicated security group of the cluster is deleted by a task once the cluster is gone, which
+// reuses the task and the lock of the create.  it returns true if the task was started.
+func (o *docDBOrchestrator) rollBackCreate(ctx context.Context, task *flywheel.Task, operation, cl string, instances []*docdb.DBInstance, securityGroupId string) (bool, error) {
+	common.Logger(ctx).Warnf("rolling back %s of docdb cluster %s", operation, cl)
 
-		msgChan, errChan := o.startTask(taskCtx, task)
+	// the rollback finishes even if the request is cancelled
+	ctx = context.WithoutCancel(ctx)
 
-		msgChan <- fmt.Sprintf("requested creation of docdb cluster %s", cl)
+	for _, i := range instances {
+		if _, err := o.docdbClient.DeleteDBInstance(ctx, &docdb.DeleteDBInstanceInput{
+			DBInstanceIdentifier: i.DBInstanceIdentifier,
+		}); err != nil {
+			return false, err
+		}
+	}
 
-		if err = retry(10, 3, 10*time.Second, func() error {
-			msgChan <- fmt.Sprintf("checking if docdb cluster %s is available before continuing", cl)
+	if _, err := o.docdbClient.DeleteDBCluster(ctx, &docdb.DeleteDBClusterInput{
+		DBClusterIdentifier: aws.String(cl),
+		SkipFinalSnapshot:   aws.Bool(true),
+	}); err != nil {
+		return false, err
+	}
 
-			if err := o.refreshSession(taskCtx); err != nil {
-				msgChan <- fmt.Sprintf("unable to refresh orchestrator session: %s", err)
-				return err
-			}
+	if securityGroupId == "" {
+		return false, nil
+	}
 
-			// check cluster status
-			cluster, err := o.docdbClient.GetDocDBDetails(taskCtx, cl)
-			if err != nil {
-				msgChan <- fmt.Sprintf("got error checking if docdb cluster %s is available: %s", cl, err)
-				return err
-			}
+	o.runTask(ctx, task, &taskState{
+		Operation:       operationDelete,
+		Cluster:         cl,
+		Step:            stepWaitForDeleted,
+		SecurityGroupID: securityGroupId,
+	}, func(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+		msgChan <- fmt.Sprintf("rolling back %s, requested deletion of docdb cluster %s", operation, cl)
+		return deleteWaitForDeleted(ctx, o, state, msgChan)
+	})
 
-			if status := aws.StringValue(cluster.Status); status != "available" {
-				msgChan <- fmt.Sprintf("docdb cluster %s is not yet available (%s)", cl, status)
-				return fmt.Errorf("docdb cluster %s not yet available", cl)
-			}
+	return true, nil
+}
 
-			// check instances
-			instances, err := o.docdbClient.GetDocDBInstances(taskCtx, cl)
-			if err != nil {
-				msgChan <- fmt.Sprintf("got error describing docdb instances for %s: %s", cl, err)
-				return err
-			}
+// createWaitForAvailable is the task step waiting for a newly created docdb cluster to become available
+func createWaitForAvailable(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+	if err := o.waitForAvailable(ctx, state.Cluster, msgChan); err != nil {
+		return fmt.Errorf("failed to create docdb cluster %s, timeout waiting to become available: %s", state.Cluster, err.Error())
+	}
+	return nil
+}
 
-			if len(instances) == 0 {
-				msgChan <- fmt.Sprintf("docdb cluster %s doesn't have any instances", cl)
-				return fmt.Errorf("docdb cluster %s has no instances", cl)
-			}
+// waitForAvailable waits for a docdb cluster and all of its instances to become available
+func (o *docDBOrchestrator) waitForAvailable(ctx context.Context, cl string, msgChan chan<- string) error {
+	return o.waitForStatus(ctx, cl, "available", 10, msgChan)
+}
 
-			for _, i := range instances {
-				if status := aws.StringValue(i.DBInstanceStatus); status != "available" {
-					msgChan <- fmt.Sprintf("not all docdb instances in cluster %s are available", cl)
-					return fmt.Errorf("not all docdb instances in cluster %s are available", cl)
-				}
-			}
+// waitForStatus waits for a docdb cluster and all of its instances to reach the given status
+func (o *docDBOrchestrator) waitForStatus(ctx context.Context, cl, status string, attempts int, msgChan chan<- string) error {
+	return retry(attempts, 3, 10*time.Second, func() error {
+		msgChan <- fmt.Sprintf("checking if docdb cluster %s is %s before continuing", cl, status)
 
-			msgChan <- fmt.Sprintf("docdb cluster %s is available", cl)
-			return nil
-		}); err != nil {
-			errChan <- fmt.Errorf("failed to create docdb cluster %s, timeout waiting to become available: %s", cl, err.Error())
-			return
+		if err := o.refreshSession(ctx); err != nil {
+			msgChan <- fmt.Sprintf("unable to refresh orchestrator session: %s", err)
+			return err
 		}
-	}()
 
-	return &DocDBResponse{
-		Cluster:   cluster,
-		Instances: allDBInstances,
-	}, task, nil
+		// check cluster status
+		cluster, err := o.docdbClient.GetDocDBDetails(ctx, cl)
+		if err != nil {
+			msgChan <- fmt.Sprintf("got error checking if docdb cluster %s is %s: %s", cl, status, err)
+			return err
+		}
+
+		if s := aws.StringValue(cluster.Status); s != status {
+			msgChan <- fmt.Sprintf("docdb cluster %s is not yet %s (%s)", cl, status, s)
+			return fmt.Errorf("docdb cluster %s not yet %s", cl, status)
+		}
+
+		// check instances
+		instances, err := o.docdbClient.GetDocDBInstances(ctx, cl)
+		if err != nil {
+			msgChan <- fmt.Sprintf("got error describing docdb instances for %s: %s", cl, err)
+			return err
+		}
+
+		if len(instances) == 0 {
+			msgChan <- fmt.Sprintf("docdb cluster %s doesn't have any instances", cl)
+			return fmt.Errorf("docdb cluster %s has no instances", cl)
+		}
+
+		for _, i := range instances {
+			if s := aws.StringValue(i.DBInstanceStatus); s != status {
+				msgChan <- fmt.Sprintf("not all docdb instances in cluster %s are %s", cl, status)
+				return fmt.Errorf("not all docdb instances in cluster %s are %s", cl, status)
+			}
+		}
+
+		msgChan <- fmt.Sprintf("docdb cluster %s is %s", cl, status)
+		return nil
+	})
 }
 
 // documentDBList lists all documentDB clusters
@@ -165,25 +298,36 @@ func (o *docDBOrchestrator) documentDBList(ctx context.Context) ([]string, error
 	return resources, nil
 }
 
-// documentDBDetails returns details about a documentDB cluster
-func (o *docDBOrchestrator) documentDBDetails(ctx context.Context, name string) (*DocDBResponse, error) {
-	if name == "" {
-		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
-	}
-
+// orgCluster returns the cluster and its tags, or not found if it isn't tagged with our org.  accounts can be
+// shared with other orgs, so every operation on an existing cluster gets it from here.
+func (o *docDBOrchestrator) orgCluster(ctx context.Context, name string) (*docdb.DBCluster, Tags, error) {
 	cluster, err := o.docdbClient.GetDocDBDetails(ctx, name)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	t, err := o.docdbClient.GetDocDBTags(ctx, cluster.DBClusterArn)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	tags := fromDocDBTags(t)
 
 	if !tags.inOrg(o.server.org) {
-		return nil, apierror.New(apierror.ErrNotFound, "cluster not found in our org", nil)
+		return nil, nil, apierror.New(apierror.ErrNotFound, "cluster not found in our org", nil)
+	}
+
+	return cluster, tags, nil
+}
+
+// documentDBDetails returns details about a documentDB cluster
+func (o *docDBOrchestrator) documentDBDetails(ctx context.Context, name string) (*DocDBResponse, error) {
+	if name == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	cluster, tags, err := o.orgCluster(ctx, name)
+	if err != nil {
+		return nil, err
 	}
 
 	instances, err := o.docdbClient.GetDocDBInstances(ctx, name)
@@ -204,10 +348,20 @@ func (o *docDBOrchestrator) documentDBModify(ctx context.Context, name string, r
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	documentDB, err := o.docdbClient.GetDocDBDetails(ctx, name)
+	unlock, err := o.lockClusterForRequest(ctx, operationModify, name)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	documentDB, _, err := o.orgCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.checkModifyQuotas(ctx, name, req, len(documentDB.DBClusterMembers)); err != nil {
+		return nil, err
+	}
 
 	// modify cluster parameters
 	cluster, err := o.docdbClient.ModifyDBCluster(ctx, &docdb.ModifyDBClusterInput{
@@ -247,18 +401,30 @@ func (o *docDBOrchestrator) documentDBModify(ctx context.Context, name string, r
 	}, nil
 }
 
-// documentDBDelete deletes documentDB cluster and associated instances
-func (o *docDBOrchestrator) documentDBDelete(ctx context.Context, name string, snapshot bool) error {
+// documentDBDelete deletes documentDB cluster and associated instances.  if the cluster has a dedicated
+// security group, it's deleted in a flywheel task once the cluster is gone, and the task is returned.
+func (o *docDBOrchestrator) documentDBDelete(ctx context.Context, name string, snapshot bool) (*flywheel.Task, error) {
 	if name == "" {
-		return apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	documentDB, err := o.docdbClient.GetDocDBDetails(ctx, name)
+	unlock, err := o.lockClusterForRequest(ctx, operationDelete, name)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer unlock()
+
+	documentDB, _, err := o.orgCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	securityGroup, err := o.clusterSecurityGroup(ctx, documentDB)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Infof("deleting documentDB cluster %s (snapshot: %t)", name, snapshot)
+	common.Logger(ctx).Infof("deleting documentDB cluster %s (snapshot: %t)", name, snapshot)
 
 	// first loop through all the cluster instances and delete them
 	for _, i := range documentDB.DBClusterMembers {
@@ -266,7 +432,7 @@ func (o *docDBOrchestrator) documentDBDelete(ctx context.Context, name string, s
 			DBInstanceIdentifier: i.DBInstanceIdentifier,
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -281,10 +447,33 @@ func (o *docDBOrchestrator) documentDBDelete(ctx context.Context, name string, s
 	}
 
 	if _, err = o.docdbClient.DeleteDBCluster(ctx, &input); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	if err := o.server.clearKeepStopped(ctx, o.account(), o.sp.region, name); err != nil {
+		common.Logger(ctx).Errorf("failed to clear keep stopped flag of deleted docdb cluster %s: %s", name, err)
+	}
+
+	if err := o.server.clearSchedules(ctx, o.account(), o.sp.region, name); err != nil {
+		common.Logger(ctx).Errorf("failed to clear power schedules of deleted docdb cluster %s: %s", name, err)
+	}
+
+	if securityGroup == nil {
+		return nil, nil
+	}
+
+	task := flywheel.NewTask()
+	o.runTask(ctx, task, &taskState{
+		Operation:       operationDelete,
+		Cluster:         name,
+		Step:            stepWaitForDeleted,
+		SecurityGroupID: aws.StringValue(securityGroup.GroupId),
+	}, func(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+		msgChan <- fmt.Sprintf("requested deletion of docdb cluster %s", name)
+		return deleteWaitForDeleted(ctx, o, state, msgChan)
+	})
+
+	return task, nil
 }
 
 // dbSubnetGroupName determines the DBSubnetGroup name based on the Org and subnet id's
@@ -299,7 +488,7 @@ func (o *docDBOrchestrator) dbSubnetGroupExists(ctx context.Context, name string
 	if err != nil {
 		if aerr, ok := errors.Cause(err).(apierror.Error); ok {
 			if aerr.Code == apierror.ErrNotFound {
-				log.Debugf("subnet group not found: %s", name)
+				common.Logger(ctx).Debugf("subnet group not found: %s", name)
 				return false, nil
 			} else {
 				return false, err
@@ -320,7 +509,7 @@ func (o *docDBOrchestrator) dbSubnetGroupCreate(ctx context.Context, name string
 		return apierror.New(apierror.ErrBadRequest, "no subnets specified", nil)
 	}
 
-	log.Infof("creating DBSubnetGroup %s with subnets: %v", name, subnets)
+	common.Logger(ctx).Infof("creating DBSubnetGroup %s with subnets: %v", name, subnets)
 
 	_, err := o.docdbClient.CreateDBSubnetGroup(ctx, &docdb.CreateDBSubnetGroupInput{
 		DBSubnetGroupDescription: aws.String(name),
@@ -334,70 +523,171 @@ func (o *docDBOrchestrator) dbSubnetGroupCreate(ctx context.Context, name string
 	return nil
 }
 
-// startTask starts the flywheel task and receives messages on the channels.  in the future, this
-// functionality might be part of the flywheel library
-func (o *docDBOrchestrator) startTask(ctx context.Context, task *flywheel.Task) (chan<- string, chan<- error) {
-	msgChan := make(chan string)
-	errChan := make(chan error)
-
-	// track the task
-	go func() {
-		taskCtx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+// docDBState is used to start and stop a given cluster.  the power change is tracked as a flywheel task
+// waiting for the cluster and its instances to become available or stopped.  starting a cluster clears
+// its keep stopped flag.
+func (o *docDBOrchestrator) docDBState(ctx context.Context, state string, name string) (*flywheel.Task, error) {
+	task, err := o.changePowerState(ctx, state, name, fmt.Sprintf("requested %s of docdb cluster %s", strings.ToLower(state), name))
+	if err != nil {
+		return nil, err
+	}
 
-		if err := o.server.flywheel.Start(taskCtx, task); err != nil {
-			log.Errorf("failed to start flywheel task, won't be tracked: %s", err)
+	if strings.ToLower(state) == "start" {
+		if err := o.server.clearKeepStopped(ctx, o.account(), o.sp.region, name); err != nil {
+			common.Logger(ctx).Errorf("failed to clear keep stopped flag of docdb cluster %s: %s", name, err)
 		}
+	}
 
-		for {
-			select {
-			case msg := <-msgChan:
-				log.Infof("task %s: %s", task.ID, msg)
+	return task, nil
+}
 
-				if ferr := o.server.flywheel.CheckIn(taskCtx, task.ID); ferr != nil {
-					log.Errorf("failed to checkin task %s: %s", task.ID, ferr)
-				}
+// changePowerState starts or stops the cluster in a flywheel task, logging the reason for the change
+func (o *docDBOrchestrator) changePowerState(ctx context.Context, state, name, reason string) (*flywheel.Task, error) {
+	if state == "" || name == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
 
-				if ferr := o.server.flywheel.Log(taskCtx, task.ID, msg); ferr != nil {
-					log.Errorf("failed to log flywheel message for %s: %s", task.ID, ferr)
-				}
-			case err := <-errChan:
-				log.Error(err)
+	state = strings.ToLower(state)
+	if state != "start" && state != "stop" {
+		msg := fmt.Sprintf("unknown power state %q", state)
+		return nil, apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
 
-				if ferr := o.server.flywheel.Fail(taskCtx, task.ID, err.Error()); ferr != nil {
-					log.Errorf("failed to fail flywheel task %s: %s", task.ID, ferr)
-				}
+	task := flywheel.NewTask()
 
-				return
-			case <-ctx.Done():
-				log.Infof("marking task %s complete", task.ID)
+	// lock the cluster until the power task is done
+	lock := clusterLock{ID: task.ID, Operation: operationPower, Task: true}
+	if err := o.server.lockCluster(ctx, o.account(), o.sp.region, name, lock, taskStateTTL); err != nil {
+		return nil, err
+	}
 
-				if ferr := o.server.flywheel.Complete(taskCtx, task.ID); ferr != nil {
-					log.Errorf("failed to complete flywheel task %s: %s", task.ID, ferr)
-				}
+	started := false
+	defer func() {
+		if started {
+			return
+		}
 
-				return
-			}
+		if err := o.server.unlockCluster(ctx, o.account(), o.sp.region, name, task.ID); err != nil {
+			common.Logger(ctx).Errorf("failed to unlock docdb cluster %s: %s", name, err)
 		}
 	}()
 
-	return msgChan, errChan
-}
+	cluster, _, err := o.orgCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
 
-// docDBState is used to start and stop a given instance
-func (o *docDBOrchestrator) docDBState(ctx context.Context, state string, name string) error {
-	if state == "" || name == "" {
-		return apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	step, err := powerTransition(state, aws.StringValue(cluster.Status))
+	if err != nil {
+		return nil, apierror.New(apierror.ErrConflict, fmt.Sprintf("cannot %s docdb cluster %s: %s", state, name, err), nil)
 	}
 
-	state = strings.ToLower(state)
 	switch state {
 	case "start":
-		return o.docdbClient.StartDBCluster(ctx, name)
+		err = o.docdbClient.StartDBCluster(ctx, name)
 	case "stop":
-		return o.docdbClient.StopDBCluster(ctx, name)
-	default:
-		msg := fmt.Sprintf("unknown power state %q", state)
-		return apierror.New(apierror.ErrBadRequest, msg, nil)
+		err = o.docdbClient.StopDBCluster(ctx, name)
 	}
+
+	if err != nil {
+		// the cluster changed state after it was checked
+		if isInvalidClusterState(err) {
+			return nil, apierror.New(apierror.ErrConflict, fmt.Sprintf("cannot %s docdb cluster %s in its current state", state, name), err)
+		}
+		return nil, err
+	}
+
+	o.runTask(ctx, task, &taskState{
+		Operation: operationPower,
+		Cluster:   name,
+		Step:      step,
+	}, func(ctx context.Context, o *docDBOrchestrator, ts *taskState, msgChan chan<- string) error {
+		msgChan <- reason
+
+		if ts.Step == stepWaitForStopped {
+			return powerWaitForStopped(ctx, o, ts, msgChan)
+		}
+		return powerWaitForAvailable(ctx, o, ts, msgChan)
+	})
+	started = true
+
+	return task, nil
+}
+
+// powerTransition returns the task step waiting for the power state change of a cluster in the given
+// status, or an error if the cluster can't change to that power state
+func powerTransition(state, status string) (string, error) {
+	switch state {
+	case "start":
+		if status != "stopped" {
+			return "", fmt.Errorf("cluster is %s, only a stopped cluster can be started", status)
+		}
+		return stepWaitForAvailable, nil
+	case "stop":
+		if status != "available" {
+			return "", fmt.Errorf("cluster is %s, only an available cluster can be stopped", status)
+		}
+		return stepWaitForStopped, nil
+	}
+
+	return "", fmt.Errorf("unknown power state %q", state)
+}
+
+// isInvalidClusterState returns true if the error is an aws fault for a cluster in the wrong state
+func isInvalidClusterState(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code() == docdb.ErrCodeInvalidDBClusterStateFault || aerr.Code() == docdb.ErrCodeInvalidDBInstanceStateFault
+	}
+	return false
+}
+
+// powerWaitForAvailable is the task step waiting for a started docdb cluster to become available
+func powerWaitForAvailable(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+	if err := o.waitForStatus(ctx, state.Cluster, "available", 20, msgChan); err != nil {
+		return fmt.Errorf("failed to start docdb cluster %s, timeout waiting to become available: %s", state.Cluster, err.Error())
+	}
+	return nil
+}
+
+// powerWaitForStopped is the task step waiting for a stopped docdb cluster to become stopped
+func powerWaitForStopped(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+	if err := o.waitForStatus(ctx, state.Cluster, "stopped", 20, msgChan); err != nil {
+		return fmt.Errorf("failed to stop docdb cluster %s, timeout waiting to become stopped: %s", state.Cluster, err.Error())
+	}
+	return nil
+}
+
+// documentDBPowerState returns the power state of the documentDB cluster and its instances
+func (o *docDBOrchestrator) documentDBPowerState(ctx context.Context, name string) (*DocDBPowerResponse, error) {
+	if name == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	cluster, _, err := o.orgCluster(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := o.docdbClient.GetDocDBInstances(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	keepStopped, err := o.server.isKeepStopped(ctx, o.account(), o.sp.region, name)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrServiceUnavailable, "failed to get keep stopped flag", err)
+	}
+
+	resp := &DocDBPowerResponse{
+		Status:      aws.StringValue(cluster.Status),
+		Instances:   map[string]string{},
+		KeepStopped: keepStopped,
+	}
+
+	for _, i := range instances {
+		resp.Instances[aws.StringValue(i.DBInstanceIdentifier)] = aws.StringValue(i.DBInstanceStatus)
+	}
+
+	return resp, nil
 }
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/docdb"
+	"github.com/YaleSpinup/docdb-api/ec2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awsdocdb "github.com/aws/aws-sdk-go/service/docdb"
+	awsec2 "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestPowerTransition(t *testing.T) {
+	tests := []struct {
+		state   string
+		status  string
+		step    string
+		wantErr bool
+	}{
+		{state: "start", status: "stopped", step: stepWaitForAvailable},
+		{state: "start", status: "available", wantErr: true},
+		{state: "start", status: "stopping", wantErr: true},
+		{state: "stop", status: "available", step: stepWaitForStopped},
+		{state: "stop", status: "stopped", wantErr: true},
+		{state: "stop", status: "starting", wantErr: true},
+		{state: "reboot", status: "available", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		step, err := powerTransition(tt.state, tt.status)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("expected error for %s of a %s cluster, got nil", tt.state, tt.status)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for %s of a %s cluster: %s", tt.state, tt.status, err)
+		}
+
+		if step != tt.step {
+			t.Errorf("expected step %s for %s of a %s cluster, got %s", tt.step, tt.state, tt.status, step)
+		}
+	}
+}
+
+func TestIsInvalidClusterState(t *testing.T) {
+	invalid := awserr.New(awsdocdb.ErrCodeInvalidDBClusterStateFault, "cluster is stopping", nil)
+	if !isInvalidClusterState(apierror.New(apierror.ErrInternalError, "starting instance", invalid)) {
+		t.Error("expected wrapped InvalidDBClusterStateFault to be an invalid cluster state")
+	}
+
+	notFound := awserr.New(awsdocdb.ErrCodeDBClusterNotFoundFault, "not found", nil)
+	if isInvalidClusterState(apierror.New(apierror.ErrNotFound, "starting instance", notFound)) {
+		t.Error("expected DBClusterNotFoundFault not to be an invalid cluster state")
+	}
+
+	if isInvalidClusterState(errors.New("boom")) {
+		t.Error("expected plain error not to be an invalid cluster state")
+	}
+}
+
+func TestOrgOwnership(t *testing.T) {
+	docdbMock := &mockDocDBClient{
+		clusters: []*awsdocdb.DBCluster{
+			{
+				DBClusterArn:        aws.String("arn:aws:rds:us-east-1:123456789012:cluster:theirs"),
+				DBClusterIdentifier: aws.String("theirs"),
+				Status:              aws.String("available"),
+				DBClusterMembers:    []*awsdocdb.DBClusterMember{{DBInstanceIdentifier: aws.String("theirs-1")}},
+				VpcSecurityGroups:   []*awsdocdb.VpcSecurityGroupMembership{{VpcSecurityGroupId: aws.String("sg-theirs")}},
+			},
+			{
+				DBClusterArn:        aws.String("arn:aws:rds:us-east-1:123456789012:cluster:untagged"),
+				DBClusterIdentifier: aws.String("untagged"),
+				Status:              aws.String("stopped"),
+			},
+		},
+		tags: map[string][]*awsdocdb.Tag{
+			"arn:aws:rds:us-east-1:123456789012:cluster:theirs": orgTags("otherorg"),
+		},
+	}
+
+	o := &docDBOrchestrator{
+		server:      &server{org: "localdev", store: newMemoryStore()},
+		sp:          &sessionParams{role: "arn:aws:iam::123456789012:role/SpinupRole", region: "us-east-1"},
+		settings:    &accountSettings{},
+		docdbClient: docdb.DocDB{Service: docdbMock},
+		ec2Client:   &ec2.EC2{Service: &mockEC2Client{}},
+	}
+
+	// schedules of the clusters of another org are only reachable through the org check
+	for _, name := range []string{"theirs", "untagged"} {
+		if err := o.server.store.Set(context.TODO(), scheduleKey("123456789012", "us-east-1", name, "abc"), `{"ID":"abc"}`, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the mocks don't implement any mutating call, so reaching one fails the test
+	ops := map[string]func(name string) error{
+		"details": func(name string) error {
+			_, err := o.documentDBDetails(context.TODO(), name)
+			return err
+		},
+		"modify": func(name string) error {
+			_, err := o.documentDBModify(context.TODO(), name, &DocDBModifyRequest{DBInstanceClass: aws.String("db.r5.large")})
+			return err
+		},
+		"delete": func(name string) error {
+			_, err := o.documentDBDelete(context.TODO(), name, false)
+			return err
+		},
+		"start": func(name string) error {
+			_, err := o.docDBState(context.TODO(), "start", name)
+			return err
+		},
+		"stop": func(name string) error {
+			_, err := o.docDBState(context.TODO(), "stop", name)
+			return err
+		},
+		"power state": func(name string) error {
+			_, err := o.documentDBPowerState(context.TODO(), name)
+			return err
+		},
+		"access": func(name string) error {
+			_, err := o.documentDBAccess(context.TODO(), name)
+			return err
+		},
+		"access update": func(name string) error {
+			_, err := o.documentDBAccessUpdate(context.TODO(), name, &DocDBAccess{Cidrs: []string{"10.1.0.0/16"}})
+			return err
+		},
+		"schedule create": func(name string) error {
+			_, err := o.documentDBScheduleCreate(context.TODO(), name, &DocDBPowerScheduleRequest{State: "stop", Schedule: "0 19 * * *"})
+			return err
+		},
+		"schedule list": func(name string) error {
+			_, err := o.documentDBSchedules(context.TODO(), name)
+			return err
+		},
+		"schedule delete": func(name string) error {
+			return o.documentDBScheduleDelete(context.TODO(), name, "abc")
+		},
+	}
+
+	for op, f := range ops {
+		for _, name := range []string{"theirs", "untagged", "missing"} {
+			if err := f(name); !isNotFound(err) {
+				t.Errorf("%s of cluster %s: expected not found, got %v", op, name, err)
+			}
+		}
+	}
+}
+
+func TestDocumentDBCreateRollback(t *testing.T) {
+	docdbMock := &failingInstancesDocDBClient{mockDocDBClient: &mockDocDBClient{}}
+
+	s := &server{org: "localdev", store: newMemoryStore()}
+	o := &docDBOrchestrator{
+		server:      s,
+		sp:          &sessionParams{role: "arn:aws:iam::123456789012:role/SpinupRole", region: "us-east-1"},
+		settings:    &accountSettings{},
+		docdbClient: docdb.DocDB{Service: docdbMock},
+		ec2Client: &ec2.EC2{Service: &mockEC2Client{
+			subnets: []*awsec2.Subnet{
+				testSubnet("subnet-1", "vpc-1", "us-east-1a"),
+				testSubnet("subnet-2", "vpc-1", "us-east-1b"),
+			},
+		}},
+	}
+
+	req := func() *DocDBCreateRequest {
+		return &DocDBCreateRequest{
+			DBClusterIdentifier: aws.String("mydocdb"),
+			DBInstanceClass:     aws.String("db.r5.large"),
+			InstanceCount:       aws.Int(2),
+			SubnetIds:           []string{"subnet-1", "subnet-2"},
+		}
+	}
+
+	// the created instance and the cluster are deleted, without a task when there's no security group to delete
+	_, task, err := o.documentDBCreate(context.TODO(), req())
+	if err == nil {
+		t.Fatal("expected error creating the instances of the cluster, got nil")
+	}
+
+	if task != nil {
+		t.Errorf("expected no rollback task, got %s", task.ID)
+	}
+
+	if strings.Join(docdbMock.deleted, ",") != "mydocdb-1,mydocdb" {
+		t.Errorf("expected the instance and the cluster to be deleted, got %v", docdbMock.deleted)
+	}
+
+	if holder, _ := s.clusterLockHolder(context.TODO(), "123456789012", "us-east-1", "mydocdb"); holder != nil {
+		t.Errorf("expected the cluster to be unlocked, got %s", holder)
+	}
+
+	// a failed rollback names the cluster left behind
+	docdbMock.instancesCreated, docdbMock.deleted, docdbMock.failDelete = 0, nil, true
+
+	_, _, err = o.documentDBCreate(context.TODO(), req())
+	if err == nil || !strings.Contains(err.Error(), "mydocdb") || !strings.Contains(err.Error(), "has to be deleted") {
+		t.Errorf("expected error naming the cluster, got %v", err)
+	}
+
+	if holder, _ := s.clusterLockHolder(context.TODO(), "123456789012", "us-east-1", "mydocdb"); holder != nil {
+		t.Errorf("expected the cluster to be unlocked, got %s", holder)
+	}
+}
+
+// deletingDocDBClient is a mock docdb client accepting instance and cluster deletions
+type deletingDocDBClient struct {
+	*mockDocDBClient
+}
+
+func (d deletingDocDBClient) DeleteDBInstanceWithContext(ctx context.Context, input *awsdocdb.DeleteDBInstanceInput, opts ...request.Option) (*awsdocdb.DeleteDBInstanceOutput, error) {
+	return &awsdocdb.DeleteDBInstanceOutput{DBInstance: &awsdocdb.DBInstance{DBInstanceIdentifier: input.DBInstanceIdentifier}}, nil
+}
+
+func (d deletingDocDBClient) DeleteDBClusterWithContext(ctx context.Context, input *awsdocdb.DeleteDBClusterInput, opts ...request.Option) (*awsdocdb.DeleteDBClusterOutput, error) {
+	return &awsdocdb.DeleteDBClusterOutput{DBCluster: &awsdocdb.DBCluster{DBClusterIdentifier: input.DBClusterIdentifier}}, nil
+}
+
+func TestDocumentDBDeleteClearsSchedules(t *testing.T) {
+	ctx := context.Background()
+	s := &server{org: "localdev", store: newMemoryStore()}
+	o := &docDBOrchestrator{
+		server:   s,
+		sp:       &sessionParams{role: "arn:aws:iam::123456789012:role/SpinupRole", region: "us-east-1"},
+		settings: &accountSettings{},
+		docdbClient: docdb.DocDB{Service: deletingDocDBClient{&mockDocDBClient{
+			clusters: []*awsdocdb.DBCluster{
+				{
+					DBClusterArn:        aws.String("arn:aws:rds:us-east-1:123456789012:cluster:mydocdb"),
+					DBClusterIdentifier: aws.String("mydocdb"),
+					DBClusterMembers:    []*awsdocdb.DBClusterMember{{DBInstanceIdentifier: aws.String("mydocdb-1")}},
+				},
+			},
+			tags: map[string][]*awsdocdb.Tag{
+				"arn:aws:rds:us-east-1:123456789012:cluster:mydocdb": orgTags("localdev"),
+			},
+		}}},
+		ec2Client: &ec2.EC2{Service: &mockEC2Client{}},
+	}
+
+	for _, cluster := range []string{"mydocdb", "otherdocdb"} {
+		if err := s.createSchedule(ctx, &PowerSchedule{Account: "123456789012", Region: "us-east-1", Cluster: cluster, State: "stop", Schedule: "0 19 * * *"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := o.documentDBDelete(ctx, "mydocdb", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if list, _ := s.listSchedules(ctx, "123456789012", "us-east-1", "mydocdb"); len(list) != 0 {
+		t.Errorf("expected the schedules of the deleted cluster to be deleted, got %+v", list)
+	}
+
+	if list, _ := s.listSchedules(ctx, "123456789012", "us-east-1", "otherdocdb"); len(list) != 1 {
+		t.Errorf("expected the schedules of other clusters to be kept, got %+v", list)
+	}
+}
@@ -7,55 +7,106 @@ import (
 
 	"github.com/YaleSpinup/docdb-api/common"
 	"github.com/YaleSpinup/docdb-api/docdb"
+	"github.com/YaleSpinup/docdb-api/ec2"
 	"github.com/YaleSpinup/docdb-api/resourcegroupstaggingapi"
 	"github.com/YaleSpinup/flywheel"
-	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type docDBOrchestrator struct {
 	server      *server
 	sp          *sessionParams
+	settings    *accountSettings
 	docdbClient docdb.DocDB
+	ec2Client   *ec2.EC2
 	rgClient    *resourcegroupstaggingapi.ResourceGroupsTaggingAPI
+	// usageClients are the clients counting the quota usage in each region of the account, created when needed
+	usageClients map[string]*usageClients
+	// plan records the calls of a dry run instead of making them, nil unless the request is a dry run
+	plan *dryRunPlan
 }
 
 // sessionParams stores all required parameters to initialize the connection session
 type sessionParams struct {
 	role         string
+	region       string
 	inlinePolicy string
 	policyArns   []string
 }
 
 // newDocDBOrchestrator creates a new session and initializes all clients
 func (s *server) newDocDBOrchestrator(ctx context.Context, sp *sessionParams) (*docDBOrchestrator, error) {
-	log.Debug("initializing docDBOrchestrator")
+	settings, err := s.accountSettings(accountFromRole(sp.role))
+	if err != nil {
+		return nil, err
+	}
+
+	// use the region of the request, or the region of the account
+	if sp.region == "" {
+		sp.region = regionFromContext(ctx)
+	}
+
+	if sp.region == "" {
+		sp.region = settings.region
+	}
+
+	ctx, span := startSpan(ctx, "newDocDBOrchestrator", trace.WithAttributes(
+		attribute.String("aws.role", sp.role),
+		attribute.String("aws.region", sp.region),
+	))
+	defer span.End()
+
+	common.Logger(ctx).Debug("initializing docDBOrchestrator")
 
 	sess, err := s.assumeRole(
 		ctx,
-		s.session.ExternalID,
+		settings.externalID,
+		sp.region,
 		sp.role,
 		sp.inlinePolicy,
 		sp.policyArns...,
 	)
 	if err != nil {
-		return nil, err
+		return nil, spanError(span, err)
 	}
 
-	return &docDBOrchestrator{
+	o := &docDBOrchestrator{
 		server:      s,
 		sp:          sp,
-		docdbClient: docdb.New(docdb.WithSession(sess.Session)),
+		settings:    settings,
+		docdbClient: docdb.New(docdb.WithSession(sess.Session), docdb.WithDefaultKMSKeyId(settings.defaultKMSKeyId)),
+		ec2Client:   ec2.New(ec2.WithSession(sess.Session)),
 		rgClient:    resourcegroupstaggingapi.New(resourcegroupstaggingapi.WithSession(sess.Session)),
-	}, nil
+	}
+
+	if isDryRun(ctx) {
+		o.plan = &dryRunPlan{}
+		o.dryRunClients()
+	}
+
+	return o, nil
+}
+
+// dryRunClients replaces the clients making changes with clients recording them in the plan of the dry run
+func (o *docDBOrchestrator) dryRunClients() {
+	o.docdbClient.Service = &dryRunDocDB{DocDBAPI: o.docdbClient.Service, plan: o.plan}
+	o.ec2Client.Service = &dryRunEC2{EC2API: o.ec2Client.Service, plan: o.plan}
+}
+
+// account returns the id of the account of the orchestrator's role
+func (o *docDBOrchestrator) account() string {
+	return accountFromRole(o.sp.role)
 }
 
 // refreshSession refreshes the session for all client connections
 func (o *docDBOrchestrator) refreshSession(ctx context.Context) error {
-	log.Debug("refreshing docDBOrchestrator session")
+	common.Logger(ctx).Debug("refreshing docDBOrchestrator session")
 
 	sess, err := o.server.assumeRole(
 		ctx,
-		o.server.session.ExternalID,
+		o.settings.externalID,
+		o.sp.region,
 		o.sp.role,
 		o.sp.inlinePolicy,
 		o.sp.policyArns...,
@@ -64,9 +115,14 @@ func (o *docDBOrchestrator) refreshSession(ctx context.Context) error {
 		return err
 	}
 
-	o.docdbClient = docdb.New(docdb.WithSession(sess.Session))
+	o.docdbClient = docdb.New(docdb.WithSession(sess.Session), docdb.WithDefaultKMSKeyId(o.settings.defaultKMSKeyId))
+	o.ec2Client = ec2.New(ec2.WithSession(sess.Session))
 	o.rgClient = resourcegroupstaggingapi.New(resourcegroupstaggingapi.WithSession(sess.Session))
 
+	if o.plan != nil {
+		o.dryRunClients()
+	}
+
 	return nil
 }
 
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+const (
+	// hoursPerMonth is the average number of hours in a month, as used by AWS pricing
+	hoursPerMonth = 730
+	// defaultEstimateStorageGB is the storage estimated when the request doesn't give one
+	defaultEstimateStorageGB = 10
+	// defaultBackupRetention is the backup retention period of a cluster created without one
+	defaultBackupRetention = 1
+	// maxBackupRetention is the longest backup retention period documentDB allows, in days
+	maxBackupRetention = 35
+)
+
+// pricingTable is the price list of documentDB by region, loaded from a JSON file
+type pricingTable struct {
+	Currency string
+	Regions  map[string]regionPricing
+}
+
+// regionPricing are the documentDB prices in a region
+type regionPricing struct {
+	// InstanceHourly is the on-demand price per hour by instance class
+	InstanceHourly map[string]float64
+	// StorageGBMonth is the price per GB-month of cluster storage
+	StorageGBMonth float64
+	// BackupGBMonth is the price per GB-month of backup storage beyond the free allowance
+	BackupGBMonth float64
+	// BackupDailyChange is the fraction of the cluster storage changed every day, kept by every retained backup
+	BackupDailyChange float64
+}
+
+// pricingFile loads the pricing table from a file, reloading it when the file changes so it can be refreshed
+// without a restart
+type pricingFile struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	table   *pricingTable
+}
+
+func newPricingFile(path string) (*pricingFile, error) {
+	p := &pricingFile{path: path}
+	if _, err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// load returns the pricing table, reading the file again if it was modified since it was last read.  the last
+// good table is kept if the modified file can't be read.
+func (p *pricingFile) load() (*pricingTable, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		if p.table != nil {
+			return p.table, nil
+		}
+		return nil, errors.Wrap(err, "failed to read pricing file")
+	}
+
+	if p.table != nil && info.ModTime().Equal(p.modTime) {
+		return p.table, nil
+	}
+
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		if p.table != nil {
+			return p.table, nil
+		}
+		return nil, errors.Wrap(err, "failed to read pricing file")
+	}
+
+	table := &pricingTable{}
+	if err := json.Unmarshal(b, table); err != nil {
+		if p.table != nil {
+			return p.table, nil
+		}
+		return nil, errors.Wrap(err, "failed to decode pricing file")
+	}
+
+	p.table = table
+	p.modTime = info.ModTime()
+
+	return table, nil
+}
+
+// pricingTable returns the current pricing table used for cost estimates
+func (s *server) pricingTable() (*pricingTable, error) {
+	if s.pricing == nil {
+		return nil, apierror.New(apierror.ErrServiceUnavailable, "cost estimates are not configured", nil)
+	}
+
+	table, err := s.pricing.load()
+	if err != nil {
+		return nil, apierror.New(apierror.ErrServiceUnavailable, "pricing table is unavailable", err)
+	}
+
+	return table, nil
+}
+
+// estimate returns the monthly cost of a cluster with the given instances, backup retention and storage
+func (t *pricingTable) estimate(region, class string, count int, retention int64, storageGB float64) (*DocDBEstimate, error) {
+	if count < 1 {
+		return nil, apierror.New(apierror.ErrBadRequest, "InstanceCount must be at least 1", nil)
+	}
+
+	if retention < 1 || retention > maxBackupRetention {
+		msg := fmt.Sprintf("BackupRetentionPeriod must be between 1 and %d", maxBackupRetention)
+		return nil, apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	if storageGB < 0 {
+		return nil, apierror.New(apierror.ErrBadRequest, "StorageGB can't be negative", nil)
+	}
+
+	prices, ok := t.Regions[region]
+	if !ok {
+		msg := fmt.Sprintf("no documentdb pricing for region %s", region)
+		return nil, apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	hourly, ok := prices.InstanceHourly[class]
+	if !ok {
+		msg := fmt.Sprintf("no documentdb pricing for instance class %s in region %s", class, region)
+		return nil, apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	// backup storage up to the size of the cluster is free, which covers the first day of retention
+	backupGB := 0.0
+	if retention > 1 {
+		backupGB = storageGB * prices.BackupDailyChange * float64(retention-1)
+	}
+
+	e := &DocDBEstimate{
+		Currency:              t.Currency,
+		Region:                region,
+		DBInstanceClass:       class,
+		InstanceCount:         count,
+		BackupRetentionPeriod: retention,
+		StorageGB:             storageGB,
+		InstanceCost:          roundCents(hourly * hoursPerMonth * float64(count)),
+		StorageCost:           roundCents(prices.StorageGBMonth * storageGB),
+		BackupCost:            roundCents(prices.BackupGBMonth * backupGB),
+	}
+	e.Total = roundCents(e.InstanceCost + e.StorageCost + e.BackupCost)
+
+	return e, nil
+}
+
+// createEstimate returns the monthly cost of the cluster of the create request
+func (t *pricingTable) createEstimate(region string, req *DocDBCreateEstimateRequest) (*DocDBEstimate, error) {
+	if req.DBInstanceClass == nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "DBInstanceClass is required", nil)
+	}
+
+	retention := int64(defaultBackupRetention)
+	if req.BackupRetentionPeriod != nil {
+		retention = *req.BackupRetentionPeriod
+	}
+
+	return t.estimate(region, *req.DBInstanceClass, aws.IntValue(req.InstanceCount), retention, estimateStorage(req.StorageGB))
+}
+
+// modifyEstimate returns the monthly cost of the existing cluster and of the cluster once the modify request
+// is applied
+func (t *pricingTable) modifyEstimate(region string, cluster *DocDBResponse, req *DocDBModifyEstimateRequest) (*DocDBEstimateResponse, error) {
+	if len(cluster.Instances) == 0 {
+		return nil, apierror.New(apierror.ErrBadRequest, "docdb cluster doesn't have any instances to estimate", nil)
+	}
+
+	class := aws.StringValue(cluster.Instances[0].DBInstanceClass)
+	count := len(cluster.Instances)
+	retention := aws.Int64Value(cluster.Cluster.BackupRetentionPeriod)
+	storage := estimateStorage(req.StorageGB)
+
+	current, err := t.estimate(region, class, count, retention, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.DBInstanceClass != nil {
+		class = *req.DBInstanceClass
+	}
+
+	if req.BackupRetentionPeriod != nil {
+		retention = *req.BackupRetentionPeriod
+	}
+
+	estimate, err := t.estimate(region, class, count, retention, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DocDBEstimateResponse{
+		Estimate: estimate,
+		Current:  current,
+	}, nil
+}
+
+// estimateStorage returns the storage of an estimate request, or the default
+func estimateStorage(storageGB *float64) float64 {
+	if storageGB == nil {
+		return defaultEstimateStorageGB
+	}
+	return *storageGB
+}
+
+// roundCents rounds the cost to 2 decimals
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/docdb"
+	"github.com/gorilla/mux"
+)
+
+const testPricing = `{
+  "currency": "USD",
+  "regions": {
+    "us-east-1": {
+      "instanceHourly": {"db.t3.medium": 0.078, "db.r5.large": 0.277},
+      "storageGBMonth": 0.10,
+      "backupGBMonth": 0.021,
+      "backupDailyChange": 0.05
+    }
+  }
+}`
+
+func writePricingFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPricingFile(t *testing.T) {
+	if _, err := newPricingFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for a missing pricing file, got nil")
+	}
+
+	path := writePricingFile(t, testPricing)
+	p, err := newPricingFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	table, _ := p.load()
+	if table.Currency != "USD" || table.Regions["us-east-1"].InstanceHourly["db.r5.large"] != 0.277 {
+		t.Errorf("unexpected pricing table %+v", table)
+	}
+
+	// a refreshed file is reloaded
+	refreshed := `{"currency": "USD", "regions": {"us-east-1": {"instanceHourly": {"db.r5.large": 0.3}}}}`
+	if err := os.WriteFile(path, []byte(refreshed), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if table, _ := p.load(); table.Regions["us-east-1"].InstanceHourly["db.r5.large"] != 0.3 {
+		t.Errorf("expected the refreshed price, got %+v", table)
+	}
+
+	// a broken file keeps the last good table
+	os.WriteFile(path, []byte("{"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if table, err := p.load(); err != nil || table.Regions["us-east-1"].InstanceHourly["db.r5.large"] != 0.3 {
+		t.Errorf("expected the last good table, got %+v, %v", table, err)
+	}
+}
+
+func TestPricingEstimate(t *testing.T) {
+	table := &pricingTable{}
+	if err := json.Unmarshal([]byte(testPricing), table); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := table.estimate("us-east-1", "db.r5.large", 3, 8, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// 0.277 * 730 * 3, 0.10 * 100, 0.021 * 100 * 0.05 * 7
+	if e.InstanceCost != 606.63 || e.StorageCost != 10 || e.BackupCost != 0.74 || e.Total != 617.37 {
+		t.Errorf("unexpected estimate %+v", e)
+	}
+
+	if e, _ := table.estimate("us-east-1", "db.t3.medium", 1, 1, 100); e.BackupCost != 0 {
+		t.Errorf("expected the first day of backups to be free, got %+v", e)
+	}
+
+	if _, err := table.estimate("eu-west-1", "db.r5.large", 1, 1, 10); err == nil {
+		t.Error("expected error for a region without pricing, got nil")
+	}
+
+	if _, err := table.estimate("us-east-1", "db.r5.large", 1, maxBackupRetention, 10); err != nil {
+		t.Errorf("unexpected error for the longest backup retention: %s", err)
+	}
+
+	if _, err := table.estimate("us-east-1", "db.x1.huge", 1, 1, 10); err == nil {
+		t.Error("expected error for an instance class without pricing, got nil")
+	}
+
+	if _, err := table.createEstimate("us-east-1", &DocDBCreateEstimateRequest{}); err == nil {
+		t.Error("expected error for a create request without instance class, got nil")
+	}
+
+	invalid := map[string]*DocDBCreateEstimateRequest{
+		"no instances": {DocDBCreateRequest: DocDBCreateRequest{DBInstanceClass: aws.String("db.r5.large")}},
+		"negative storage": {
+			DocDBCreateRequest: DocDBCreateRequest{DBInstanceClass: aws.String("db.r5.large"), InstanceCount: aws.Int(1)},
+			StorageGB:          aws.Float64(-1),
+		},
+		"no backup retention": {
+			DocDBCreateRequest: DocDBCreateRequest{DBInstanceClass: aws.String("db.r5.large"), InstanceCount: aws.Int(1), BackupRetentionPeriod: aws.Int64(0)},
+		},
+		"too long backup retention": {
+			DocDBCreateRequest: DocDBCreateRequest{DBInstanceClass: aws.String("db.r5.large"), InstanceCount: aws.Int(1), BackupRetentionPeriod: aws.Int64(36)},
+		},
+	}
+
+	for name, req := range invalid {
+		if _, err := table.createEstimate("us-east-1", req); !isBadRequest(err) {
+			t.Errorf("%s: expected bad request, got %v", name, err)
+		}
+	}
+
+	if _, err := table.modifyEstimate("us-east-1", &DocDBResponse{Cluster: &docdb.DBCluster{BackupRetentionPeriod: aws.Int64(1)}}, &DocDBModifyEstimateRequest{}); !isBadRequest(err) {
+		t.Errorf("expected bad request for a cluster without instances, got %v", err)
+	}
+
+	cluster := &DocDBResponse{
+		Cluster: &docdb.DBCluster{BackupRetentionPeriod: aws.Int64(1)},
+		Instances: []*docdb.DBInstance{
+			{DBInstanceClass: aws.String("db.t3.medium")},
+			{DBInstanceClass: aws.String("db.t3.medium")},
+		},
+	}
+
+	req := &DocDBModifyEstimateRequest{StorageGB: aws.Float64(50)}
+	req.DBInstanceClass = aws.String("db.r5.large")
+
+	resp, err := table.modifyEstimate("us-east-1", cluster, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if resp.Current.DBInstanceClass != "db.t3.medium" || resp.Current.InstanceCount != 2 || resp.Estimate.DBInstanceClass != "db.r5.large" ||
+		resp.Estimate.InstanceCount != 2 || resp.Estimate.StorageGB != 50 || resp.Estimate.Total <= resp.Current.Total {
+		t.Errorf("unexpected modify estimate %+v, current %+v", resp.Estimate, resp.Current)
+	}
+}
+
+func TestDocumentDBCreateEstimateHandler(t *testing.T) {
+	p, err := newPricingFile(writePricingFile(t, testPricing))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{pricing: p, defaultRegion: "us-east-1"}
+	router := mux.NewRouter()
+	router.Handle("/v1/docdb/{account}/estimate", s.regionMiddleware(http.HandlerFunc(s.DocumentDBCreateEstimateHandler)))
+
+	body := `{"DBClusterIdentifier":"mydocdb","DBInstanceClass":"db.r5.large","InstanceCount":2,"StorageGB":20}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/docdb/123456789012/estimate", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	resp := DocDBEstimateResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Estimate == nil || resp.Estimate.InstanceCount != 2 || resp.Estimate.StorageGB != 20 || resp.Estimate.Region != "us-east-1" || resp.Current != nil {
+		t.Errorf("unexpected estimate response %+v", resp)
+	}
+
+	// estimates aren't available without a pricing table
+	s.pricing = nil
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/docdb/123456789012/estimate", bytes.NewBufferString(body)))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
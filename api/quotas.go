@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/docdb"
+	"github.com/YaleSpinup/docdb-api/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// quotaUsage is the current usage of the org in all the regions of the account counted against its quotas
+type quotaUsage struct {
+	clusters int
+	vcpu     int
+	// clusterVCPU is the total vCPU of the instances of each cluster in the region of the request
+	clusterVCPU map[string]int
+}
+
+// usageClients are the read only clients counting the usage of the org in a region
+type usageClients struct {
+	docdbClient docdb.DocDB
+	rgClient    *resourcegroupstaggingapi.ResourceGroupsTaggingAPI
+}
+
+// instanceVCPU returns the number of vCPU of a documentDB instance class from its size, ie. 2 for
+// db.t3.medium and db.r5.large, 4 for db.r5.xlarge and 48 for db.r5.12xlarge
+func instanceVCPU(class string) (int, bool) {
+	size := class[strings.LastIndex(class, ".")+1:]
+
+	switch size {
+	case "medium", "large":
+		return 2, true
+	case "xlarge":
+		return 4, true
+	}
+
+	if n, err := strconv.Atoi(strings.TrimSuffix(size, "xlarge")); err == nil && strings.HasSuffix(size, "xlarge") && n > 0 {
+		return 4 * n, true
+	}
+
+	return 0, false
+}
+
+// checkInstanceClass returns an error if the instance class isn't allowed in the account
+func (a *accountSettings) checkInstanceClass(class string) error {
+	if a.instanceClassAllowed(class) {
+		return nil
+	}
+
+	msg := fmt.Sprintf("instance class %s is not allowed in account %s, allowed instance classes are %s", class, a.id, strings.Join(a.allowedInstanceClasses, ", "))
+	return apierror.New(apierror.ErrLimitExceeded, msg, nil)
+}
+
+// needsUsage returns true if checking the quotas of the account requires its current usage
+func (a *accountSettings) needsUsage() bool {
+	return a.maxClusters > 0 || a.maxVCPU > 0
+}
+
+// checkCreateQuotas returns an error naming the quota exceeded by creating a cluster with the instances
+func (a *accountSettings) checkCreateQuotas(class string, count int, usage *quotaUsage) error {
+	if a.maxInstances > 0 && count > a.maxInstances {
+		msg := fmt.Sprintf("quota exceeded: %d instances requested, clusters in account %s are limited to %d instances", count, a.id, a.maxInstances)
+		return apierror.New(apierror.ErrLimitExceeded, msg, nil)
+	}
+
+	if a.maxClusters > 0 && usage.clusters+1 > a.maxClusters {
+		msg := fmt.Sprintf("quota exceeded: account %s already has %d clusters, limited to %d clusters", a.id, usage.clusters, a.maxClusters)
+		return apierror.New(apierror.ErrLimitExceeded, msg, nil)
+	}
+
+	return a.checkVCPUQuota(class, count, usage.vcpu)
+}
+
+// checkModifyQuotas returns an error naming the quota exceeded by changing the instance class of the cluster
+func (a *accountSettings) checkModifyQuotas(name, class string, count int, usage *quotaUsage) error {
+	return a.checkVCPUQuota(class, count, usage.vcpu-usage.clusterVCPU[name])
+}
+
+// checkVCPUQuota returns an error if adding the instances to the vCPU used by other clusters exceeds the quota
+func (a *accountSettings) checkVCPUQuota(class string, count, used int) error {
+	if a.maxVCPU <= 0 {
+		return nil
+	}
+
+	vcpu, ok := instanceVCPU(class)
+	if !ok {
+		msg := fmt.Sprintf("unable to check the vCPU quota of account %s, unknown vCPU of instance class %s", a.id, class)
+		return apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	if requested := vcpu * count; used+requested > a.maxVCPU {
+		msg := fmt.Sprintf("quota exceeded: %d vCPU requested and %d vCPU used in account %s, limited to %d vCPU", requested, used, a.id, a.maxVCPU)
+		return apierror.New(apierror.ErrLimitExceeded, msg, nil)
+	}
+
+	return nil
+}
+
+// quotaUsage returns the clusters of the org and the vCPU of their instances in all the regions of the account.
+// the clusters of other orgs sharing the account don't count against the quotas.
+func (o *docDBOrchestrator) quotaUsage(ctx context.Context) (*quotaUsage, error) {
+	usage := &quotaUsage{clusterVCPU: map[string]int{}}
+
+	for _, region := range o.server.accountRegions(o.settings) {
+		if err := o.regionUsage(ctx, region, usage); err != nil {
+			return nil, err
+		}
+	}
+
+	return usage, nil
+}
+
+// regionUsage adds the clusters of the org and the vCPU of their instances in the region to the usage.  the
+// clusters of the org are listed in one call to the tagging api, which also lists other rds clusters, so
+// they're matched with the documentDB clusters of the region.
+func (o *docDBOrchestrator) regionUsage(ctx context.Context, region string, usage *quotaUsage) error {
+	c, err := o.usageClientsIn(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	resources, err := c.rgClient.GetResourcesInOrg(ctx, o.server.org, "", "")
+	if err != nil {
+		return err
+	}
+
+	orgArns := map[string]bool{}
+	for _, r := range resources {
+		orgArns[aws.StringValue(r.ResourceARN)] = true
+	}
+
+	clusters, err := c.docdbClient.ListDocDBClusters(ctx)
+	if err != nil {
+		return err
+	}
+
+	orgClusters := map[string]bool{}
+	for _, cl := range clusters {
+		if orgArns[aws.StringValue(cl.DBClusterArn)] {
+			orgClusters[aws.StringValue(cl.DBClusterIdentifier)] = true
+		}
+	}
+	usage.clusters += len(orgClusters)
+
+	if len(orgClusters) == 0 {
+		return nil
+	}
+
+	instances, err := c.docdbClient.ListDocDBInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range instances {
+		if !orgClusters[aws.StringValue(i.DBClusterIdentifier)] {
+			continue
+		}
+
+		// instances of unknown classes don't count against the quota
+		vcpu, _ := instanceVCPU(aws.StringValue(i.DBInstanceClass))
+		usage.vcpu += vcpu
+		if region == o.sp.region {
+			usage.clusterVCPU[aws.StringValue(i.DBClusterIdentifier)] += vcpu
+		}
+	}
+
+	return nil
+}
+
+// usageClientsIn returns read only clients counting the usage in the region, assuming the role of the
+// orchestrator in the region the first time they're needed
+func (o *docDBOrchestrator) usageClientsIn(ctx context.Context, region string) (*usageClients, error) {
+	if c, ok := o.usageClients[region]; ok {
+		return c, nil
+	}
+
+	sess, err := o.server.assumeRole(
+		ctx,
+		o.settings.externalID,
+		region,
+		o.sp.role,
+		"",
+		"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess",
+		"arn:aws:iam::aws:policy/ResourceGroupsandTagEditorReadOnlyAccess",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &usageClients{
+		docdbClient: docdb.New(docdb.WithSession(sess.Session)),
+		rgClient:    resourcegroupstaggingapi.New(resourcegroupstaggingapi.WithSession(sess.Session)),
+	}
+
+	if o.usageClients == nil {
+		o.usageClients = map[string]*usageClients{}
+	}
+	o.usageClients[region] = c
+
+	return c, nil
+}
+
+// checkCreateQuotas returns an error if the create request exceeds the quotas of the account
+func (o *docDBOrchestrator) checkCreateQuotas(ctx context.Context, req *DocDBCreateRequest) error {
+	usage := &quotaUsage{}
+	if o.settings.needsUsage() {
+		u, err := o.quotaUsage(ctx)
+		if err != nil {
+			return err
+		}
+		usage = u
+	}
+
+	return o.settings.checkCreateQuotas(aws.StringValue(req.DBInstanceClass), aws.IntValue(req.InstanceCount), usage)
+}
+
+// checkModifyQuotas returns an error if changing the instance class of the cluster exceeds the quotas of the account
+func (o *docDBOrchestrator) checkModifyQuotas(ctx context.Context, name string, req *DocDBModifyRequest, members int) error {
+	if req.DBInstanceClass == nil || o.settings.maxVCPU <= 0 {
+		return nil
+	}
+
+	usage, err := o.quotaUsage(ctx)
+	if err != nil {
+		return err
+	}
+
+	return o.settings.checkModifyQuotas(name, *req.DBInstanceClass, members, usage)
+}
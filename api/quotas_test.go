@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/YaleSpinup/docdb-api/docdb"
+	"github.com/YaleSpinup/docdb-api/resourcegroupstaggingapi"
+	"github.com/YaleSpinup/docdb-api/session"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awsdocdb "github.com/aws/aws-sdk-go/service/docdb"
+	awsrg "github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	"github.com/pkg/errors"
+)
+
+func (m *mockDocDBClient) DescribeDBClustersPagesWithContext(ctx context.Context, input *awsdocdb.DescribeDBClustersInput, fn func(*awsdocdb.DescribeDBClustersOutput, bool) bool, opts ...request.Option) error {
+	fn(&awsdocdb.DescribeDBClustersOutput{DBClusters: m.clusters}, true)
+	return nil
+}
+
+func (m *mockDocDBClient) DescribeDBInstancesPagesWithContext(ctx context.Context, input *awsdocdb.DescribeDBInstancesInput, fn func(*awsdocdb.DescribeDBInstancesOutput, bool) bool, opts ...request.Option) error {
+	fn(&awsdocdb.DescribeDBInstancesOutput{DBInstances: m.instances}, true)
+	return nil
+}
+
+// isLimitExceeded returns true if the error is an apierror.ErrLimitExceeded
+func isLimitExceeded(err error) bool {
+	aerr, ok := errors.Cause(err).(apierror.Error)
+	return ok && aerr.Code == apierror.ErrLimitExceeded
+}
+
+func TestInstanceVCPU(t *testing.T) {
+	tests := map[string]int{
+		"db.t3.medium":   2,
+		"db.t4g.medium":  2,
+		"db.r5.large":    2,
+		"db.r5.xlarge":   4,
+		"db.r6g.2xlarge": 8,
+		"db.r5.12xlarge": 48,
+		"db.r5.24xlarge": 96,
+	}
+
+	for class, want := range tests {
+		if got, ok := instanceVCPU(class); !ok || got != want {
+			t.Errorf("expected %d vCPU for %s, got %d", want, class, got)
+		}
+	}
+
+	for _, class := range []string{"", "db.r5.huge", "db.r5.0xlarge", "db.r5.axlarge"} {
+		if _, ok := instanceVCPU(class); ok {
+			t.Errorf("expected unknown vCPU for %q", class)
+		}
+	}
+}
+
+func TestAccountSettingsQuotas(t *testing.T) {
+	s := &server{
+		session: session.Session{RoleName: "SpinupRole"},
+		quotas:  common.Quotas{MaxClusters: 10, MaxInstances: 3, MaxVCPU: 64, AllowedInstanceClasses: []string{"db.t3.medium"}},
+		accounts: map[string]common.AccountOverride{
+			"123456789012": {},
+			"000000000000": {MaxClusters: 2, MaxVCPU: 16, AllowedInstanceClasses: []string{"db.r5.large"}},
+		},
+	}
+
+	settings, _ := s.accountSettings("123456789012")
+	if settings.maxClusters != 10 || settings.maxInstances != 3 || settings.maxVCPU != 64 || len(settings.allowedInstanceClasses) != 1 {
+		t.Errorf("expected the org quotas, got %+v", settings)
+	}
+
+	settings, _ = s.accountSettings("000000000000")
+	if settings.maxClusters != 2 || settings.maxInstances != 3 || settings.maxVCPU != 16 || settings.allowedInstanceClasses[0] != "db.r5.large" {
+		t.Errorf("expected the account quotas, got %+v", settings)
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	settings := &accountSettings{id: "123456789012", maxClusters: 3, maxInstances: 3, maxVCPU: 16}
+	usage := &quotaUsage{clusters: 2, vcpu: 8, clusterVCPU: map[string]int{"mydocdb": 4}}
+
+	if err := settings.checkCreateQuotas("db.r5.large", 3, usage); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := settings.checkCreateQuotas("db.r5.large", 4, usage); !isLimitExceeded(err) {
+		t.Errorf("expected instances quota to be exceeded, got %v", err)
+	}
+
+	if err := settings.checkCreateQuotas("db.r5.xlarge", 3, usage); !isLimitExceeded(err) {
+		t.Errorf("expected vCPU quota to be exceeded, got %v", err)
+	}
+
+	usage.clusters = 3
+	if err := settings.checkCreateQuotas("db.r5.large", 1, usage); !isLimitExceeded(err) || !strings.Contains(err.Error(), "limited to 3 clusters") {
+		t.Errorf("expected clusters quota to be exceeded, got %v", err)
+	}
+
+	if err := settings.checkCreateQuotas("db.r5.huge", 1, &quotaUsage{}); err == nil || isLimitExceeded(err) {
+		t.Errorf("expected bad request for an unknown instance class, got %v", err)
+	}
+
+	// the vCPU of the modified cluster is replaced
+	if err := settings.checkModifyQuotas("mydocdb", "db.r5.2xlarge", 1, usage); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := settings.checkModifyQuotas("mydocdb", "db.r5.2xlarge", 2, usage); !isLimitExceeded(err) {
+		t.Errorf("expected vCPU quota to be exceeded, got %v", err)
+	}
+
+	// no quotas
+	if err := (&accountSettings{}).checkCreateQuotas("db.r5.24xlarge", 16, &quotaUsage{}); err != nil {
+		t.Errorf("expected no quotas, got %s", err)
+	}
+
+	if err := (&accountSettings{allowedInstanceClasses: []string{"db.t3.medium"}}).checkInstanceClass("db.r5.24xlarge"); !isLimitExceeded(err) {
+		t.Errorf("expected instance class not to be allowed, got %v", err)
+	}
+}
+
+// mockTaggingClient is a fake tagging api client returning the resources of the org
+type mockTaggingClient struct {
+	resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+	arns []string
+}
+
+func (m *mockTaggingClient) GetResourcesWithContext(ctx context.Context, input *awsrg.GetResourcesInput, opts ...request.Option) (*awsrg.GetResourcesOutput, error) {
+	out := &awsrg.GetResourcesOutput{}
+	for _, a := range m.arns {
+		out.ResourceTagMappingList = append(out.ResourceTagMappingList, &awsrg.ResourceTagMapping{ResourceARN: aws.String(a)})
+	}
+	return out, nil
+}
+
+func TestQuotaUsage(t *testing.T) {
+	east := &mockDocDBClient{
+		clusters: []*awsdocdb.DBCluster{
+			{DBClusterArn: aws.String("arn:aws:rds:us-east-1:123456789012:cluster:mydocdb"), DBClusterIdentifier: aws.String("mydocdb")},
+			{DBClusterArn: aws.String("arn:aws:rds:us-east-1:123456789012:cluster:other"), DBClusterIdentifier: aws.String("other")},
+			{DBClusterArn: aws.String("arn:aws:rds:us-east-1:123456789012:cluster:theirs"), DBClusterIdentifier: aws.String("theirs")},
+		},
+		instances: []*awsdocdb.DBInstance{
+			{DBClusterIdentifier: aws.String("mydocdb"), DBInstanceClass: aws.String("db.r5.large")},
+			{DBClusterIdentifier: aws.String("mydocdb"), DBInstanceClass: aws.String("db.r5.large")},
+			{DBClusterIdentifier: aws.String("other"), DBInstanceClass: aws.String("db.r5.4xlarge")},
+			{DBClusterIdentifier: aws.String("theirs"), DBInstanceClass: aws.String("db.r5.24xlarge")},
+		},
+	}
+
+	west := &mockDocDBClient{
+		clusters: []*awsdocdb.DBCluster{
+			{DBClusterArn: aws.String("arn:aws:rds:us-west-2:123456789012:cluster:mydocdb"), DBClusterIdentifier: aws.String("mydocdb")},
+		},
+		instances: []*awsdocdb.DBInstance{
+			{DBClusterIdentifier: aws.String("mydocdb"), DBInstanceClass: aws.String("db.r5.xlarge")},
+		},
+	}
+
+	o := &docDBOrchestrator{
+		server:   &server{org: "localdev", regions: []string{"us-east-1", "us-west-2"}},
+		sp:       &sessionParams{region: "us-east-1"},
+		settings: &accountSettings{id: "123456789012", region: "us-east-1", maxClusters: 3},
+		usageClients: map[string]*usageClients{
+			// the tagging api lists the clusters of the org, including other rds clusters and the resource id arns
+			"us-east-1": {
+				docdbClient: docdb.DocDB{Service: east},
+				rgClient: &resourcegroupstaggingapi.ResourceGroupsTaggingAPI{Service: &mockTaggingClient{arns: []string{
+					"arn:aws:rds:us-east-1:123456789012:cluster:mydocdb",
+					"arn:aws:rds:us-east-1:123456789012:cluster:other",
+					"arn:aws:rds:us-east-1:123456789012:cluster:cluster-L3R4YRSBUYDP4GLMTJ2WF5GH5Q",
+					"arn:aws:rds:us-east-1:123456789012:cluster:myaurora",
+				}}},
+			},
+			"us-west-2": {
+				docdbClient: docdb.DocDB{Service: west},
+				rgClient: &resourcegroupstaggingapi.ResourceGroupsTaggingAPI{Service: &mockTaggingClient{arns: []string{
+					"arn:aws:rds:us-west-2:123456789012:cluster:mydocdb",
+				}}},
+			},
+		},
+	}
+
+	// the cluster of another org sharing the account doesn't count, the clusters of every region do
+	usage, err := o.quotaUsage(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if usage.clusters != 3 || usage.vcpu != 24 || usage.clusterVCPU["mydocdb"] != 4 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	req := &DocDBCreateRequest{DBInstanceClass: aws.String("db.t3.medium"), InstanceCount: aws.Int(1)}
+	if err := o.checkCreateQuotas(context.TODO(), req); !isLimitExceeded(err) {
+		t.Errorf("expected clusters quota to be exceeded, got %v", err)
+	}
+}
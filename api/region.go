@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// regionQueryParam is the query parameter selecting the region of a request
+	regionQueryParam = "region"
+	// regionHeader is the request header selecting the region of a request, if the query parameter isn't set
+	regionHeader = "X-Region"
+)
+
+type regionContextKey struct{}
+
+// withRegion returns a copy of the context carrying the region of the request
+func withRegion(ctx context.Context, region string) context.Context {
+	return context.WithValue(ctx, regionContextKey{}, region)
+}
+
+// regionFromContext returns the region carried by the context, if any
+func regionFromContext(ctx context.Context) string {
+	if region, ok := ctx.Value(regionContextKey{}).(string); ok {
+		return region
+	}
+	return ""
+}
+
+// requestRegion returns the region selected by the query parameter or header of the request
+func requestRegion(r *http.Request) string {
+	if region := r.URL.Query().Get(regionQueryParam); region != "" {
+		return strings.ToLower(region)
+	}
+	return strings.ToLower(r.Header.Get(regionHeader))
+}
+
+// allowedRegion returns true if the region is in the allowed regions
+func (s *server) allowedRegion(region string) bool {
+	for _, r := range s.regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// accountRegions returns the regions requests can select in the account, its own region first
+func (s *server) accountRegions(settings *accountSettings) []string {
+	regions := []string{settings.region}
+	for _, r := range s.regions {
+		if r != settings.region {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// regionMiddleware validates the region selected by the request against the allowed regions and adds it to
+// the request context.  requests without a region use the region of the account, or the default region.
+func (s *server) regionMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accountRegion := s.defaultRegion
+		if account, ok := mux.Vars(r)["account"]; ok {
+			if settings, err := s.accountSettings(account); err == nil {
+				accountRegion = settings.region
+			}
+		}
+
+		region := requestRegion(r)
+		if region == "" {
+			region = accountRegion
+		}
+
+		if region != accountRegion && !s.allowedRegion(region) {
+			msg := fmt.Sprintf("region %s is not allowed, must be one of %s", region, strings.Join(s.regions, ", "))
+			handleError(w, apierror.New(apierror.ErrBadRequest, msg, nil))
+			return
+		}
+
+		ctx := withRegion(r.Context(), region)
+		ctx = common.WithLogger(ctx, common.Logger(ctx).WithField("region", region))
+
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegionMiddleware(t *testing.T) {
+	s := &server{
+		defaultRegion: "us-east-1",
+		regions:       []string{"us-east-1", "us-west-2"},
+	}
+
+	var got string
+	h := s.regionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = regionFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		status int
+		region string
+	}{
+		{name: "default region", url: "/v1/docdb/123", status: http.StatusOK, region: "us-east-1"},
+		{name: "query parameter", url: "/v1/docdb/123?region=us-west-2", status: http.StatusOK, region: "us-west-2"},
+		{name: "header", url: "/v1/docdb/123", header: "US-WEST-2", status: http.StatusOK, region: "us-west-2"},
+		{name: "query parameter wins over header", url: "/v1/docdb/123?region=us-east-1", header: "us-west-2", status: http.StatusOK, region: "us-east-1"},
+		{name: "region not allowed", url: "/v1/docdb/123?region=eu-west-1", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.header != "" {
+				r.Header.Set(regionHeader, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+
+			if got != tt.region {
+				t.Errorf("expected region %q, got %q", tt.region, got)
+			}
+		})
+	}
+}
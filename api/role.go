@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/YaleSpinup/docdb-api/common"
 	"github.com/YaleSpinup/docdb-api/session"
 	stsSvc "github.com/YaleSpinup/docdb-api/sts"
 	"github.com/aws/aws-sdk-go/aws"
@@ -13,19 +14,32 @@ import (
 	"github.com/google/uuid"
 	cache "github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// assumeRole assumes the passed role arn.  if an externalId is set in the account to be accessed, it can be passed with the request. inline
-// policy can be passed to limit the access for the session.  policy arns can also be passed to limit access for the session.
+// assumeRole assumes the passed role arn for a session in the given region.  if an externalId is set in the account to be accessed, it can be
+// passed with the request. inline policy can be passed to limit the access for the session.  policy arns can also be passed to limit access for the session.
 // Note: sessions live for 900s and will be cached for 600 seconds, giving a 300s buffer to avoid terminated sessions inside of orchestration
-func (s *server) assumeRole(ctx context.Context, externalId, roleArn, inlinePolicy string, policyArns ...string) (*session.Session, error) {
-	contextLogger := log.WithFields(log.Fields{
-		"role": roleArn,
+func (s *server) assumeRole(ctx context.Context, externalId, region, roleArn, inlinePolicy string, policyArns ...string) (*session.Session, error) {
+	ctx, span := startSpan(ctx, "assumeRole", trace.WithAttributes(
+		attribute.String("aws.role", roleArn),
+		attribute.String("aws.region", region),
+	))
+	defer span.End()
+
+	contextLogger := common.Logger(ctx).WithFields(log.Fields{
+		"role":   roleArn,
+		"region": region,
 	})
 
+	cacheResult := "miss"
 	start := time.Now()
 	defer func() {
 		totalTime := time.Since(start)
+		span.SetAttributes(attribute.String("cache", cacheResult))
+		assumeRoleTotal.WithLabelValues(cacheResult).Inc()
+		assumeRoleDuration.WithLabelValues(cacheResult).Observe(totalTime.Seconds())
 		contextLogger.WithField("duration", totalTime).Info("assumeRole()")
 	}()
 
@@ -47,7 +61,7 @@ func (s *server) assumeRole(ctx context.Context, externalId, roleArn, inlinePoli
 		},
 	}
 
-	cacheKey := fmt.Sprintf("spinup_%s_%s", s.org, roleArn)
+	cacheKey := fmt.Sprintf("spinup_%s_%s_%s", s.org, region, roleArn)
 
 	if externalId != "" {
 		input.SetExternalId(externalId)
@@ -75,6 +89,7 @@ func (s *server) assumeRole(ctx context.Context, externalId, roleArn, inlinePoli
 	item, expire, found := s.sessionCache.GetWithExpiration(cacheKey)
 	if found {
 		if sess, ok := item.(*session.Session); ok {
+			cacheResult = "hit"
 			contextLogger.Infof("using cached session (expire: %s)", expire.String())
 			return sess, nil
 		}
@@ -84,8 +99,8 @@ func (s *server) assumeRole(ctx context.Context, externalId, roleArn, inlinePoli
 
 	out, err := stsService.AssumeRole(ctx, &input)
 	if err != nil {
-		log.Errorf("got: %s", err)
-		return nil, err
+		contextLogger.Errorf("got: %s", err)
+		return nil, spanError(span, err)
 	}
 
 	akid := aws.StringValue(out.Credentials.AccessKeyId)
@@ -98,7 +113,7 @@ func (s *server) assumeRole(ctx context.Context, externalId, roleArn, inlinePoli
 			aws.StringValue(out.Credentials.SecretAccessKey),
 			aws.StringValue(out.Credentials.SessionToken),
 		),
-		session.WithRegion("us-east-1"),
+		session.WithRegion(region),
 	)
 
 	contextLogger.Debugf("caching session with cache key: '%s'", cacheKey)
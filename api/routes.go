@@ -28,12 +28,24 @@ func (s *server) routes() {
 	api.HandleFunc("/version", s.VersionHandler).Methods(http.MethodGet)
 	api.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
 
-	api.Handle("/flywheel", s.flywheel.Handler())
-
-	api.HandleFunc("/{account}", s.DocumentDBCreateHandler).Methods(http.MethodPost)
-	api.HandleFunc("/{account}", s.DocumentDBListHandler).Methods(http.MethodGet)
-	api.HandleFunc("/{account}/{name}", s.DocumentDBGetHandler).Methods(http.MethodGet)
-	api.HandleFunc("/{account}/{name}", s.DocumentDBModifyHandler).Methods(http.MethodPut)
-	api.HandleFunc("/{account}/{name}/power", s.DocumentDBStateHandler).Methods(http.MethodPut)
-	api.HandleFunc("/{account}/{name}", s.DocumentDBDeleteHandler).Methods(http.MethodDelete)
+	api.Handle("/flywheel", authorized(opRead, s.taskScoped(s.flywheel.Handler())))
+	api.Handle("/flywheel/deliveries", authorized(opRead, s.WebhookDeliveriesHandler)).Methods(http.MethodGet)
+	api.Handle("/flywheel/{task}/stream", authorized(opRead, s.TaskStreamHandler)).Methods(http.MethodGet)
+
+	api.Handle("/{account}", s.audited(opCreate, authorized(opCreate, s.DocumentDBCreateHandler))).Methods(http.MethodPost)
+	api.Handle("/{account}", authorized(opRead, s.DocumentDBListHandler)).Methods(http.MethodGet)
+	api.Handle("/{account}/estimate", authorized(opRead, s.DocumentDBCreateEstimateHandler)).Methods(http.MethodPost)
+	api.Handle("/{account}/{name}/estimate", authorized(opRead, s.DocumentDBModifyEstimateHandler)).Methods(http.MethodPost)
+	api.Handle("/{account}/{name}", authorized(opRead, s.DocumentDBGetHandler)).Methods(http.MethodGet)
+	api.Handle("/{account}/{name}/clone", s.audited(opCreate, authorized(opCreate, s.DocumentDBCloneHandler))).Methods(http.MethodPost)
+	api.Handle("/{account}/{name}", s.audited(opModify, authorized(opModify, s.DocumentDBModifyHandler))).Methods(http.MethodPut)
+	api.Handle("/{account}/{name}/power", s.audited(opPower, authorized(opPower, s.DocumentDBStateHandler))).Methods(http.MethodPut)
+	api.Handle("/{account}/{name}/power", authorized(opRead, s.DocumentDBStateGetHandler)).Methods(http.MethodGet)
+	api.Handle("/{account}/{name}", s.audited(opDelete, authorized(opDelete, s.DocumentDBDeleteHandler))).Methods(http.MethodDelete)
+	api.Handle("/{account}/{name}/access", authorized(opRead, s.DocumentDBAccessGetHandler)).Methods(http.MethodGet)
+	api.Handle("/{account}/{name}/access", s.audited(opModify, authorized(opModify, s.DocumentDBAccessUpdateHandler))).Methods(http.MethodPut)
+
+	api.Handle("/{account}/{name}/schedules", s.audited(opPower, authorized(opPower, s.DocumentDBScheduleCreateHandler))).Methods(http.MethodPost)
+	api.Handle("/{account}/{name}/schedules", authorized(opRead, s.DocumentDBScheduleListHandler)).Methods(http.MethodGet)
+	api.Handle("/{account}/{name}/schedules/{id}", s.audited(opPower, authorized(opPower, s.DocumentDBScheduleDeleteHandler))).Methods(http.MethodDelete)
 }
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// schedulePrefix is the store key prefix for power schedules
+	schedulePrefix = "schedule:"
+	// scheduleRunPrefix is the store key prefix used by instances to claim a scheduled run
+	scheduleRunPrefix = "schedulerun:"
+	// scheduleInterval is how often the scheduler looks for due power schedules
+	scheduleInterval = 30 * time.Second
+	// scheduleRunTTL is how long a claimed scheduled run is remembered
+	scheduleRunTTL = 1 * time.Hour
+)
+
+// PowerSchedule is a cron style rule to start or stop a documentDB cluster
+type PowerSchedule struct {
+	ID        string
+	Account   string
+	Region    string
+	Cluster   string
+	State     string
+	Schedule  string
+	CreatedAt time.Time
+	NextRun   *time.Time `json:",omitempty"`
+}
+
+// parse validates the power schedule and returns the parsed cron schedule
+func (p *PowerSchedule) parse() (cron.Schedule, error) {
+	switch p.State {
+	case "start", "stop":
+	default:
+		msg := fmt.Sprintf("invalid power schedule state %q, must be start or stop", p.State)
+		return nil, apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	// standard 5 field cron expressions, optionally prefixed with CRON_TZ=<timezone>
+	sched, err := cron.ParseStandard(p.Schedule)
+	if err != nil {
+		msg := fmt.Sprintf("invalid power schedule %q: %s", p.Schedule, err)
+		return nil, apierror.New(apierror.ErrBadRequest, msg, err)
+	}
+
+	return sched, nil
+}
+
+// due returns the time of the scheduled run between from (exclusive) and to (inclusive), if any
+func (p *PowerSchedule) due(sched cron.Schedule, from, to time.Time) (time.Time, bool) {
+	next := sched.Next(from)
+	if next.IsZero() || next.After(to) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// scheduleKey returns the store key of a power schedule of the cluster in the account and region
+func scheduleKey(account, region, cluster, id string) string {
+	return schedulePrefix + account + ":" + region + ":" + cluster + ":" + id
+}
+
+// createSchedule validates and saves a new power schedule
+func (s *server) createSchedule(ctx context.Context, schedule *PowerSchedule) error {
+	schedule.State = strings.ToLower(schedule.State)
+
+	sched, err := schedule.parse()
+	if err != nil {
+		return err
+	}
+
+	schedule.ID = uuid.New().String()
+	schedule.CreatedAt = time.Now().UTC()
+
+	j, err := json.Marshal(schedule)
+	if err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to marshal power schedule", err)
+	}
+
+	if err := s.store.Set(ctx, scheduleKey(schedule.Account, schedule.Region, schedule.Cluster, schedule.ID), string(j), 0); err != nil {
+		return apierror.New(apierror.ErrServiceUnavailable, "failed to save power schedule", err)
+	}
+
+	next := sched.Next(time.Now())
+	schedule.NextRun = &next
+
+	return nil
+}
+
+// listSchedules returns the power schedules of the cluster in the account and region
+func (s *server) listSchedules(ctx context.Context, account, region, cluster string) ([]*PowerSchedule, error) {
+	schedules, err := s.loadSchedules(ctx, schedulePrefix+account+":"+region+":"+cluster+":")
+	if err != nil {
+		return nil, apierror.New(apierror.ErrServiceUnavailable, "failed to list power schedules", err)
+	}
+
+	now := time.Now()
+	for _, schedule := range schedules {
+		if sched, err := schedule.parse(); err == nil {
+			next := sched.Next(now)
+			schedule.NextRun = &next
+		}
+	}
+
+	return schedules, nil
+}
+
+// deleteSchedule deletes the power schedule of the cluster in the account and region
+func (s *server) deleteSchedule(ctx context.Context, account, region, cluster, id string) error {
+	key := scheduleKey(account, region, cluster, id)
+
+	_, found, err := s.store.Get(ctx, key)
+	if err != nil {
+		return apierror.New(apierror.ErrServiceUnavailable, "failed to get power schedule", err)
+	}
+
+	if !found {
+		msg := fmt.Sprintf("power schedule %s not found for docdb cluster %s", id, cluster)
+		return apierror.New(apierror.ErrNotFound, msg, nil)
+	}
+
+	if err := s.store.Delete(ctx, key); err != nil {
+		return apierror.New(apierror.ErrServiceUnavailable, "failed to delete power schedule", err)
+	}
+
+	return nil
+}
+
+// documentDBScheduleCreate creates a power schedule for the cluster, which must belong to our org
+func (o *docDBOrchestrator) documentDBScheduleCreate(ctx context.Context, name string, req *DocDBPowerScheduleRequest) (*PowerSchedule, error) {
+	if _, _, err := o.orgCluster(ctx, name); err != nil {
+		return nil, err
+	}
+
+	schedule := &PowerSchedule{
+		Account:  o.account(),
+		Region:   o.sp.region,
+		Cluster:  name,
+		State:    req.State,
+		Schedule: req.Schedule,
+	}
+
+	if err := o.server.createSchedule(ctx, schedule); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// documentDBSchedules returns the power schedules of the cluster, which must belong to our org
+func (o *docDBOrchestrator) documentDBSchedules(ctx context.Context, name string) ([]*PowerSchedule, error) {
+	if _, _, err := o.orgCluster(ctx, name); err != nil {
+		return nil, err
+	}
+
+	return o.server.listSchedules(ctx, o.account(), o.sp.region, name)
+}
+
+// documentDBScheduleDelete deletes a power schedule of the cluster, which must belong to our org
+func (o *docDBOrchestrator) documentDBScheduleDelete(ctx context.Context, name, id string) error {
+	if _, _, err := o.orgCluster(ctx, name); err != nil {
+		return err
+	}
+
+	return o.server.deleteSchedule(ctx, o.account(), o.sp.region, name, id)
+}
+
+// clearSchedules deletes all power schedules of the cluster in the account and region
+func (s *server) clearSchedules(ctx context.Context, account, region, cluster string) error {
+	if s.store == nil || isDryRun(ctx) {
+		return nil
+	}
+
+	keys, err := s.store.Keys(ctx, schedulePrefix+account+":"+region+":"+cluster+":")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadSchedules returns all power schedules with keys starting with the prefix, ordered by creation time
+func (s *server) loadSchedules(ctx context.Context, prefix string) ([]*PowerSchedule, error) {
+	keys, err := s.store.Keys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []*PowerSchedule{}
+	for _, key := range keys {
+		v, found, err := s.store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			continue
+		}
+
+		schedule := &PowerSchedule{}
+		if err := json.Unmarshal([]byte(v), schedule); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal power schedule %s", key)
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+
+	return schedules, nil
+}
+
+// runSchedules periodically starts and stops clusters according to their power schedules
+func (s *server) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, schedule := range s.claimDueSchedules(ctx, last, now) {
+				go s.runSchedule(ctx, schedule)
+			}
+			last = now
+		}
+	}
+}
+
+// claimDueSchedules returns the power schedules due between from and to.  each run is claimed, so it
+// only happens once when several instances are running.
+func (s *server) claimDueSchedules(ctx context.Context, from, to time.Time) []*PowerSchedule {
+	logger := common.Logger(ctx)
+
+	schedules, err := s.loadSchedules(ctx, schedulePrefix)
+	if err != nil {
+		logger.Errorf("failed to load power schedules: %s", err)
+		return nil
+	}
+
+	due := []*PowerSchedule{}
+	for _, schedule := range schedules {
+		sched, err := schedule.parse()
+		if err != nil {
+			logger.Errorf("invalid power schedule %s for docdb cluster %s: %s", schedule.ID, schedule.Cluster, err)
+			continue
+		}
+
+		next, ok := schedule.due(sched, from, to)
+		if !ok {
+			continue
+		}
+
+		claimed, err := s.store.SetNX(ctx, fmt.Sprintf("%s%s:%d", scheduleRunPrefix, schedule.ID, next.Unix()), s.instanceID, scheduleRunTTL)
+		if err != nil {
+			logger.Errorf("failed to claim power schedule %s run: %s", schedule.ID, err)
+			continue
+		}
+
+		if !claimed {
+			continue
+		}
+
+		due = append(due, schedule)
+	}
+
+	return due
+}
+
+// runSchedule starts or stops the cluster of the power schedule
+func (s *server) runSchedule(ctx context.Context, schedule *PowerSchedule) {
+	ctx = common.WithRequestID(ctx, uuid.New().String())
+	ctx = common.WithLogger(ctx, common.Logger(ctx).WithFields(map[string]interface{}{
+		"account":  schedule.Account,
+		"region":   schedule.Region,
+		"cluster":  schedule.Cluster,
+		"schedule": schedule.ID,
+	}))
+	logger := common.Logger(ctx)
+
+	logger.Infof("running power schedule %s (%s) to %s docdb cluster %s", schedule.ID, schedule.Schedule, schedule.State, schedule.Cluster)
+
+	policy, err := generatePolicy([]string{"rds:StartDBCluster", "rds:StopDBCluster"})
+	if err != nil {
+		logger.Errorf("failed to generate power policy: %s", err)
+		return
+	}
+
+	orch, err := s.newDocDBOrchestrator(ctx, &sessionParams{
+		role:         s.roleArn(schedule.Account),
+		region:       schedule.Region,
+		inlinePolicy: policy,
+		policyArns:   []string{"arn:aws:iam::aws:policy/AmazonDocDBReadOnlyAccess"},
+	})
+	if err != nil {
+		logger.Errorf("unable to create docdb orchestrator for power schedule %s: %s", schedule.ID, err)
+		return
+	}
+
+	task, err := orch.docDBState(ctx, schedule.State, schedule.Cluster)
+	if err != nil {
+		// the cluster is already in the desired state or busy with another operation
+		if aerr, ok := errors.Cause(err).(apierror.Error); ok && aerr.Code == apierror.ErrConflict {
+			logger.Infof("skipping power schedule %s: %s", schedule.ID, err)
+			return
+		}
+
+		logger.Errorf("failed to run power schedule %s: %s", schedule.ID, err)
+		return
+	}
+
+	logger.Infof("power schedule %s started task %s", schedule.ID, task.ID)
+}
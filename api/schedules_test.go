@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPowerScheduleParse(t *testing.T) {
+	tests := []struct {
+		state    string
+		schedule string
+		wantErr  bool
+	}{
+		{state: "start", schedule: "0 8 * * 1-5"},
+		{state: "stop", schedule: "CRON_TZ=America/New_York 0 19 * * 1-5"},
+		{state: "stop", schedule: "@daily"},
+		{state: "reboot", schedule: "0 8 * * *", wantErr: true},
+		{state: "start", schedule: "0 8 * *", wantErr: true},
+		{state: "start", schedule: "CRON_TZ=Nowhere/Special 0 8 * * *", wantErr: true},
+		{state: "start", schedule: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		p := &PowerSchedule{State: tt.state, Schedule: tt.schedule}
+		if _, err := p.parse(); (err != nil) != tt.wantErr {
+			t.Errorf("parse(%s, %q): expected error %t, got %v", tt.state, tt.schedule, tt.wantErr, err)
+		}
+	}
+}
+
+func TestPowerScheduleDue(t *testing.T) {
+	p := &PowerSchedule{State: "start", Schedule: "0 8 * * *"}
+	sched, err := p.parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	day := time.Date(2021, 6, 1, 0, 0, 0, 0, time.Local)
+
+	if next, ok := p.due(sched, day.Add(7*time.Hour+59*time.Minute+45*time.Second), day.Add(8*time.Hour+15*time.Second)); !ok || !next.Equal(day.Add(8*time.Hour)) {
+		t.Errorf("expected schedule to be due at 08:00, got %s (due: %t)", next, ok)
+	}
+
+	if _, ok := p.due(sched, day.Add(8*time.Hour), day.Add(8*time.Hour+30*time.Second)); ok {
+		t.Error("expected schedule not to be due again right after it ran")
+	}
+
+	if _, ok := p.due(sched, day.Add(9*time.Hour), day.Add(9*time.Hour+30*time.Second)); ok {
+		t.Error("expected schedule not to be due at 09:00")
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore(), instanceID: "me"}
+
+	start := &PowerSchedule{Account: "123456789012", Region: "us-east-1", Cluster: "mydocdb", State: "START", Schedule: "0 8 * * 1-5"}
+	if err := s.createSchedule(ctx, start); err != nil {
+		t.Fatalf("unexpected error creating schedule: %s", err)
+	}
+
+	if start.ID == "" || start.State != "start" || start.NextRun == nil {
+		t.Errorf("expected created schedule to have an id, normalized state and next run, got %+v", start)
+	}
+
+	stop := &PowerSchedule{Account: "123456789012", Region: "us-east-1", Cluster: "mydocdb", State: "stop", Schedule: "0 19 * * 1-5"}
+	if err := s.createSchedule(ctx, stop); err != nil {
+		t.Fatalf("unexpected error creating schedule: %s", err)
+	}
+
+	other := &PowerSchedule{Account: "123456789012", Region: "us-east-1", Cluster: "otherdocdb", State: "stop", Schedule: "0 19 * * *"}
+	if err := s.createSchedule(ctx, other); err != nil {
+		t.Fatalf("unexpected error creating schedule: %s", err)
+	}
+
+	if err := s.createSchedule(ctx, &PowerSchedule{Account: "123456789012", Region: "us-east-1", Cluster: "mydocdb", State: "start", Schedule: "never"}); err == nil {
+		t.Error("expected error creating invalid schedule, got nil")
+	}
+
+	list, err := s.listSchedules(ctx, "123456789012", "us-east-1", "mydocdb")
+	if err != nil {
+		t.Fatalf("unexpected error listing schedules: %s", err)
+	}
+
+	if len(list) != 2 || list[0].ID != start.ID || list[1].ID != stop.ID {
+		t.Errorf("expected start and stop schedules for mydocdb, got %+v", list)
+	}
+
+	// every schedule is due within a week, and claimed only once
+	now := time.Now()
+	if due := s.claimDueSchedules(ctx, now, now.Add(7*24*time.Hour)); len(due) != 3 {
+		t.Errorf("expected 3 due schedules, got %d", len(due))
+	}
+
+	if due := s.claimDueSchedules(ctx, now, now.Add(7*24*time.Hour)); len(due) != 0 {
+		t.Errorf("expected already claimed schedules not to be due again, got %d", len(due))
+	}
+
+	if err := s.deleteSchedule(ctx, "123456789012", "us-east-1", "mydocdb", start.ID); err != nil {
+		t.Fatalf("unexpected error deleting schedule: %s", err)
+	}
+
+	if err := s.deleteSchedule(ctx, "123456789012", "us-east-1", "mydocdb", start.ID); err == nil {
+		t.Error("expected error deleting missing schedule, got nil")
+	}
+
+	if list, _ := s.listSchedules(ctx, "123456789012", "us-east-1", "mydocdb"); len(list) != 1 || list[0].ID != stop.ID {
+		t.Errorf("expected only the stop schedule for mydocdb, got %+v", list)
+	}
+
+	// clearing the schedules of a cluster leaves the other clusters alone
+	if err := s.clearSchedules(ctx, "123456789012", "us-east-1", "mydocdb"); err != nil {
+		t.Fatalf("unexpected error clearing schedules: %s", err)
+	}
+
+	if list, _ := s.listSchedules(ctx, "123456789012", "us-east-1", "mydocdb"); len(list) != 0 {
+		t.Errorf("expected no schedules for mydocdb, got %+v", list)
+	}
+
+	if list, _ := s.listSchedules(ctx, "123456789012", "us-east-1", "otherdocdb"); len(list) != 1 || list[0].ID != other.ID {
+		t.Errorf("expected the otherdocdb schedule to be kept, got %+v", list)
+	}
+}
@@ -23,12 +23,17 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/YaleSpinup/docdb-api/common"
 	"github.com/YaleSpinup/docdb-api/iam"
 	"github.com/YaleSpinup/docdb-api/session"
 	"github.com/YaleSpinup/flywheel"
+	"github.com/google/uuid"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	cache "github.com/patrickmn/go-cache"
@@ -50,16 +55,40 @@ type apiVersion struct {
 }
 
 type server struct {
-	router       *mux.Router
-	version      *apiVersion
-	context      context.Context
-	session      session.Session
-	sessionCache *cache.Cache
-	flywheel     *flywheel.Manager
-	orgPolicy    string
-	org          string
+	router          *mux.Router
+	version         *apiVersion
+	context         context.Context
+	session         session.Session
+	sessionCache    *cache.Cache
+	flywheel        *flywheel.Manager
+	store           kvStore
+	instanceID      string
+	regions         []string
+	accounts        map[string]common.AccountOverride
+	accountDefaults common.Account
+	tokens          []common.APIToken
+	quotas          common.Quotas
+	audit           auditSink
+	notifier        *notifier
+	streams         *taskBroker
+	pricing         *pricingFile
+	defaultRegion   string
+	tasks           sync.WaitGroup
+	tasksMu         sync.Mutex
+	draining        bool
+	orgPolicy       string
+	org             string
 }
 
+// defaultRegion is used when no region is configured
+const defaultRegion = "us-east-1"
+
+// defaultShutdownTimeout is used when no shutdown timeout is configured
+const defaultShutdownTimeout = 30 * time.Second
+
+// taskFailTimeout is how long to wait for unfinished tasks to be marked failed after the shutdown deadline
+const taskFailTimeout = 5 * time.Second
+
 // NewServer creates a new server and starts it
 func NewServer(config common.Config) error {
 	// setup server context with cancellation
@@ -75,6 +104,44 @@ func NewServer(config common.Config) error {
 		context:      ctx,
 		org:          config.Org,
 		sessionCache: cache.New(600*time.Second, 900*time.Second),
+		instanceID:   uuid.New().String(),
+	}
+
+	s.defaultRegion = config.Account.Region
+	if s.defaultRegion == "" {
+		s.defaultRegion = defaultRegion
+	}
+
+	s.regions = []string{s.defaultRegion}
+	for _, r := range config.Account.Regions {
+		if r = strings.ToLower(r); r != s.defaultRegion {
+			s.regions = append(s.regions, r)
+		}
+	}
+
+	s.accounts = config.Accounts
+	s.accountDefaults = config.Account
+	s.tokens = config.Tokens
+	s.quotas = config.Quotas
+
+	audit, err := newAuditSink(config.Audit)
+	if err != nil {
+		return err
+	}
+	s.audit = audit
+
+	notifier, err := newNotifier(config.Webhooks)
+	if err != nil {
+		return err
+	}
+	s.notifier = notifier
+
+	if config.PricingFile != "" {
+		pricing, err := newPricingFile(config.PricingFile)
+		if err != nil {
+			return err
+		}
+		s.pricing = pricing
 	}
 
 	s.version = &apiVersion{
@@ -89,12 +156,29 @@ func NewServer(config common.Config) error {
 	}
 	s.orgPolicy = orgPolicy
 
+	shutdownTracing, err := setupTracing(ctx, config.Tracing, config.Version.Version)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Errorf("failed to shutdown tracing: %s", err)
+		}
+	}()
+
 	manager, err := newFlywheelManager(config.Flywheel)
 	if err != nil {
 		return err
 	}
 	s.flywheel = manager
 
+	store, err := newStore(config.Flywheel)
+	if err != nil {
+		return err
+	}
+	s.store = store
+	s.streams = newTaskBroker(store)
+
 	// Create a new session used for authentication and assuming cross account roles
 	log.Debugf("Creating new session with key '%s' in region '%s'", config.Account.Akid, config.Account.Region)
 	s.session = session.New(
@@ -104,6 +188,24 @@ func NewServer(config common.Config) error {
 		session.WithExternalRoleName(config.Account.Role),
 	)
 
+	// background loops starting tasks, stopped first when shutting down
+	loops, stopLoops := context.WithCancel(ctx)
+	defer stopLoops()
+
+	// resume unfinished tasks left behind by other instances
+	if s.store.Persistent() {
+		go s.resumeTasks(loops)
+	}
+
+	// start and stop clusters according to their power schedules
+	if !s.store.Persistent() {
+		log.Warn("power schedules and keep stopped flags are only kept in memory and are lost on restart, configure the flywheel redis to persist them")
+	}
+	go s.runSchedules(loops)
+
+	// stop clusters flagged keep stopped that AWS started again
+	go s.reconcileKeepStopped(loops)
+
 	publicURLs := map[string]string{
 		"/v1/docdb/ping":    "public",
 		"/v1/docdb/version": "public",
@@ -112,11 +214,19 @@ func NewServer(config common.Config) error {
 
 	// load routes
 	s.routes()
+	s.router.Use(contextLoggerMiddleware, s.accountMiddleware, s.regionMiddleware, s.callbackMiddleware, dryRunMiddleware, routeTracingMiddleware, s.metricsMiddleware)
 
 	if config.ListenAddress == "" {
 		config.ListenAddress = ":8080"
 	}
-	handler := handlers.RecoveryHandler()(handlers.LoggingHandler(os.Stdout, TokenMiddleware([]byte(config.Token), publicURLs, s.router)))
+	var bearer bearerValidator
+	if config.OIDC.JWKS != "" {
+		if bearer, err = newJWTValidator(config.OIDC); err != nil {
+			return err
+		}
+	}
+
+	handler := handlers.RecoveryHandler()(RequestIDMiddleware(TracingMiddleware(handlers.LoggingHandler(os.Stdout, TokenMiddleware([]byte(config.Token), s.apiTokens, bearer, publicURLs, s.router)))))
 	srv := &http.Server{
 		Handler:      handler,
 		Addr:         config.ListenAddress,
@@ -124,14 +234,91 @@ func NewServer(config common.Config) error {
 		ReadTimeout:  15 * time.Second,
 	}
 
-	log.Infof("Starting listener on %s", config.ListenAddress)
-	if err := srv.ListenAndServe(); err != nil {
+	shutdownTimeout := defaultShutdownTimeout
+	if config.ShutdownTimeout != "" {
+		if shutdownTimeout, err = time.ParseDuration(config.ShutdownTimeout); err != nil {
+			return err
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("Starting listener on %s", config.ListenAddress)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	select {
+	case err := <-errs:
 		return err
+	case sig := <-sigs:
+		log.Infof("received %s, shutting down", sig)
 	}
 
+	s.shutdown(srv, stopLoops, cancel, shutdownTimeout)
+
 	return nil
 }
 
+// shutdown stops the background loops and the server from accepting new requests, and waits until the
+// timeout for in-flight requests and orchestration tasks to finish.  New tasks are refused once the requests
+// are drained.  When the timeout is reached, the server context is cancelled which fails any unfinished
+// flywheel tasks.
+func (s *server) shutdown(srv *http.Server, stopLoops, cancel context.CancelFunc, timeout time.Duration) {
+	deadline, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+
+	stopLoops()
+
+	if err := srv.Shutdown(deadline); err != nil {
+		log.Errorf("failed to drain in-flight requests: %s", err)
+	}
+
+	// requests are drained, so no more audit events are queued
+	if q, ok := s.audit.(*queuedSink); ok {
+		if err := q.Close(deadline); err != nil {
+			log.Errorf("failed to write queued audit events: %s", err)
+		}
+	}
+
+	s.drainTasks()
+
+	finished := make(chan struct{})
+	go func() {
+		s.tasks.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		log.Info("all in-flight tasks finished, shutdown complete")
+		return
+	case <-deadline.Done():
+		log.Warnf("shutdown timeout (%s) reached, failing unfinished tasks", timeout)
+	}
+
+	cancel()
+
+	select {
+	case <-finished:
+		log.Info("unfinished tasks marked failed, shutdown complete")
+	case <-time.After(taskFailTimeout):
+		log.Error("timeout waiting for unfinished tasks to be marked failed")
+	}
+}
+
+// done returns a channel that's closed when the server is shutting down and tasks should be abandoned
+func (s *server) done() <-chan struct{} {
+	if s.context == nil {
+		return nil
+	}
+	return s.context.Done()
+}
+
 // LogWriter is an http.ResponseWriter
 type LogWriter struct {
 	http.ResponseWriter
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"testing"
 	"time"
 )
@@ -79,3 +80,58 @@ func TestRetry(t *testing.T) {
 		t.Errorf("unexpected error for successful retry, got %s", err)
 	}
 }
+
+func TestShutdown(t *testing.T) {
+	// tasks finishing before the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &server{context: ctx}
+
+	s.tasks.Add(1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.tasks.Done()
+	}()
+
+	loopsStopped := false
+	s.shutdown(&http.Server{}, func() { loopsStopped = true }, cancel, 5*time.Second)
+
+	if ctx.Err() != nil {
+		t.Error("expected server context not to be cancelled when tasks finish before the timeout")
+	}
+	cancel()
+
+	if !loopsStopped {
+		t.Error("expected the background loops to be stopped")
+	}
+
+	// no task starts once the server is draining
+	if s.trackTask() {
+		t.Error("expected a new task to be refused after shutdown")
+	}
+
+	// tasks still running at the timeout
+	ctx, cancel = context.WithCancel(context.Background())
+	s = &server{context: ctx}
+
+	abandoned := false
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+		select {
+		case <-s.done():
+			abandoned = true
+		case <-time.After(10 * time.Second):
+		}
+	}()
+
+	start := time.Now()
+	s.shutdown(&http.Server{}, func() {}, cancel, 10*time.Millisecond)
+
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected shutdown to return shortly after the timeout, took %s", d)
+	}
+
+	if !abandoned {
+		t.Error("expected unfinished task to be abandoned when the shutdown timeout is reached")
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/go-redis/redis/v8"
+	cache "github.com/patrickmn/go-cache"
+)
+
+// kvStore is a small key/value store used to persist API state, like in-flight orchestration tasks
+type kvStore interface {
+	// Get returns the value of the key and whether it was found
+	Get(ctx context.Context, key string) (string, bool, error)
+	// Set sets the value of the key, a zero ttl never expires
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// SetNX sets the value of the key only if it doesn't exist and reports whether it was set
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// Delete removes the key
+	Delete(ctx context.Context, key string) error
+	// Keys returns all keys starting with the prefix
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	// Persistent reports whether the stored data survives a restart of the API
+	Persistent() bool
+}
+
+// newStore returns a redis backed store using the flywheel redis configuration, or an in-memory
+// store if no redis address is configured
+func newStore(config common.Flywheel) (kvStore, error) {
+	if config.RedisAddress == "" {
+		return newMemoryStore(), nil
+	}
+
+	opts := &redis.Options{
+		Addr:     config.RedisAddress,
+		Username: config.RedisUsername,
+		Password: config.RedisPassword,
+	}
+
+	if config.RedisDatabase != "" {
+		db, err := strconv.Atoi(config.RedisDatabase)
+		if err != nil {
+			return nil, err
+		}
+		opts.DB = db
+	}
+
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = "docdbapi"
+	}
+
+	return &redisStore{
+		client: redis.NewClient(opts),
+		prefix: namespace + ":state:",
+	}, nil
+}
+
+// redisStore is a kvStore in redis, all keys are prefixed with the namespace
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (r *redisStore) Get(ctx context.Context, key string) (string, bool, error) {
+	v, err := r.client.Get(ctx, r.prefix+key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+func (r *redisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+func (r *redisStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.prefix+key, value, ttl).Result()
+}
+
+func (r *redisStore) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+func (r *redisStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	iter := r.client.Scan(ctx, 0, r.prefix+prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), r.prefix))
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (r *redisStore) Persistent() bool {
+	return true
+}
+
+// memoryStore is a kvStore in memory, data is lost when the API restarts
+type memoryStore struct {
+	cache *cache.Cache
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		cache: cache.New(cache.NoExpiration, 10*time.Minute),
+	}
+}
+
+func (m *memoryStore) Get(ctx context.Context, key string) (string, bool, error) {
+	v, found := m.cache.Get(key)
+	if !found {
+		return "", false, nil
+	}
+	return v.(string), true, nil
+}
+
+func (m *memoryStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.cache.Set(key, value, memoryTTL(ttl))
+	return nil
+}
+
+func (m *memoryStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	if err := m.cache.Add(key, value, memoryTTL(ttl)); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, key string) error {
+	m.cache.Delete(key)
+	return nil
+}
+
+func (m *memoryStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	for k := range m.cache.Items() {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memoryStore) Persistent() bool {
+	return false
+}
+
+// memoryTTL converts a store ttl to a go-cache expiration, where zero never expires
+func memoryTTL(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		return cache.NoExpiration
+	}
+	return ttl
+}
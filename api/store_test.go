@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+)
+
+func TestNewStore(t *testing.T) {
+	store, err := newStore(common.Flywheel{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, ok := store.(*memoryStore); !ok {
+		t.Errorf("expected memory store without a redis address, got %T", store)
+	}
+
+	store, err = newStore(common.Flywheel{Namespace: "test", RedisAddress: "127.0.0.1:6379", RedisDatabase: "2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rs, ok := store.(*redisStore)
+	if !ok {
+		t.Fatalf("expected redis store with a redis address, got %T", store)
+	}
+
+	if rs.prefix != "test:state:" {
+		t.Errorf("expected key prefix test:state:, got %s", rs.prefix)
+	}
+
+	if !rs.Persistent() {
+		t.Error("expected redis store to be persistent")
+	}
+
+	if _, err := newStore(common.Flywheel{RedisAddress: "127.0.0.1:6379", RedisDatabase: "zero"}); err == nil {
+		t.Error("expected error for invalid redis database, got nil")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+
+	if store.Persistent() {
+		t.Error("expected memory store not to be persistent")
+	}
+
+	if _, found, err := store.Get(ctx, "missing"); err != nil || found {
+		t.Errorf("expected missing key not to be found, got found: %t, err: %v", found, err)
+	}
+
+	if err := store.Set(ctx, "task:1", "one", 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if v, found, err := store.Get(ctx, "task:1"); err != nil || !found || v != "one" {
+		t.Errorf("expected task:1 to be one, got %s (found: %t, err: %v)", v, found, err)
+	}
+
+	if ok, _ := store.SetNX(ctx, "task:1", "uno", 0); ok {
+		t.Error("expected SetNX of an existing key to fail")
+	}
+
+	if ok, _ := store.SetNX(ctx, "task:2", "two", time.Minute); !ok {
+		t.Error("expected SetNX of a new key to succeed")
+	}
+
+	if err := store.Set(ctx, "other:1", "other", 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	keys, err := store.Keys(ctx, "task:")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sort.Strings(keys)
+
+	if expected := []string{"task:1", "task:2"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected keys %v, got %v", expected, keys)
+	}
+
+	if err := store.Delete(ctx, "task:1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, found, _ := store.Get(ctx, "task:1"); found {
+		t.Error("expected deleted key not to be found")
+	}
+
+	// expired keys aren't returned
+	if err := store.Set(ctx, "task:3", "three", time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, found, _ := store.Get(ctx, "task:3"); found {
+		t.Error("expected expired key not to be found")
+	}
+}
@@ -0,0 +1,365 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// taskStreamRetention is how long the events of a finished task can still be streamed
+	taskStreamRetention = 5 * time.Minute
+	// taskStreamKeepalive is how often an idle stream sends a comment to keep the connection open
+	taskStreamKeepalive = 15 * time.Second
+	// taskStreamBuffer is how many events a slow subscriber can fall behind before it's disconnected
+	taskStreamBuffer = 64
+	// taskStreamPrefix is the store key prefix for the events of a task shared with the other instances
+	taskStreamPrefix = "stream:"
+	// taskStreamPollInterval is how often the stream of a task running on another instance looks for new events
+	taskStreamPollInterval = 2 * time.Second
+
+	taskEventMessage   = "message"
+	taskEventCompleted = "completed"
+	taskEventFailed    = "failed"
+	// taskEventReleased ends the stream when the task is released to be resumed by another instance
+	taskEventReleased = "released"
+)
+
+// taskEvent is a progress message or the final status of a task
+type taskEvent struct {
+	ID      int       `json:"-"`
+	Type    string    `json:"-"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// final returns true if the event ends the stream of the task
+func (e taskEvent) final() bool {
+	return e.Type != taskEventMessage
+}
+
+// storedTaskEvent is a task event shared through the store
+type storedTaskEvent struct {
+	ID      int
+	Type    string
+	Message string
+	Time    time.Time
+}
+
+// storedTaskStream is the account and the events of a task shared through the store, so the task can be streamed
+// from any instance and its events continue when it's resumed by another instance
+type storedTaskStream struct {
+	Account string
+	Events  []storedTaskEvent
+}
+
+// taskStream is the history and the subscribers of the events of a task in the account
+type taskStream struct {
+	account     string
+	events      []taskEvent
+	subscribers map[chan taskEvent]struct{}
+	done        bool
+
+	// saveMu orders the saves of the events to the store, saved is the number of events saved
+	saveMu sync.Mutex
+	saved  int
+}
+
+// taskSubscription is the account, the past events and the next events of a task, until stop is called
+type taskSubscription struct {
+	account string
+	history []taskEvent
+	events  <-chan taskEvent
+	stop    func()
+}
+
+// taskBroker publishes the events of the tasks running on this instance to subscribers.  with a store, the events
+// are shared with the other instances, which poll them for their subscribers.
+type taskBroker struct {
+	mu           sync.Mutex
+	streams      map[string]*taskStream
+	store        kvStore
+	pollInterval time.Duration
+}
+
+func newTaskBroker(store kvStore) *taskBroker {
+	return &taskBroker{
+		streams:      map[string]*taskStream{},
+		store:        store,
+		pollInterval: taskStreamPollInterval,
+	}
+}
+
+// open starts the stream of the task in the account, so it can be subscribed before the first event.  the stream
+// of a task resumed from another instance continues after its stored events.
+func (b *taskBroker) open(taskID, account string) {
+	if b == nil {
+		return
+	}
+
+	stored, _, err := b.load(context.Background(), taskID)
+	if err != nil {
+		log.Errorf("failed to load the events of task %s: %s", taskID, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.streams[taskID]; ok {
+		return
+	}
+
+	stream := &taskStream{account: account, subscribers: map[chan taskEvent]struct{}{}}
+	if stored != nil {
+		for _, e := range stored.Events {
+			stream.events = append(stream.events, taskEvent(e))
+		}
+		stream.saved = len(stream.events)
+	}
+	b.streams[taskID] = stream
+}
+
+// load returns the events of the task from the store, or false if they aren't stored
+func (b *taskBroker) load(ctx context.Context, taskID string) (*storedTaskStream, bool, error) {
+	if b.store == nil {
+		return nil, false, nil
+	}
+
+	v, found, err := b.store.Get(ctx, taskStreamPrefix+taskID)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	stored := &storedTaskStream{}
+	if err := json.Unmarshal([]byte(v), stored); err != nil {
+		return nil, false, err
+	}
+
+	return stored, true, nil
+}
+
+// save stores the events of the stream, unless newer events were already saved.  the events are kept as long
+// as the task state, and for the retention period once the task completed or failed.
+func (b *taskBroker) save(taskID string, stream *taskStream, account string, events []taskEvent) {
+	if b.store == nil {
+		return
+	}
+
+	stream.saveMu.Lock()
+	defer stream.saveMu.Unlock()
+
+	if len(events) <= stream.saved {
+		return
+	}
+
+	stored := storedTaskStream{Account: account}
+	for _, e := range events {
+		stored.Events = append(stored.Events, storedTaskEvent(e))
+	}
+
+	j, err := json.Marshal(stored)
+	if err != nil {
+		log.Errorf("failed to marshal the events of task %s: %s", taskID, err)
+		return
+	}
+
+	ttl := taskStateTTL
+	if last := events[len(events)-1]; last.final() && last.Type != taskEventReleased {
+		ttl = taskStreamRetention
+	}
+
+	if err := b.store.Set(context.Background(), taskStreamPrefix+taskID, string(j), ttl); err != nil {
+		log.Errorf("failed to save the events of task %s: %s", taskID, err)
+		return
+	}
+	stream.saved = len(events)
+}
+
+// publish sends the event to the subscribers of the task and keeps it for later subscribers.  a final event
+// closes the stream, which is forgotten after the retention period.
+func (b *taskBroker) publish(taskID, eventType, message string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	stream, ok := b.streams[taskID]
+	if !ok || stream.done {
+		b.mu.Unlock()
+		return
+	}
+
+	event := taskEvent{
+		ID:      len(stream.events) + 1,
+		Type:    eventType,
+		Message: message,
+		Time:    time.Now().UTC(),
+	}
+	stream.events = append(stream.events, event)
+
+	for ch := range stream.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// the subscriber can't keep up
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	if event.final() {
+		stream.done = true
+		for ch := range stream.subscribers {
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+
+		time.AfterFunc(taskStreamRetention, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if b.streams[taskID] == stream {
+				delete(b.streams, taskID)
+			}
+		})
+	}
+
+	events := append([]taskEvent{}, stream.events...)
+	b.mu.Unlock()
+
+	// share the events with the other instances outside of the lock, so the store doesn't hold up other tasks
+	b.save(taskID, stream, stream.account, events)
+}
+
+// subscribe returns the past events of the task and a channel receiving the next ones, which is closed after
+// the final event.  it returns false if the task isn't running on this instance.
+func (b *taskBroker) subscribe(taskID string) ([]taskEvent, <-chan taskEvent, func(), bool) {
+	if b == nil {
+		return nil, nil, nil, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stream, ok := b.streams[taskID]
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	history := append([]taskEvent{}, stream.events...)
+	ch := make(chan taskEvent, taskStreamBuffer)
+	if stream.done {
+		close(ch)
+		return history, ch, func() {}, true
+	}
+
+	stream.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := stream.subscribers[ch]; ok {
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return history, ch, unsubscribe, true
+}
+
+// follow subscribes to the events of the task, from this instance when it runs the task, otherwise from the
+// store, polling it for new events until the final event.  the stream of a task released by this instance is
+// followed from the store, where the instance that resumed it publishes its events.  it returns false if the
+// task isn't known.
+func (b *taskBroker) follow(ctx context.Context, taskID string) (*taskSubscription, bool, error) {
+	if b == nil {
+		return nil, false, nil
+	}
+
+	b.mu.Lock()
+	stream, ok := b.streams[taskID]
+	released := ok && stream.done && stream.events[len(stream.events)-1].Type == taskEventReleased
+	b.mu.Unlock()
+
+	if ok && (!released || b.store == nil) {
+		history, events, unsubscribe, ok := b.subscribe(taskID)
+		if ok {
+			return &taskSubscription{account: stream.account, history: history, events: events, stop: unsubscribe}, true, nil
+		}
+	}
+
+	stored, found, err := b.load(ctx, taskID)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	sub := &taskSubscription{account: stored.Account}
+	for _, e := range stored.Events {
+		sub.history = append(sub.history, taskEvent(e))
+	}
+
+	ch := make(chan taskEvent, taskStreamBuffer)
+	sub.events = ch
+
+	if len(sub.history) > 0 && sub.history[len(sub.history)-1].final() {
+		close(ch)
+		sub.stop = func() {}
+		return sub, true, nil
+	}
+
+	pollCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stopped := make(chan struct{})
+	sub.stop = func() {
+		cancel()
+		<-stopped
+	}
+
+	go b.poll(pollCtx, taskID, len(sub.history), ch, stopped)
+
+	return sub, true, nil
+}
+
+// poll sends the events of the task stored after the given number of events, until the final event, the
+// events expire, or the context is cancelled
+func (b *taskBroker) poll(ctx context.Context, taskID string, sent int, ch chan<- taskEvent, stopped chan<- struct{}) {
+	defer close(stopped)
+	defer close(ch)
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stored, found, err := b.load(ctx, taskID)
+		if err != nil {
+			log.Errorf("failed to poll the events of task %s: %s", taskID, err)
+			continue
+		}
+
+		if !found {
+			return
+		}
+
+		for _, e := range stored.Events[min(sent, len(stored.Events)):] {
+			select {
+			case ch <- taskEvent(e):
+			default:
+				// the subscriber can't keep up
+				return
+			}
+
+			if taskEvent(e).final() {
+				return
+			}
+		}
+		sent = len(stored.Events)
+	}
+}
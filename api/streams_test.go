@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/gorilla/mux"
+)
+
+func TestTaskBroker(t *testing.T) {
+	b := newTaskBroker(nil)
+
+	if _, _, _, ok := b.subscribe("task-1"); ok {
+		t.Fatal("expected subscribing to an unknown task to fail")
+	}
+
+	b.open("task-1", "123456789012")
+	b.publish("task-1", taskEventMessage, "requested creation of docdb cluster mydocdb")
+
+	history, events, unsubscribe, ok := b.subscribe("task-1")
+	if !ok {
+		t.Fatal("expected to subscribe to an open task")
+	}
+	defer unsubscribe()
+
+	if len(history) != 1 || history[0].ID != 1 || history[0].Type != taskEventMessage {
+		t.Errorf("expected the past message, got %+v", history)
+	}
+
+	b.publish("task-1", taskEventMessage, "docdb cluster mydocdb is available")
+	b.publish("task-1", taskEventCompleted, "task task-1 completed")
+	b.publish("task-1", taskEventMessage, "ignored after the final event")
+
+	got := []taskEvent{}
+	for e := range events {
+		got = append(got, e)
+	}
+
+	if len(got) != 2 || got[0].ID != 2 || got[1].Type != taskEventCompleted {
+		t.Errorf("expected a message and the final event, got %+v", got)
+	}
+
+	// late subscribers get the whole history of a finished task
+	history, events, _, ok = b.subscribe("task-1")
+	if !ok || len(history) != 3 {
+		t.Fatalf("expected the history of the finished task, got %+v", history)
+	}
+
+	if _, open := <-events; open {
+		t.Error("expected the channel of a finished task to be closed")
+	}
+
+	// a nil broker is disabled
+	var nb *taskBroker
+	nb.open("task-1", "123456789012")
+	nb.publish("task-1", taskEventMessage, "msg")
+	if _, _, _, ok := nb.subscribe("task-1"); ok {
+		t.Error("expected subscribing to a nil broker to fail")
+	}
+
+	if sub, ok, err := b.follow(context.TODO(), "task-1"); err != nil || !ok || sub.account != "123456789012" {
+		t.Errorf("expected the account of the task, got %+v, %t, %v", sub, ok, err)
+	}
+}
+
+func TestTaskBrokerSharedEvents(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+
+	// the task runs on the first instance, the second follows it through the store
+	running, other := newTaskBroker(store), newTaskBroker(store)
+	other.pollInterval = 10 * time.Millisecond
+
+	if _, ok, err := other.follow(ctx, "task-1"); ok || err != nil {
+		t.Fatalf("expected following an unknown task to fail, got %t, %v", ok, err)
+	}
+
+	running.open("task-1", "123456789012")
+	running.publish("task-1", taskEventMessage, "requested creation of docdb cluster mydocdb")
+
+	sub, ok, err := other.follow(ctx, "task-1")
+	if err != nil || !ok {
+		t.Fatalf("expected to follow the task from the store, got %t, %v", ok, err)
+	}
+	defer sub.stop()
+
+	if sub.account != "123456789012" || len(sub.history) != 1 || sub.history[0].ID != 1 {
+		t.Errorf("expected the account and the past events, got %+v", sub)
+	}
+
+	// the task is released and resumed by the other instance, its events continue after the stored ones
+	running.publish("task-1", taskEventReleased, "docdb-api is shutting down")
+	other.open("task-1", "123456789012")
+	other.publish("task-1", taskEventMessage, "docdb cluster mydocdb is available")
+	other.publish("task-1", taskEventCompleted, "task task-1 completed")
+
+	got := []taskEvent{}
+	for e := range sub.events {
+		got = append(got, e)
+	}
+
+	if len(got) != 1 || got[0].ID != 2 || got[0].Type != taskEventReleased {
+		t.Errorf("expected the stream to end with the released event, got %+v", got)
+	}
+
+	// the releasing instance follows the rest of the task from the store
+	sub, ok, err = running.follow(ctx, "task-1")
+	if err != nil || !ok {
+		t.Fatalf("expected to follow the resumed task, got %t, %v", ok, err)
+	}
+	defer sub.stop()
+
+	if len(sub.history) != 4 || sub.history[2].ID != 3 || sub.history[3].Type != taskEventCompleted {
+		t.Errorf("expected the events of the resumed task, got %+v", sub.history)
+	}
+
+	if _, open := <-sub.events; open {
+		t.Error("expected the events of a finished task to be closed")
+	}
+}
+
+func TestTaskStreamHandler(t *testing.T) {
+	s := &server{streams: newTaskBroker(nil)}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/docdb/flywheel/{task}/stream", s.TaskStreamHandler)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/v1/docdb/flywheel/task-1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown task, got %d", http.StatusNotFound, res.StatusCode)
+	}
+
+	s.streams.open("task-1", "123456789012")
+	s.streams.publish("task-1", taskEventMessage, "requested deletion of docdb cluster mydocdb")
+
+	// a token without the account of the task
+	rr := httptest.NewRecorder()
+	forbidden := httptest.NewRequest(http.MethodGet, "/v1/docdb/flywheel/task-1/stream", nil)
+	router.ServeHTTP(rr, forbidden.WithContext(withToken(forbidden.Context(), &common.APIToken{Name: "other", Accounts: []string{"000000000000"}, Operations: []string{opRead}})))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for a token without the account of the task, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/docdb/flywheel/task-1/stream", nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected event stream content type, got %q", ct)
+	}
+
+	reader := bufio.NewReader(res.Body)
+	readEvent := func() string {
+		lines := []string{}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("unexpected error reading event: %s", err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if e := readEvent(); !strings.HasPrefix(e, "id: 1\nevent: message\ndata: ") || !strings.Contains(e, "requested deletion") {
+		t.Errorf("unexpected first event %q", e)
+	}
+
+	s.streams.publish("task-1", taskEventFailed, "failed to delete docdb cluster mydocdb")
+
+	if e := readEvent(); !strings.HasPrefix(e, "id: 2\nevent: failed\n") {
+		t.Errorf("unexpected final event %q", e)
+	}
+
+	// a reconnecting client resumes after the last event it got
+	req.Header.Set("Last-Event-ID", "1")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	reader = bufio.NewReader(res.Body)
+	if e := readEvent(); !strings.HasPrefix(e, "id: 2\n") {
+		t.Errorf("expected only the missed event, got %q", e)
+	}
+}
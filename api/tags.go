@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/docdb"
+	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
 type Tag struct {
@@ -63,6 +64,18 @@ func (tags *Tags) toDocDBTags() []*docdb.Tag {
 	return docdbTags
 }
 
+// toEC2Tags converts from api Tags to EC2 tags
+func (tags *Tags) toEC2Tags() []*ec2.Tag {
+	ec2Tags := make([]*ec2.Tag, 0, len(*tags))
+	for _, t := range *tags {
+		ec2Tags = append(ec2Tags, &ec2.Tag{
+			Key:   aws.String(t.Key),
+			Value: aws.String(t.Value),
+		})
+	}
+	return ec2Tags
+}
+
 // fromDocDBTags converts from DocDB tags to api Tags
 func fromDocDBTags(docdbTags []*docdb.Tag) Tags {
 	tags := make(Tags, 0, len(docdbTags))
@@ -0,0 +1,558 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/YaleSpinup/flywheel"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// taskStatePrefix is the store key prefix for persisted task state
+	taskStatePrefix = "task:"
+	// taskAccountPrefix is the store key prefix for the account of a task, kept after the task finishes
+	taskAccountPrefix = "taskaccount:"
+	// taskClaimPrefix is the store key prefix used by instances to claim a task before resuming it
+	taskClaimPrefix = "claim:"
+	// taskStateTTL is how long the state of an unfinished task is kept
+	taskStateTTL = 24 * time.Hour
+	// staleTaskTimeout is how long a task can go without a heartbeat before another instance resumes it
+	staleTaskTimeout = 3 * time.Minute
+	// taskHeartbeatInterval is how often the owner of a running task saves its state, independent of its messages
+	taskHeartbeatInterval = staleTaskTimeout / 3
+	// resumeInterval is how often the server looks for unfinished tasks to resume
+	resumeInterval = 1 * time.Minute
+
+	operationCreate      = "create"
+	operationModify      = "modify"
+	operationDelete      = "delete"
+	operationPower       = "power"
+	operationClone       = "clone"
+	stepWaitForAvailable = "waitForAvailable"
+	stepWaitForStopped   = "waitForStopped"
+	stepWaitForDeleted   = "waitForDeleted"
+)
+
+// taskFunc is a step of an asynchronous orchestration, run in the background and tracked in flywheel
+type taskFunc func(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error
+
+// taskState is the persisted state of an asynchronous orchestration, which allows resuming it
+// from its current step if the instance running it goes away
+type taskState struct {
+	TaskID    string
+	Operation string
+	Account   string
+	Region    string
+	Cluster   string
+	Step      string
+	// SecurityGroupID is the dedicated security group of the cluster, deleted with it
+	SecurityGroupID string `json:",omitempty"`
+	Role            string
+	InlinePolicy    string
+	PolicyArns      []string
+	RequestID       string
+	// CallbackURL is notified when the task finishes, in addition to the org webhooks
+	CallbackURL string `json:",omitempty"`
+	Owner       string
+	UpdatedAt   time.Time
+
+	// resumed is set when the task was started by another instance
+	resumed bool
+	mu      sync.Mutex
+}
+
+// claimable returns true if the task isn't owned by a running instance and can be resumed by this one
+func (t *taskState) claimable(instanceID string, now time.Time) bool {
+	if t.Owner == "" {
+		return true
+	}
+	return t.Owner != instanceID && now.Sub(t.UpdatedAt) > staleTaskTimeout
+}
+
+// saveTaskState persists the task state, which also serves as the heartbeat of the owning instance
+func (s *server) saveTaskState(ctx context.Context, state *taskState) error {
+	if s.store == nil {
+		return nil
+	}
+
+	state.mu.Lock()
+	state.UpdatedAt = time.Now().UTC()
+	j, err := json.Marshal(state)
+	state.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.store.Set(ctx, taskStatePrefix+state.TaskID, string(j), taskStateTTL)
+}
+
+// loadTaskState returns the persisted state of the task stored under the given key
+func (s *server) loadTaskState(ctx context.Context, key string) (*taskState, error) {
+	v, found, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("task state %s not found", key)
+	}
+
+	state := &taskState{}
+	if err := json.Unmarshal([]byte(v), state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// saveTaskAccount persists the account of the task, so reading the task can be limited to its account
+func (s *server) saveTaskAccount(ctx context.Context, id, account string) error {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Set(ctx, taskAccountPrefix+id, account, taskStateTTL)
+}
+
+// taskAccount returns the account of the task, or false if the task isn't known
+func (s *server) taskAccount(ctx context.Context, id string) (string, bool, error) {
+	if s.store == nil {
+		return "", false, nil
+	}
+	return s.store.Get(ctx, taskAccountPrefix+id)
+}
+
+// deleteTaskState removes the persisted state of a finished task
+func (s *server) deleteTaskState(ctx context.Context, id string) error {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Delete(ctx, taskStatePrefix+id)
+}
+
+// runTask runs the function in the background as the given flywheel task, persisting the task state so it
+// can be resumed.  the task outlives the request, but keeps its logger and request id.  it's traced in a
+// new trace, linked to the one of the originating request.
+func (o *docDBOrchestrator) runTask(ctx context.Context, task *flywheel.Task, state *taskState, f taskFunc) {
+	// a dry run only records the task it would start
+	if o.plan != nil {
+		o.plan.record("flywheel", "StartTask", &taskState{
+			Operation:       state.Operation,
+			Cluster:         state.Cluster,
+			Step:            state.Step,
+			SecurityGroupID: state.SecurityGroupID,
+		})
+		return
+	}
+
+	state.TaskID = task.ID
+	state.Role = o.sp.role
+	state.InlinePolicy = o.sp.inlinePolicy
+	state.PolicyArns = o.sp.policyArns
+	state.Owner = o.server.instanceID
+	state.Account = o.account()
+	state.Region = o.sp.region
+
+	if id := common.RequestID(ctx); id != "" {
+		state.RequestID = id
+	}
+
+	if u := callbackURLFromContext(ctx); u != "" {
+		state.CallbackURL = u
+	}
+
+	taskCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	taskCtx, span := startSpan(taskCtx, state.Operation+"."+state.Step,
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(
+			attribute.String("docdb.cluster", state.Cluster),
+			attribute.String("flywheel.task", task.ID),
+		),
+	)
+
+	msgChan, errChan, ok := o.startTask(taskCtx, cancel, task, state)
+	if !ok {
+		cancel()
+		span.End()
+		return
+	}
+
+	go func() {
+		defer cancel()
+		defer span.End()
+
+		// forward the messages of the task, dropping them once the tracker stopped at shutdown so the task
+		// doesn't block on them.  all messages are forwarded before the task completes or fails.
+		msgs := make(chan string)
+		forwarded := make(chan struct{})
+		go func() {
+			defer close(forwarded)
+			for msg := range msgs {
+				select {
+				case msgChan <- msg:
+				case <-taskCtx.Done():
+				}
+			}
+		}()
+
+		err := f(taskCtx, o, state, msgs)
+		close(msgs)
+		<-forwarded
+
+		if err != nil {
+			select {
+			case errChan <- spanError(span, err):
+			case <-taskCtx.Done():
+			}
+		}
+	}()
+}
+
+// startTask starts the flywheel task and receives messages on the channels.  in the future, this
+// functionality might be part of the flywheel library.  the task is tracked by the server, so shutdown
+// waits for it.  if the server shuts down before the task finishes, it's released to be resumed by
+// another instance when the task state is persisted, otherwise it's marked failed, and the task is
+// cancelled.  a task can't start once the server is draining, it's abandoned the same way and false is
+// returned.
+func (o *docDBOrchestrator) startTask(ctx context.Context, cancelTask context.CancelFunc, task *flywheel.Task, state *taskState) (chan<- string, chan<- error, bool) {
+	msgChan := make(chan string)
+	errChan := make(chan error)
+
+	logger := common.Logger(ctx).WithField("task", task.ID)
+	requestID := common.RequestID(ctx)
+
+	if err := o.server.saveTaskState(ctx, state); err != nil {
+		logger.Errorf("failed to save task state, task can't be resumed: %s", err)
+	}
+
+	if !state.resumed {
+		if err := o.server.saveTaskAccount(ctx, task.ID, state.Account); err != nil {
+			logger.Errorf("failed to save the account of the task, scoped tokens can't read it: %s", err)
+		}
+	}
+
+	// stream the progress of the task
+	o.server.streams.open(task.ID, state.Account)
+
+	// track the task
+	if !o.server.trackTask() {
+		logger.Warnf("server is shutting down, not starting task %s", task.ID)
+		o.server.abandonTask(common.WithLogger(context.Background(), logger), task.ID, state)
+		return nil, nil, false
+	}
+
+	go func() {
+		defer o.server.tasks.Done()
+		defer cancelTask()
+
+		taskCtx, cancel := context.WithCancel(common.WithLogger(context.Background(), logger))
+		defer cancel()
+
+		flywheelTasksRunning.Inc()
+		defer flywheelTasksRunning.Dec()
+
+		// keep the task owned while it waits between messages, stopped before the task state is released or deleted
+		stopHeartbeat := o.server.startHeartbeat(taskCtx, state, taskHeartbeatInterval)
+		defer stopHeartbeat()
+
+		if !state.resumed {
+			if err := o.server.flywheel.Start(taskCtx, task); err != nil {
+				logger.Errorf("failed to start flywheel task, won't be tracked: %s", err)
+			}
+		}
+
+		for {
+			select {
+			case msg := <-msgChan:
+				logger.Infof("task %s: %s", task.ID, msg)
+				o.server.streams.publish(task.ID, taskEventMessage, msg)
+
+				if requestID != "" {
+					msg = fmt.Sprintf("[request %s] %s", requestID, msg)
+				}
+
+				if ferr := o.server.flywheel.CheckIn(taskCtx, task.ID); ferr != nil {
+					logger.Errorf("failed to checkin task %s: %s", task.ID, ferr)
+				}
+
+				if ferr := o.server.flywheel.Log(taskCtx, task.ID, msg); ferr != nil {
+					logger.Errorf("failed to log flywheel message for %s: %s", task.ID, ferr)
+				}
+
+				if err := o.server.saveTaskState(taskCtx, state); err != nil {
+					logger.Errorf("failed to save task state for %s: %s", task.ID, err)
+				}
+			case err := <-errChan:
+				logger.Error(err)
+				o.server.streams.publish(task.ID, taskEventFailed, err.Error())
+
+				msg := err.Error()
+				if requestID != "" {
+					msg = fmt.Sprintf("[request %s] %s", requestID, msg)
+				}
+
+				if ferr := o.server.flywheel.Fail(taskCtx, task.ID, msg); ferr != nil {
+					logger.Errorf("failed to fail flywheel task %s: %s", task.ID, ferr)
+				}
+
+				stopHeartbeat()
+				o.server.finishTask(taskCtx, state, err)
+
+				return
+			case <-o.server.done():
+				stopHeartbeat()
+				o.server.abandonTask(taskCtx, task.ID, state)
+				return
+			case <-ctx.Done():
+				logger.Infof("marking task %s complete", task.ID)
+
+				if ferr := o.server.flywheel.Complete(taskCtx, task.ID); ferr != nil {
+					logger.Errorf("failed to complete flywheel task %s: %s", task.ID, ferr)
+				}
+				o.server.streams.publish(task.ID, taskEventCompleted, fmt.Sprintf("task %s completed", task.ID))
+
+				stopHeartbeat()
+				o.server.finishTask(taskCtx, state, nil)
+
+				return
+			}
+		}
+	}()
+
+	return msgChan, errChan, true
+}
+
+// startHeartbeat saves the task state at the interval, so the task isn't claimed by another instance while it's
+// running, and returns the function stopping it.  once stopped, the state isn't saved again.
+func (s *server) startHeartbeat(ctx context.Context, state *taskState, interval time.Duration) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.saveTaskState(ctx, state); err != nil {
+					common.Logger(ctx).Errorf("failed to save task state heartbeat for %s: %s", state.TaskID, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
+
+// abandonTask gives up an unfinished task when the server shuts down.  it's released to be resumed by another
+// instance when the task state is persisted, otherwise it's marked failed.
+func (s *server) abandonTask(ctx context.Context, id string, state *taskState) {
+	logger := common.Logger(ctx)
+
+	if s.store != nil && s.store.Persistent() {
+		logger.Warnf("server is shutting down, releasing unfinished task %s to be resumed", id)
+
+		state.mu.Lock()
+		state.Owner = ""
+		state.mu.Unlock()
+
+		if err := s.saveTaskState(ctx, state); err != nil {
+			logger.Errorf("failed to release task state for %s: %s", id, err)
+		}
+
+		msg := "docdb-api is shutting down, the task will be resumed by another instance"
+		if ferr := s.flywheel.Log(ctx, id, msg); ferr != nil {
+			logger.Errorf("failed to log flywheel message for %s: %s", id, ferr)
+		}
+		s.streams.publish(id, taskEventReleased, msg)
+
+		return
+	}
+
+	logger.Warnf("server is shutting down, marking unfinished task %s failed", id)
+
+	msg := "docdb-api shut down before the task finished, the operation may still be in progress in AWS"
+	if ferr := s.flywheel.Fail(ctx, id, msg); ferr != nil {
+		logger.Errorf("failed to fail flywheel task %s: %s", id, ferr)
+	}
+	s.streams.publish(id, taskEventFailed, msg)
+
+	s.finishTask(ctx, state, errors.New(msg))
+}
+
+// trackTask adds a task to the tasks shutdown waits for, or returns false if the server is draining
+func (s *server) trackTask() bool {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+
+	if s.draining {
+		return false
+	}
+
+	s.tasks.Add(1)
+	return true
+}
+
+// drainTasks refuses new tasks, so shutdown can wait for the running ones
+func (s *server) drainTasks() {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	s.draining = true
+}
+
+// finishTask deletes the persisted state of the task, releases the cluster lock it holds and notifies
+// webhooks that it completed, or failed with the error
+func (s *server) finishTask(ctx context.Context, state *taskState, taskErr error) {
+	logger := common.Logger(ctx)
+
+	if err := s.deleteTaskState(ctx, state.TaskID); err != nil {
+		logger.Errorf("failed to delete task state for %s: %s", state.TaskID, err)
+	}
+
+	if err := s.unlockCluster(ctx, state.Account, state.Region, state.Cluster, state.TaskID); err != nil {
+		logger.Errorf("failed to unlock docdb cluster %s from task %s: %s", state.Cluster, state.TaskID, err)
+	}
+
+	s.notifyTask(ctx, state, taskErr)
+}
+
+// resumeFunc returns the function that continues the operation of the task from its persisted step
+func resumeFunc(state *taskState) (taskFunc, bool) {
+	switch state.Operation {
+	case operationCreate, operationClone:
+		switch state.Step {
+		case stepWaitForAvailable:
+			return createWaitForAvailable, true
+		}
+	case operationDelete:
+		switch state.Step {
+		case stepWaitForDeleted:
+			return deleteWaitForDeleted, true
+		}
+	case operationPower:
+		switch state.Step {
+		case stepWaitForAvailable:
+			return powerWaitForAvailable, true
+		case stepWaitForStopped:
+			return powerWaitForStopped, true
+		}
+	}
+
+	return nil, false
+}
+
+// resumeTasks periodically looks for unfinished tasks that aren't owned by a running instance and resumes them
+func (s *server) resumeTasks(ctx context.Context) {
+	ticker := time.NewTicker(resumeInterval)
+	defer ticker.Stop()
+
+	for {
+		s.resumeUnfinishedTasks(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resumeUnfinishedTasks resumes all claimable tasks from the store
+func (s *server) resumeUnfinishedTasks(ctx context.Context) {
+	logger := common.Logger(ctx)
+
+	keys, err := s.store.Keys(ctx, taskStatePrefix)
+	if err != nil {
+		logger.Errorf("failed to list unfinished tasks: %s", err)
+		return
+	}
+
+	for _, key := range keys {
+		state, err := s.loadTaskState(ctx, key)
+		if err != nil {
+			logger.Errorf("failed to load task state %s: %s", key, err)
+			continue
+		}
+
+		if !state.claimable(s.instanceID, time.Now()) {
+			continue
+		}
+
+		claimed, err := s.store.SetNX(ctx, taskClaimPrefix+state.TaskID, s.instanceID, resumeInterval)
+		if err != nil {
+			logger.Errorf("failed to claim task %s: %s", state.TaskID, err)
+			continue
+		}
+
+		if !claimed {
+			logger.Debugf("task %s already claimed by another instance", state.TaskID)
+			continue
+		}
+
+		s.resumeTask(ctx, state)
+	}
+}
+
+// resumeTask continues the task from its persisted step, or marks it failed if it can't be resumed
+func (s *server) resumeTask(ctx context.Context, state *taskState) {
+	if state.RequestID != "" {
+		ctx = common.WithRequestID(ctx, state.RequestID)
+	}
+	ctx = common.WithLogger(ctx, common.Logger(ctx).WithFields(map[string]interface{}{
+		"task":    state.TaskID,
+		"account": state.Account,
+		"region":  state.Region,
+		"cluster": state.Cluster,
+	}))
+	logger := common.Logger(ctx)
+
+	fail := func(msg string) {
+		logger.Error(msg)
+
+		if err := s.flywheel.Fail(ctx, state.TaskID, msg); err != nil {
+			logger.Errorf("failed to fail flywheel task %s: %s", state.TaskID, err)
+		}
+
+		s.finishTask(ctx, state, errors.New(msg))
+	}
+
+	f, ok := resumeFunc(state)
+	if !ok {
+		fail(fmt.Sprintf("unable to resume %s of docdb cluster %s from step %q", state.Operation, state.Cluster, state.Step))
+		return
+	}
+
+	o, err := s.newDocDBOrchestrator(ctx, &sessionParams{
+		role:         state.Role,
+		region:       state.Region,
+		inlinePolicy: state.InlinePolicy,
+		policyArns:   state.PolicyArns,
+	})
+	if err != nil {
+		fail(fmt.Sprintf("unable to resume %s of docdb cluster %s, failed to create orchestrator: %s", state.Operation, state.Cluster, err))
+		return
+	}
+
+	logger.Infof("resuming %s of docdb cluster %s from step %s", state.Operation, state.Cluster, state.Step)
+
+	state.resumed = true
+	o.runTask(ctx, &flywheel.Task{ID: state.TaskID}, state, func(ctx context.Context, o *docDBOrchestrator, state *taskState, msgChan chan<- string) error {
+		msgChan <- fmt.Sprintf("resuming %s of docdb cluster %s from step %s", state.Operation, state.Cluster, state.Step)
+		return f(ctx, o, state, msgChan)
+	})
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTaskStateClaimable(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		state *taskState
+		want  bool
+	}{
+		{
+			name:  "released task",
+			state: &taskState{Owner: "", UpdatedAt: now},
+			want:  true,
+		},
+		{
+			name:  "task owned by a live instance",
+			state: &taskState{Owner: "other", UpdatedAt: now.Add(-1 * time.Minute)},
+			want:  false,
+		},
+		{
+			name:  "task owned by a dead instance",
+			state: &taskState{Owner: "other", UpdatedAt: now.Add(-1 * time.Hour)},
+			want:  true,
+		},
+		{
+			name:  "task owned by this instance",
+			state: &taskState{Owner: "me", UpdatedAt: now.Add(-1 * time.Hour)},
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.claimable("me", now); got != tt.want {
+				t.Errorf("expected claimable %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTaskStatePersistence(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore()}
+
+	state := &taskState{
+		TaskID:     "abc-123",
+		Operation:  operationCreate,
+		Account:    "123456789012",
+		Cluster:    "mydocdb",
+		Step:       stepWaitForAvailable,
+		Role:       "arn:aws:iam::123456789012:role/SpinupRole",
+		PolicyArns: []string{"arn:aws:iam::aws:policy/AmazonDocDBFullAccess"},
+		Owner:      "me",
+	}
+
+	if err := s.saveTaskState(ctx, state); err != nil {
+		t.Fatalf("unexpected error saving task state: %s", err)
+	}
+
+	if state.UpdatedAt.IsZero() {
+		t.Error("expected saving task state to set the heartbeat time")
+	}
+
+	got, err := s.loadTaskState(ctx, taskStatePrefix+"abc-123")
+	if err != nil {
+		t.Fatalf("unexpected error loading task state: %s", err)
+	}
+
+	if got.TaskID != state.TaskID || got.Cluster != state.Cluster || got.Step != state.Step || got.Role != state.Role || len(got.PolicyArns) != 1 {
+		t.Errorf("expected loaded state %+v, got %+v", state, got)
+	}
+
+	if err := s.deleteTaskState(ctx, "abc-123"); err != nil {
+		t.Fatalf("unexpected error deleting task state: %s", err)
+	}
+
+	if _, err := s.loadTaskState(ctx, taskStatePrefix+"abc-123"); err == nil {
+		t.Error("expected error loading deleted task state, got nil")
+	}
+}
+
+func TestTaskHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &server{store: newMemoryStore(), instanceID: "me"}
+
+	// a task sleeping between polls hasn't sent a message for longer than the stale timeout
+	state := &taskState{TaskID: "abc-123", Owner: "me", UpdatedAt: time.Now().Add(-2 * staleTaskTimeout)}
+	j, _ := json.Marshal(state)
+	if err := s.store.Set(ctx, taskStatePrefix+"abc-123", string(j), taskStateTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := s.startHeartbeat(ctx, state, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	got, err := s.loadTaskState(ctx, taskStatePrefix+"abc-123")
+	if err != nil {
+		t.Fatalf("unexpected error loading task state: %s", err)
+	}
+
+	if got.claimable("other", time.Now()) {
+		t.Errorf("expected a running task not to be claimable by another instance, last heartbeat %s", got.UpdatedAt)
+	}
+
+	// a stopped heartbeat doesn't save the state of a finished task again
+	if err := s.deleteTaskState(ctx, "abc-123"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if _, err := s.loadTaskState(ctx, taskStatePrefix+"abc-123"); err == nil {
+		t.Error("expected the deleted task state not to be saved again")
+	}
+}
+
+func TestResumeFunc(t *testing.T) {
+	if _, ok := resumeFunc(&taskState{Operation: operationCreate, Step: stepWaitForAvailable}); !ok {
+		t.Error("expected create task waiting for available to be resumable")
+	}
+
+	if _, ok := resumeFunc(&taskState{Operation: operationCreate, Step: "unknown"}); ok {
+		t.Error("expected create task with unknown step not to be resumable")
+	}
+
+	if _, ok := resumeFunc(&taskState{Operation: operationPower, Step: stepWaitForAvailable}); !ok {
+		t.Error("expected power task waiting for available to be resumable")
+	}
+
+	if _, ok := resumeFunc(&taskState{Operation: operationPower, Step: stepWaitForStopped}); !ok {
+		t.Error("expected power task waiting for stopped to be resumable")
+	}
+
+	if _, ok := resumeFunc(&taskState{Operation: "unknown"}); ok {
+		t.Error("expected unknown operation not to be resumable")
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	// apiTokenPrefix is the store key prefix for scoped API tokens
+	apiTokenPrefix = "apitoken:"
+	// tokenCacheTTL is how long an authenticated token header is remembered, which also bounds how long a
+	// removed token keeps working
+	tokenCacheTTL = 5 * time.Minute
+
+	// operations scoped tokens can be allowed
+	opRead   = "read"
+	opCreate = "create"
+	opModify = "modify"
+	opPower  = "power"
+	opDelete = "delete"
+)
+
+// tokenLookup returns the scoped tokens that can authenticate requests
+type tokenLookup func(ctx context.Context) ([]*common.APIToken, error)
+
+type tokenContextKey struct{}
+
+// withToken returns a copy of the context carrying the scoped token that authenticated the request
+func withToken(ctx context.Context, token *common.APIToken) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// tokenFromContext returns the scoped token that authenticated the request, or nil if the request was
+// authenticated with the pre-shared key
+func tokenFromContext(ctx context.Context) *common.APIToken {
+	if token, ok := ctx.Value(tokenContextKey{}).(*common.APIToken); ok {
+		return token
+	}
+	return nil
+}
+
+// tokenAllows returns true if the token can run the operation in the account.  an empty account is
+// allowed for routes that aren't scoped to an account.
+func tokenAllows(token *common.APIToken, account, op string) bool {
+	if account != "" && !contains(token.Accounts, account) {
+		return false
+	}
+	return contains(token.Operations, op)
+}
+
+// allowedInAccount returns true if the request can run the operation in the account, for routes that
+// aren't scoped to an account themselves.  requests authenticated with the pre-shared key are always allowed.
+func allowedInAccount(ctx context.Context, account, op string) bool {
+	token := tokenFromContext(ctx)
+	return token == nil || tokenAllows(token, account, op)
+}
+
+// tokenExpired returns true if the token has an expiry in the past
+func tokenExpired(token *common.APIToken, now time.Time) bool {
+	return token.ExpiresAt != nil && !now.Before(*token.ExpiresAt)
+}
+
+// contains returns true if the list has the value or the wildcard "*"
+func contains(list []string, v string) bool {
+	for _, l := range list {
+		if l == v || l == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokens returns the scoped tokens from the configuration and the store
+func (s *server) apiTokens(ctx context.Context) ([]*common.APIToken, error) {
+	tokens := []*common.APIToken{}
+	for i := range s.tokens {
+		tokens = append(tokens, &s.tokens[i])
+	}
+
+	if s.store == nil {
+		return tokens, nil
+	}
+
+	keys, err := s.store.Keys(ctx, apiTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		v, found, err := s.store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			continue
+		}
+
+		token := &common.APIToken{}
+		if err := json.Unmarshal([]byte(v), token); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal api token %s", key)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// authorized only lets requests authenticated with a scoped token through if the token allows the
+// operation in the account of the route
+func authorized(op string, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromContext(r.Context())
+		if token == nil {
+			h(w, r)
+			return
+		}
+
+		account := mux.Vars(r)["account"]
+		if !tokenAllows(token, account, op) {
+			msg := fmt.Sprintf("token %s is not allowed to %s in account %s", token.Name, op, account)
+			if account == "" {
+				msg = fmt.Sprintf("token %s is not allowed to %s", token.Name, op)
+			}
+
+			common.Logger(r.Context()).Warn(msg)
+			handleError(w, apierror.New(apierror.ErrForbidden, msg, nil))
+			return
+		}
+
+		h(w, r)
+	})
+}
+
+// taskScoped only lets requests for flywheel tasks authenticated with a scoped token through if every task in
+// the task query parameters belongs to an account the token can read.  other tasks return not found, like
+// tasks that don't exist.
+func (s *server) taskScoped(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromContext(r.Context())
+		if token == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		for _, id := range r.URL.Query()["task"] {
+			account, found, err := s.taskAccount(r.Context(), id)
+			if err != nil {
+				handleError(w, errors.Wrapf(err, "failed to get the account of task %s", id))
+				return
+			}
+
+			if !found || !tokenAllows(token, account, opRead) {
+				common.Logger(r.Context()).Warnf("token %s is not allowed to read task %s", token.Name, id)
+				handleError(w, apierror.New(apierror.ErrNotFound, fmt.Sprintf("task %s not found", id), nil))
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	}
+}
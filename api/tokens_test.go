@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/gorilla/mux"
+)
+
+func TestTokenAllows(t *testing.T) {
+	token := &common.APIToken{
+		Name:       "ops",
+		Accounts:   []string{"123456789012"},
+		Operations: []string{opRead, opPower},
+	}
+
+	tests := []struct {
+		account string
+		op      string
+		allowed bool
+	}{
+		{account: "123456789012", op: opRead, allowed: true},
+		{account: "123456789012", op: opPower, allowed: true},
+		{account: "123456789012", op: opDelete, allowed: false},
+		{account: "000000000000", op: opRead, allowed: false},
+		{account: "", op: opRead, allowed: true},
+	}
+
+	for _, tt := range tests {
+		if allowed := tokenAllows(token, tt.account, tt.op); allowed != tt.allowed {
+			t.Errorf("expected %s in account %q allowed to be %t, got %t", tt.op, tt.account, tt.allowed, allowed)
+		}
+	}
+
+	admin := &common.APIToken{Name: "admin", Accounts: []string{"*"}, Operations: []string{"*"}}
+	if !tokenAllows(admin, "000000000000", opDelete) {
+		t.Error("expected wildcard token to allow delete in any account")
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+
+	if tokenExpired(&common.APIToken{}, now) {
+		t.Error("expected token without expiry not to be expired")
+	}
+
+	if !tokenExpired(&common.APIToken{ExpiresAt: &past}, now) {
+		t.Error("expected token with past expiry to be expired")
+	}
+
+	if tokenExpired(&common.APIToken{ExpiresAt: &future}, now) {
+		t.Error("expected token with future expiry not to be expired")
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	s := &server{
+		tokens: []common.APIToken{{Name: "config", Token: "configtoken"}},
+		store:  newMemoryStore(),
+	}
+
+	if err := s.store.Set(context.TODO(), apiTokenPrefix+"stored", `{"Name":"stored","Token":"storedtoken","Accounts":["*"],"Operations":["read"]}`, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := s.apiTokens(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	names := map[string]bool{}
+	for _, token := range tokens {
+		names[token.Name] = true
+	}
+
+	if len(tokens) != 2 || !names["config"] || !names["stored"] {
+		t.Errorf("expected config and stored tokens, got %+v", tokens)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	router := mux.NewRouter()
+	router.Handle("/v1/docdb/{account}/{name}", authorized(opDelete, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).Methods(http.MethodDelete)
+
+	tests := []struct {
+		token  *common.APIToken
+		url    string
+		status int
+	}{
+		{token: nil, url: "/v1/docdb/123456789012/mydocdb", status: http.StatusNoContent},
+		{token: &common.APIToken{Name: "admin", Accounts: []string{"123456789012"}, Operations: []string{opDelete}}, url: "/v1/docdb/123456789012/mydocdb", status: http.StatusNoContent},
+		{token: &common.APIToken{Name: "admin", Accounts: []string{"123456789012"}, Operations: []string{opDelete}}, url: "/v1/docdb/000000000000/mydocdb", status: http.StatusForbidden},
+		{token: &common.APIToken{Name: "reader", Accounts: []string{"*"}, Operations: []string{opRead}}, url: "/v1/docdb/123456789012/mydocdb", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodDelete, tt.url, nil)
+		if tt.token != nil {
+			req = req.WithContext(withToken(req.Context(), tt.token))
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%+v %s: expected status %d, got %d", tt.token, tt.url, tt.status, rr.Code)
+		}
+	}
+}
+
+func TestTaskScoped(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore()}
+	for id, account := range map[string]string{"task-1": "123456789012", "task-2": "000000000000"} {
+		if err := s.saveTaskAccount(ctx, id, account); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := s.taskScoped(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	reader := &common.APIToken{Name: "reader", Accounts: []string{"123456789012"}, Operations: []string{opRead}}
+
+	tests := []struct {
+		token  *common.APIToken
+		query  string
+		status int
+	}{
+		{token: nil, query: "task=task-2", status: http.StatusOK},
+		{token: reader, query: "task=task-1", status: http.StatusOK},
+		{token: reader, query: "task=task-2", status: http.StatusNotFound},
+		{token: reader, query: "task=task-1&task=task-2", status: http.StatusNotFound},
+		{token: reader, query: "task=unknown", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/docdb/flywheel?"+tt.query, nil)
+		if tt.token != nil {
+			req = req.WithContext(withToken(req.Context(), tt.token))
+		}
+
+		rr := httptest.NewRecorder()
+		h(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%+v %s: expected status %d, got %d", tt.token, tt.query, tt.status, rr.Code)
+		}
+	}
+}
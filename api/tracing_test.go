@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetupTracing(t *testing.T) {
+	for _, exporter := range []string{"", "stdout", "otlp"} {
+		shutdown, err := setupTracing(context.Background(), common.Tracing{Exporter: exporter}, "0.0.0")
+		if err != nil {
+			t.Errorf("unexpected error setting up %q exporter: %s", exporter, err)
+			continue
+		}
+
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("unexpected error shutting down %q exporter: %s", exporter, err)
+		}
+	}
+
+	if _, err := setupTracing(context.Background(), common.Tracing{Exporter: "carrierpigeon"}, "0.0.0"); err == nil {
+		t.Error("expected error for unknown exporter, got nil")
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer tp.Shutdown(context.Background())
+
+	h := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := common.Logger(r.Context()).Data["trace_id"]; !ok {
+			t.Error("expected trace_id field in request logger")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/v1/docdb/123/foo", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+
+	if got := spans[0].SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("expected span to continue trace %s, got %s", traceID, got)
+	}
+
+	if got := spans[0].Status.Code.String(); got != "Error" {
+		t.Errorf("expected span status Error for a 500 response, got %s", got)
+	}
+}
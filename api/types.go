@@ -1,6 +1,9 @@
 package api
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/aws/aws-sdk-go/service/docdb"
 )
 
@@ -16,6 +19,8 @@ type DocDBCreateRequest struct {
 	SubnetIds             []string
 	Tags                  Tags
 	VpcSecurityGroupIds   []*string
+	// Access creates a dedicated security group for the cluster allowing the given sources
+	Access *DocDBAccess `json:",omitempty"`
 }
 
 // DocDBModifyRequest is data used to modify a documentDB
@@ -29,6 +34,19 @@ type DocDBModifyRequest struct {
 	VpcSecurityGroupIds    []*string
 }
 
+// DocDBCloneRequest is data used to clone a documentDB into a new cluster
+type DocDBCloneRequest struct {
+	DBClusterIdentifier *string
+	// RestoreType is pointInTime (default) for a copy-on-write clone sharing the storage of the source, or
+	// snapshot to restore the latest snapshot of the source
+	RestoreType *string
+	// RestoreToTime is the point in time of a pointInTime clone, defaults to the latest restorable time
+	RestoreToTime *time.Time
+	// DBInstanceClass and InstanceCount default to the instances of the source
+	DBInstanceClass *string
+	InstanceCount   *int
+}
+
 // DocDBResponse is the output from documentDB operations
 type DocDBResponse struct {
 	// https://docs.aws.amazon.com/sdk-for-go/api/service/docdb/#DBCluster
@@ -38,6 +56,98 @@ type DocDBResponse struct {
 	Tags      Tags                `json:",omitempty"`
 }
 
+// DocDBCreateEstimateRequest is a create request to estimate the monthly cost of
+type DocDBCreateEstimateRequest struct {
+	DocDBCreateRequest
+	// StorageGB is the expected storage of the cluster, defaults to 10
+	StorageGB *float64
+}
+
+// DocDBModifyEstimateRequest is a modify request of an existing cluster to estimate the monthly cost of
+type DocDBModifyEstimateRequest struct {
+	DocDBModifyRequest
+	// StorageGB is the expected storage of the cluster, defaults to 10
+	StorageGB *float64
+}
+
+// DocDBEstimate is the estimated monthly cost of a documentDB cluster
+type DocDBEstimate struct {
+	Currency              string
+	Region                string
+	DBInstanceClass       string
+	InstanceCount         int
+	BackupRetentionPeriod int64
+	StorageGB             float64
+	InstanceCost          float64
+	StorageCost           float64
+	BackupCost            float64
+	Total                 float64
+}
+
+// DocDBEstimateResponse is the estimated monthly cost of a create or modify request
+type DocDBEstimateResponse struct {
+	Estimate *DocDBEstimate
+	// Current is the estimate of the existing cluster, for modify requests
+	Current *DocDBEstimate `json:",omitempty"`
+}
+
 type docDBInstanceStateChangeRequest struct {
 	State string `json:"state"`
+	// KeepStopped flags a stopped cluster to be stopped again if AWS starts it automatically
+	KeepStopped *bool `json:"keepStopped,omitempty"`
+}
+
+// DocDBPowerScheduleRequest is data used to create a power schedule for a documentDB
+type DocDBPowerScheduleRequest struct {
+	// start or stop
+	State string
+	// standard cron expression, optionally prefixed with CRON_TZ=<timezone>
+	Schedule string
+}
+
+// DocDBPowerResponse is the power state of a documentDB cluster and its instances
+type DocDBPowerResponse struct {
+	Status      string
+	Instances   map[string]string
+	KeepStopped bool
+}
+
+// DocDBAccess are the sources allowed to connect to a documentDB cluster through its dedicated security group
+type DocDBAccess struct {
+	Cidrs            []string
+	SecurityGroupIds []string
+}
+
+// DocDBAccessResponse is the dedicated security group of a documentDB cluster and the sources it allows
+type DocDBAccessResponse struct {
+	SecurityGroupId  string
+	Cidrs            []string
+	SecurityGroupIds []string
+}
+
+// DocDBPlannedCall is an AWS call that a dry run would make, with secrets redacted from its input
+type DocDBPlannedCall struct {
+	Service   string
+	Operation string
+	Input     json.RawMessage `json:",omitempty"`
+}
+
+// DocDBDryRunResponse is the response of a dry run, listing the AWS calls the request would make
+type DocDBDryRunResponse struct {
+	DryRun bool
+	Calls  []*DocDBPlannedCall
+}
+
+// ErrorResponse is the JSON body returned when a request fails
+type ErrorResponse struct {
+	// Code is the apierror code, ie. NotFound
+	Code string `json:"code"`
+	// Status is the HTTP status code of the response
+	Status int `json:"status"`
+	// Message is a human readable description of the error
+	Message string `json:"message"`
+	// AWSCode is the underlying AWS error code, if any
+	AWSCode string `json:"awsCode,omitempty"`
+	// RequestID identifies the request in the API logs
+	RequestID string `json:"requestId"`
 }
@@ -0,0 +1,414 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+)
+
+const (
+	// callbackHeader is the request header with a URL notified when the task started by the request finishes
+	callbackHeader = "X-Callback-Url"
+	// signatureHeader carries the HMAC-SHA256 signature of a notification payload
+	signatureHeader = "X-Signature"
+	// webhookDeliveryPrefix is the store key prefix for the delivery log of a task's notifications
+	webhookDeliveryPrefix = "webhook:"
+	// webhookTimeout bounds a single delivery attempt
+	webhookTimeout = 10 * time.Second
+	// defaultWebhookRetries is how many times a failed delivery is retried when not configured
+	defaultWebhookRetries = 3
+
+	notificationCompleted = "completed"
+	notificationFailed    = "failed"
+)
+
+// webhookBackoff is the wait before the first retry of a failed delivery, doubled for every retry
+var webhookBackoff = 2 * time.Second
+
+// taskNotification is the payload sent to webhooks when a task finishes
+type taskNotification struct {
+	TaskID    string    `json:"taskId"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Operation string    `json:"operation"`
+	Account   string    `json:"account"`
+	Region    string    `json:"region"`
+	Cluster   string    `json:"cluster"`
+	RequestID string    `json:"requestId,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// webhookDelivery is an attempt to deliver a notification, kept in the delivery log of the task
+type webhookDelivery struct {
+	URL     string    `json:"url"`
+	Event   string    `json:"event"`
+	Attempt int       `json:"attempt"`
+	Status  int       `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// webhookDeliveryLog is the delivery log of a task's notifications, with the account of the task
+type webhookDeliveryLog struct {
+	Account    string
+	Deliveries []*webhookDelivery
+}
+
+// notifier delivers signed task notifications to webhooks
+type notifier struct {
+	urls          []string
+	secret        []byte
+	retries       int
+	client        *http.Client
+	callbackHosts []string
+	// callbackClient delivers to request callbacks, which aren't redirected and, without allowed callback
+	// hosts, only connect to public addresses
+	callbackClient *http.Client
+	lookupIP       func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// newNotifier returns a notifier for the org webhooks and request callbacks
+func newNotifier(config common.Webhooks) (*notifier, error) {
+	for _, u := range config.URLs {
+		if err := validateWebhookURL(u); err != nil {
+			return nil, err
+		}
+	}
+
+	retries := config.Retries
+	if retries <= 0 {
+		retries = defaultWebhookRetries
+	}
+
+	callbackHosts := []string{}
+	for _, h := range config.CallbackHosts {
+		callbackHosts = append(callbackHosts, strings.ToLower(h))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(callbackHosts) == 0 {
+		// checked when connecting, so a callback host can't resolve to a public address when the request
+		// is validated and to a private one when the notification is delivered
+		dialer := &net.Dialer{Timeout: webhookTimeout, Control: publicAddressControl}
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = nil
+	}
+
+	return &notifier{
+		urls:          config.URLs,
+		secret:        []byte(config.Secret),
+		retries:       retries,
+		client:        &http.Client{Timeout: webhookTimeout},
+		callbackHosts: callbackHosts,
+		callbackClient: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		lookupIP: net.DefaultResolver.LookupIPAddr,
+	}, nil
+}
+
+// validateWebhookURL returns an error if the webhook isn't an absolute http or https URL
+func validateWebhookURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid webhook url %q, must be an absolute http or https url", u)
+	}
+	return nil
+}
+
+// validateCallbackURL returns an error if the request callback isn't a webhook URL with an allowed host or,
+// without allowed callback hosts, a host resolving to public addresses only
+func (n *notifier) validateCallbackURL(ctx context.Context, u string) error {
+	if err := validateWebhookURL(u); err != nil {
+		return err
+	}
+
+	parsed, _ := url.Parse(u)
+	host := strings.ToLower(parsed.Hostname())
+
+	if len(n.callbackHosts) > 0 {
+		for _, h := range n.callbackHosts {
+			if h == host {
+				return nil
+			}
+		}
+		return fmt.Errorf("callback url host %q is not allowed", host)
+	}
+
+	addrs := []net.IPAddr{}
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	} else {
+		resolved, err := n.lookupIP(ctx, host)
+		if err != nil {
+			return fmt.Errorf("unable to resolve callback url host %q: %s", host, err)
+		}
+		addrs = resolved
+	}
+
+	for _, a := range addrs {
+		if !publicAddress(a.IP) {
+			return fmt.Errorf("callback url host %q must resolve to a public address", host)
+		}
+	}
+
+	return nil
+}
+
+// publicAddress returns true if the ip is a public unicast address, not a loopback, link-local (like the
+// instance metadata service) or private one
+func publicAddress(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// publicAddressControl is a dialer control refusing to connect to addresses that aren't public
+func publicAddressControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+
+	return nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the payload, or an empty string without a secret
+func (n *notifier) sign(payload []byte) string {
+	if len(n.secret) == 0 {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts the notification to the URL with the client, retrying with a backoff until it's accepted or
+// the retries are exhausted.  it returns every attempt.
+func (n *notifier) deliver(ctx context.Context, client *http.Client, u string, notification *taskNotification) []*webhookDelivery {
+	attempts := []*webhookDelivery{}
+
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return append(attempts, &webhookDelivery{URL: u, Event: notification.Status, Attempt: 1, Error: err.Error(), Time: time.Now().UTC()})
+	}
+	signature := n.sign(payload)
+
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= n.retries+1; attempt++ {
+		delivery := &webhookDelivery{URL: u, Event: notification.Status, Attempt: attempt, Time: time.Now().UTC()}
+		attempts = append(attempts, delivery)
+
+		retry, err := n.post(ctx, client, u, payload, signature, delivery)
+		if err == nil {
+			return attempts
+		}
+		delivery.Error = err.Error()
+
+		if !retry || attempt > n.retries {
+			return attempts
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempts
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	return attempts
+}
+
+// post sends the payload once, recording the response status in the delivery.  it reports whether a failure
+// can be retried, client errors other than 429 are not.
+func (n *notifier) post(ctx context.Context, client *http.Client, u string, payload []byte, signature string, delivery *webhookDelivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set(signatureHeader, signature)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	delivery.Status = res.StatusCode
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return false, nil
+	}
+
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status %d from webhook", res.StatusCode)
+}
+
+type callbackContextKey struct{}
+
+// withCallbackURL returns a copy of the context carrying the callback URL of the request
+func withCallbackURL(ctx context.Context, u string) context.Context {
+	return context.WithValue(ctx, callbackContextKey{}, u)
+}
+
+// callbackURLFromContext returns the callback URL carried by the context, if any
+func callbackURLFromContext(ctx context.Context) string {
+	if u, ok := ctx.Value(callbackContextKey{}).(string); ok {
+		return u
+	}
+	return ""
+}
+
+// callbackMiddleware validates the callback URL of the request and adds it to the request context, so it's
+// notified when a task started by the request finishes
+func (s *server) callbackMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := r.Header.Get(callbackHeader)
+		if u == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if err := s.notifier.validateCallbackURL(r.Context(), u); err != nil {
+			handleError(w, apierror.New(apierror.ErrBadRequest, err.Error(), err))
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(withCallbackURL(r.Context(), u)))
+	})
+}
+
+// notifyTask notifies the org webhooks and the callback URL of the request that started the task that it
+// completed, or failed with the error.  notifications are delivered in the background, and waited for when
+// shutting down.
+func (s *server) notifyTask(ctx context.Context, state *taskState, taskErr error) {
+	if s.notifier == nil {
+		return
+	}
+
+	type webhook struct {
+		url    string
+		client *http.Client
+	}
+
+	webhooks := []webhook{}
+	for _, u := range s.notifier.urls {
+		webhooks = append(webhooks, webhook{u, s.notifier.client})
+	}
+
+	if state.CallbackURL != "" {
+		webhooks = append(webhooks, webhook{state.CallbackURL, s.notifier.callbackClient})
+	}
+
+	if len(webhooks) == 0 {
+		return
+	}
+
+	notification := &taskNotification{
+		TaskID:    state.TaskID,
+		Status:    notificationCompleted,
+		Operation: state.Operation,
+		Account:   state.Account,
+		Region:    state.Region,
+		Cluster:   state.Cluster,
+		RequestID: state.RequestID,
+		Time:      time.Now().UTC(),
+	}
+
+	if taskErr != nil {
+		notification.Status = notificationFailed
+		notification.Error = taskErr.Error()
+	}
+
+	logger := common.Logger(ctx)
+	deliver := func() {
+		ctx := common.WithLogger(context.Background(), logger)
+
+		for _, w := range webhooks {
+			attempts := s.notifier.deliver(ctx, w.client, w.url, notification)
+			if last := attempts[len(attempts)-1]; last.Error != "" {
+				logger.Errorf("failed to deliver %s notification of task %s to webhook %s after %d attempts: %s", notification.Status, state.TaskID, w.url, len(attempts), last.Error)
+			}
+
+			if err := s.logWebhookDeliveries(ctx, state.Account, state.TaskID, attempts); err != nil {
+				logger.Errorf("failed to save webhook delivery log of task %s: %s", state.TaskID, err)
+			}
+		}
+	}
+
+	// tasks finishing while the server is draining deliver before they're done, so shutdown still waits for them
+	if !s.trackTask() {
+		deliver()
+		return
+	}
+
+	go func() {
+		defer s.tasks.Done()
+		deliver()
+	}()
+}
+
+// logWebhookDeliveries appends the delivery attempts to the delivery log of the task in the account
+func (s *server) logWebhookDeliveries(ctx context.Context, account, taskID string, attempts []*webhookDelivery) error {
+	if s.store == nil {
+		return nil
+	}
+
+	deliveries, err := s.webhookDeliveries(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	deliveries.Account = account
+	deliveries.Deliveries = append(deliveries.Deliveries, attempts...)
+
+	j, err := json.Marshal(deliveries)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Set(ctx, webhookDeliveryPrefix+taskID, string(j), taskStateTTL)
+}
+
+// webhookDeliveries returns the delivery log of the task's notifications
+func (s *server) webhookDeliveries(ctx context.Context, taskID string) (*webhookDeliveryLog, error) {
+	deliveries := &webhookDeliveryLog{Deliveries: []*webhookDelivery{}}
+	if s.store == nil {
+		return deliveries, nil
+	}
+
+	v, found, err := s.store.Get(ctx, webhookDeliveryPrefix+taskID)
+	if err != nil || !found {
+		return deliveries, err
+	}
+
+	if err := json.Unmarshal([]byte(v), deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/pkg/errors"
+)
+
+// webhookStandIn is a local webhook that answers with the given statuses in order, then 204
+type webhookStandIn struct {
+	mu       sync.Mutex
+	statuses []int
+	received []taskNotification
+	sigs     []string
+}
+
+func (ws *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	b, _ := io.ReadAll(r.Body)
+	n := taskNotification{}
+	json.Unmarshal(b, &n)
+	ws.received = append(ws.received, n)
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(b)
+	if r.Header.Get(signatureHeader) == "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		ws.sigs = append(ws.sigs, "valid")
+	} else {
+		ws.sigs = append(ws.sigs, r.Header.Get(signatureHeader))
+	}
+
+	status := http.StatusNoContent
+	if len(ws.statuses) > 0 {
+		status, ws.statuses = ws.statuses[0], ws.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestNewNotifier(t *testing.T) {
+	n, err := newNotifier(common.Webhooks{URLs: []string{"https://hooks.example.edu/docdb"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if n.retries != defaultWebhookRetries {
+		t.Errorf("expected %d retries by default, got %d", defaultWebhookRetries, n.retries)
+	}
+
+	for _, u := range []string{"hooks.example.edu/docdb", "ftp://hooks.example.edu", "https://"} {
+		if _, err := newNotifier(common.Webhooks{URLs: []string{u}}); err == nil {
+			t.Errorf("expected error for webhook url %q, got nil", u)
+		}
+	}
+}
+
+func TestNotifierDeliver(t *testing.T) {
+	defer func(b time.Duration) { webhookBackoff = b }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	standIn := &webhookStandIn{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+
+	n, _ := newNotifier(common.Webhooks{Secret: "s3cret", Retries: 2})
+	attempts := n.deliver(context.TODO(), n.client, ts.URL, &taskNotification{TaskID: "task-1", Status: notificationCompleted})
+
+	if len(attempts) != 3 || attempts[0].Status != http.StatusInternalServerError || attempts[2].Status != http.StatusNoContent || attempts[2].Error != "" {
+		t.Fatalf("expected 2 failed attempts and a delivery, got %+v", attempts)
+	}
+
+	for _, sig := range standIn.sigs {
+		if sig != "valid" {
+			t.Errorf("expected a valid signature, got %q", sig)
+		}
+	}
+
+	// retries are exhausted
+	standIn.statuses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	if attempts := n.deliver(context.TODO(), n.client, ts.URL, &taskNotification{}); len(attempts) != 3 || attempts[2].Error == "" {
+		t.Errorf("expected 3 failed attempts, got %+v", attempts)
+	}
+
+	// client errors aren't retried
+	standIn.statuses = []int{http.StatusBadRequest}
+	if attempts := n.deliver(context.TODO(), n.client, ts.URL, &taskNotification{}); len(attempts) != 1 || attempts[0].Status != http.StatusBadRequest {
+		t.Errorf("expected 1 failed attempt, got %+v", attempts)
+	}
+
+	// callbacks don't connect to the local webhook
+	received := len(standIn.received)
+	if attempts := n.deliver(context.TODO(), n.callbackClient, ts.URL, &taskNotification{}); attempts[len(attempts)-1].Error == "" || len(standIn.received) != received {
+		t.Errorf("expected the callback to the local webhook to be refused, got %+v", attempts)
+	}
+}
+
+func TestNotifyTask(t *testing.T) {
+	orgHook := &webhookStandIn{}
+	org := httptest.NewServer(orgHook)
+	defer org.Close()
+
+	callbackHook := &webhookStandIn{}
+	callback := httptest.NewServer(callbackHook)
+	defer callback.Close()
+
+	n, _ := newNotifier(common.Webhooks{URLs: []string{org.URL}, Secret: "s3cret", CallbackHosts: []string{"127.0.0.1"}})
+	s := &server{store: newMemoryStore(), notifier: n}
+
+	ctx := context.Background()
+	state := &taskState{TaskID: "task-1", Operation: operationCreate, Account: "123456789012", Cluster: "mydocdb", CallbackURL: callback.URL}
+	s.notifyTask(ctx, state, errors.New("boom"))
+
+	var deliveries []*webhookDelivery
+	for i := 0; i < 100; i++ {
+		if log, _ := s.webhookDeliveries(ctx, "task-1"); log != nil && log.Account == "123456789012" {
+			deliveries = log.Deliveries
+		}
+		if len(deliveries) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(deliveries) != 2 || deliveries[0].URL != org.URL || deliveries[1].URL != callback.URL || deliveries[1].Event != notificationFailed {
+		t.Fatalf("expected deliveries to the org webhook and the callback, got %+v", deliveries)
+	}
+
+	for _, hook := range []*webhookStandIn{orgHook, callbackHook} {
+		hook.mu.Lock()
+		if len(hook.received) != 1 || hook.received[0].Status != notificationFailed || hook.received[0].Error != "boom" || hook.received[0].Cluster != "mydocdb" {
+			t.Errorf("unexpected notifications %+v", hook.received)
+		}
+		hook.mu.Unlock()
+	}
+}
+
+func TestCallbackMiddleware(t *testing.T) {
+	n, _ := newNotifier(common.Webhooks{})
+	n.lookupIP = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "portal.example.edu":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		case "internal.example.edu":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.1.2.3")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	s := &server{notifier: n}
+
+	var got string
+	h := s.callbackMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = callbackURLFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/docdb/123456789012/mydocdb", nil)
+	req.Header.Set(callbackHeader, "https://portal.example.edu/hooks/docdb")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "https://portal.example.edu/hooks/docdb" {
+		t.Errorf("expected callback url in the context, got %q", got)
+	}
+
+	for _, u := range []string{
+		"not a url",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://localhost:8080/v1/docdb/ping",
+		"http://127.0.0.1/",
+		"http://[::1]/",
+		"https://10.0.0.1/hooks",
+		"https://internal.example.edu/hooks",
+		"https://missing.example.edu/hooks",
+	} {
+		req.Header.Set(callbackHeader, u)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for callback url %q, got %d", http.StatusBadRequest, u, rr.Code)
+		}
+	}
+
+	// only the allowed hosts, wherever they resolve
+	s.notifier, _ = newNotifier(common.Webhooks{CallbackHosts: []string{"Hooks.Internal.Example.edu"}})
+
+	req.Header.Set(callbackHeader, "https://hooks.internal.example.edu/docdb")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || got != "https://hooks.internal.example.edu/docdb" {
+		t.Errorf("expected an allowed callback host, got %d and %q", rr.Code, got)
+	}
+
+	req.Header.Set(callbackHeader, "https://portal.example.edu/hooks/docdb")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a callback host that isn't allowed, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestWebhookDeliveriesHandler(t *testing.T) {
+	ctx := context.Background()
+	s := &server{store: newMemoryStore()}
+	if err := s.logWebhookDeliveries(ctx, "123456789012", "task-1", []*webhookDelivery{{URL: "https://portal.example.edu/hooks/docdb", Attempt: 1, Status: 204}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token      *common.APIToken
+		status     int
+		deliveries int
+	}{
+		{token: nil, status: http.StatusOK, deliveries: 1},
+		{token: &common.APIToken{Name: "reader", Accounts: []string{"123456789012"}, Operations: []string{opRead}}, status: http.StatusOK, deliveries: 1},
+		{token: &common.APIToken{Name: "other", Accounts: []string{"000000000000"}, Operations: []string{opRead}}, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/docdb/flywheel/deliveries?task=task-1", nil)
+		if tt.token != nil {
+			req = req.WithContext(withToken(req.Context(), tt.token))
+		}
+
+		rr := httptest.NewRecorder()
+		s.WebhookDeliveriesHandler(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%+v: expected status %d, got %d", tt.token, tt.status, rr.Code)
+			continue
+		}
+
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		deliveries := []*webhookDelivery{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &deliveries); err != nil || len(deliveries) != tt.deliveries {
+			t.Errorf("%+v: expected %d deliveries, got %s", tt.token, tt.deliveries, rr.Body.String())
+		}
+	}
+}
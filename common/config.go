@@ -19,6 +19,7 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 import (
 	"encoding/json"
 	"io"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -26,13 +27,22 @@ import (
 
 // Config is representation of the configuration data
 type Config struct {
-	ListenAddress string
-	Account       Account
-	Flywheel      Flywheel
-	Token         string
-	LogLevel      string
-	Version       Version
-	Org           string
+	ListenAddress   string
+	ShutdownTimeout string
+	Account         Account
+	Accounts        map[string]AccountOverride
+	Flywheel        Flywheel
+	Tracing         Tracing
+	Token           string
+	Tokens          []APIToken
+	OIDC            OIDC
+	Audit           Audit
+	Webhooks        Webhooks
+	PricingFile     string
+	Quotas          Quotas
+	LogLevel        string
+	Version         Version
+	Org             string
 }
 
 // Account is the configuration for an individual account
@@ -42,7 +52,95 @@ type Account struct {
 	Akid       string
 	Secret     string
 	Region     string
-	Role       string
+	// Regions are the regions requests can select, defaults to Region
+	Regions []string
+	Role    string
+	// DefaultSubnets and DefaultSecurityGroups are used by create requests that don't specify them
+	DefaultSubnets        []string
+	DefaultSecurityGroups []string
+	// SubnetTags and SecurityGroupTags discover the networking of create requests without defaults
+	SubnetTags        map[string]string
+	SecurityGroupTags map[string]string
+}
+
+// AccountOverride overrides the account configuration for a single managed account, empty fields use
+// the account configuration.  when overrides are configured, only the listed accounts are managed.
+type AccountOverride struct {
+	Role                   string
+	ExternalID             string
+	Region                 string
+	DefaultKMSKeyId        string
+	DefaultSubnets         []string
+	DefaultSecurityGroups  []string
+	SubnetTags             map[string]string
+	SecurityGroupTags      map[string]string
+	AllowedInstanceClasses []string
+	MaxClusters            int
+	MaxInstances           int
+	MaxVCPU                int
+}
+
+// Quotas limit the clusters of the org in every account, zero values are unlimited.  accounts can override
+// them.
+type Quotas struct {
+	// MaxClusters is the maximum number of clusters of the org in an account, over all its regions
+	MaxClusters int
+	// MaxInstances is the maximum number of instances in a cluster
+	MaxInstances int
+	// MaxVCPU is the maximum total vCPU of the instances of the org in an account, over all its regions
+	MaxVCPU int
+	// AllowedInstanceClasses are the instance classes allowed by create and modify requests, empty allows all
+	AllowedInstanceClasses []string
+}
+
+// APIToken is a named pre-shared key, limited to some accounts and operations.  "*" allows all accounts
+// or operations.
+type APIToken struct {
+	Name       string
+	Token      string
+	Accounts   []string
+	Operations []string
+	ExpiresAt  *time.Time `json:",omitempty"`
+}
+
+// OIDC is the configuration for authenticating bearer JWTs issued by an OIDC provider
+type OIDC struct {
+	// JWKS is the URL or file path of the JSON web key set used to verify tokens, empty disables JWTs
+	JWKS string
+	// Issuer and Audience are checked against the iss and aud claims if set
+	Issuer   string
+	Audience string
+	// NameClaim identifies the caller in the logs, defaults to sub
+	NameClaim string
+	// AccountsClaim and OperationsClaim list the allowed accounts and operations, default to
+	// accounts and operations
+	AccountsClaim   string
+	OperationsClaim string
+}
+
+// Audit is the configuration for the audit log of mutating requests
+type Audit struct {
+	// Sink is where audit events are written: "file", "stdout", "webhook" or empty to disable auditing
+	Sink string
+	// Path is the JSON lines file of the file sink
+	Path string
+	// URL is where the webhook sink posts events
+	URL string
+	// Headers are added to the requests of the webhook sink, ie. for authentication
+	Headers map[string]string
+}
+
+// Webhooks is the configuration for notifying when tasks complete or fail
+type Webhooks struct {
+	// URLs are notified of every task of the org, in addition to the callback URL of the request
+	URLs []string
+	// Secret signs the notification payloads with HMAC-SHA256, empty sends them unsigned
+	Secret string
+	// Retries is how many times a failed delivery is retried, defaults to 3
+	Retries int
+	// CallbackHosts are the only hosts allowed in request callback URLs, empty allows any host resolving
+	// to public addresses
+	CallbackHosts []string
 }
 
 // Flywheel is the configuration for task tracking in flywheel
@@ -55,6 +153,18 @@ type Flywheel struct {
 	TTL           string
 }
 
+// Tracing is the configuration for OpenTelemetry tracing
+type Tracing struct {
+	// Exporter selects where spans are sent: "otlp", "stdout" or empty to disable tracing
+	Exporter string
+	// Endpoint is the host:port of the OTLP HTTP collector
+	Endpoint string
+	// Insecure disables TLS when connecting to the OTLP collector
+	Insecure bool
+	// SampleRatio is the fraction of new traces to sample, defaults to sampling everything
+	SampleRatio float64
+}
+
 // Version carries around the API version information
 type Version struct {
 	Version    string
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// WithLogger returns a copy of the context carrying the given log entry
+func WithLogger(ctx context.Context, logger *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Logger returns the log entry carried by the context, or a plain entry from the standard logger
+func Logger(ctx context.Context) *log.Entry {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey).(*log.Entry); ok {
+			return logger
+		}
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// WithRequestID returns a copy of the context carrying the request id and a logger with the request_id field set
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	return WithLogger(ctx, Logger(ctx).WithField("request_id", id))
+}
+
+// RequestID returns the request id carried by the context, if any
+func RequestID(ctx context.Context) string {
+	if ctx != nil {
+		if id, ok := ctx.Value(requestIDKey).(string); ok {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestLogger(t *testing.T) {
+	// a context without a logger returns a usable entry
+	if l := Logger(context.Background()); l == nil {
+		t.Fatal("expected default logger entry, got nil")
+	}
+
+	logger := log.WithField("foo", "bar")
+	ctx := WithLogger(context.Background(), logger)
+	if l := Logger(ctx); l != logger {
+		t.Errorf("expected logger %+v, got %+v", logger, l)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if id := RequestID(context.Background()); id != "" {
+		t.Errorf("expected empty request id, got %s", id)
+	}
+
+	ctx := WithLogger(context.Background(), log.WithField("foo", "bar"))
+	ctx = WithRequestID(ctx, "abc-123")
+
+	if id := RequestID(ctx); id != "abc-123" {
+		t.Errorf("expected request id abc-123, got %s", id)
+	}
+
+	fields := Logger(ctx).Data
+	if fields["request_id"] != "abc-123" {
+		t.Errorf("expected request_id field abc-123, got %v", fields["request_id"])
+	}
+
+	if fields["foo"] != "bar" {
+		t.Errorf("expected existing logger fields to be kept, got %v", fields)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -30,7 +31,9 @@ func New(opts ...DocDBOption) DocDB {
 	}
 
 	if e.session != nil {
-		e.Service = docdb.New(e.session)
+		svc := docdb.New(e.session)
+		svc.Handlers.Complete.PushBackNamed(metricsHandler)
+		e.Service = svc
 	}
 
 	return e
@@ -63,21 +66,27 @@ func WithDefaultKMSKeyId(keyId string) DocDBOption {
 
 // GetDBSubnetGroup gets documentDB DBSubnetGroup by name
 func (d *DocDB) GetDBSubnetGroup(ctx context.Context, name string) ([]*docdb.DBSubnetGroup, error) {
-	log.Debugf("getting details for documentDB subnet group: %s", name)
+	ctx, span := startSpan(ctx, "docdb.GetDBSubnetGroup")
+	defer span.End()
 
-	out, err := d.Service.DescribeDBSubnetGroups(&docdb.DescribeDBSubnetGroupsInput{DBSubnetGroupName: aws.String(name)})
+	common.Logger(ctx).Debugf("getting details for documentDB subnet group: %s", name)
+
+	out, err := d.Service.DescribeDBSubnetGroupsWithContext(ctx, &docdb.DescribeDBSubnetGroupsInput{DBSubnetGroupName: aws.String(name)})
 	if err != nil {
-		return nil, ErrCode("failed to get subnet groups", err)
+		return nil, spanError(span, ErrCode("failed to get subnet groups", err))
 	}
 
-	log.Debugf("search output for documentDB db subnet group: %+v", out.DBSubnetGroups)
+	common.Logger(ctx).Debugf("search output for documentDB db subnet group: %+v", out.DBSubnetGroups)
 
 	return out.DBSubnetGroups, nil
 }
 
 // ListDocDBs lists all documentDB clusters
-func (d *DocDB) ListDocDBClusters(ctx context.Context) ([]string, error) {
-	log.Debug("listing documentDB clusters")
+func (d *DocDB) ListDocDBClusters(ctx context.Context) ([]*docdb.DBCluster, error) {
+	ctx, span := startSpan(ctx, "docdb.ListDocDBClusters")
+	defer span.End()
+
+	common.Logger(ctx).Debug("listing documentDB clusters")
 
 	filters := []*docdb.Filter{
 		{
@@ -86,33 +95,33 @@ func (d *DocDB) ListDocDBClusters(ctx context.Context) ([]string, error) {
 		},
 	}
 
-	clusters := []string{}
+	clusters := []*docdb.DBCluster{}
 	if err := d.Service.DescribeDBClustersPagesWithContext(ctx,
 		&docdb.DescribeDBClustersInput{Filters: filters},
 		func(page *docdb.DescribeDBClustersOutput, lastPage bool) bool {
-			for _, c := range page.DBClusters {
-				clusters = append(clusters, aws.StringValue(c.DBClusterIdentifier))
-			}
-
+			clusters = append(clusters, page.DBClusters...)
 			return true
 		}); err != nil {
-		return nil, ErrCode("failed to list clusters", err)
+		return nil, spanError(span, ErrCode("failed to list clusters", err))
 	}
 
-	log.Debugf("listing documentDB clusters output: %+v", clusters)
+	common.Logger(ctx).Debugf("listed %d documentDB clusters", len(clusters))
 
 	return clusters, nil
 }
 
 // GetDocDBDetails gets information about a documentDB cluster
 func (d *DocDB) GetDocDBDetails(ctx context.Context, name string) (*docdb.DBCluster, error) {
-	log.Debugf("getting information about documentDB cluster %s", name)
+	ctx, span := startSpan(ctx, "docdb.GetDocDBDetails")
+	defer span.End()
+
+	common.Logger(ctx).Debugf("getting information about documentDB cluster %s", name)
 
 	out, err := d.Service.DescribeDBClustersWithContext(ctx, &docdb.DescribeDBClustersInput{
 		DBClusterIdentifier: aws.String(name),
 	})
 	if err != nil {
-		return nil, ErrCode("failed to get details", err)
+		return nil, spanError(span, ErrCode("failed to get details", err))
 	}
 
 	if len(out.DBClusters) == 0 {
@@ -125,14 +134,17 @@ func (d *DocDB) GetDocDBDetails(ctx context.Context, name string) (*docdb.DBClus
 		return nil, apierror.New(apierror.ErrInternalError, msg, nil)
 	}
 
-	log.Debugf("getting documentDB cluster and instance(s) output: %+v", out)
+	common.Logger(ctx).Debugf("getting documentDB cluster and instance(s) output: %+v", out)
 
 	return out.DBClusters[0], err
 }
 
 // GetDocDBInstances gets information about all instances in a documentDB cluster
 func (d *DocDB) GetDocDBInstances(ctx context.Context, name string) ([]*docdb.DBInstance, error) {
-	log.Debugf("getting information about documentDB instances in cluster %s", name)
+	ctx, span := startSpan(ctx, "docdb.GetDocDBInstances")
+	defer span.End()
+
+	common.Logger(ctx).Debugf("getting information about documentDB instances in cluster %s", name)
 
 	filters := []*docdb.Filter{
 		{
@@ -145,186 +157,329 @@ func (d *DocDB) GetDocDBInstances(ctx context.Context, name string) ([]*docdb.DB
 		Filters: filters,
 	})
 	if err != nil {
-		return nil, ErrCode("failed to get instances", err)
+		return nil, spanError(span, ErrCode("failed to get instances", err))
 	}
 
-	log.Debugf("getting documentDB instances output: %+v", out)
+	common.Logger(ctx).Debugf("getting documentDB instances output: %+v", out)
 
 	return out.DBInstances, err
 }
 
+// ListDocDBInstances lists all documentDB instances
+func (d *DocDB) ListDocDBInstances(ctx context.Context) ([]*docdb.DBInstance, error) {
+	ctx, span := startSpan(ctx, "docdb.ListDocDBInstances")
+	defer span.End()
+
+	common.Logger(ctx).Debug("listing documentDB instances")
+
+	filters := []*docdb.Filter{
+		{
+			Name:   aws.String("engine"),
+			Values: aws.StringSlice([]string{"docdb"}),
+		},
+	}
+
+	instances := []*docdb.DBInstance{}
+	if err := d.Service.DescribeDBInstancesPagesWithContext(ctx,
+		&docdb.DescribeDBInstancesInput{Filters: filters},
+		func(page *docdb.DescribeDBInstancesOutput, lastPage bool) bool {
+			instances = append(instances, page.DBInstances...)
+			return true
+		}); err != nil {
+		return nil, spanError(span, ErrCode("failed to list instances", err))
+	}
+
+	return instances, nil
+}
+
 // GetDocDBTags gets the tags for a documentDB cluster
 func (d *DocDB) GetDocDBTags(ctx context.Context, arn *string) ([]*docdb.Tag, error) {
-	log.Debugf("getting tags for documentDB cluster %s", aws.StringValue(arn))
+	ctx, span := startSpan(ctx, "docdb.GetDocDBTags")
+	defer span.End()
+
+	common.Logger(ctx).Debugf("getting tags for documentDB cluster %s", aws.StringValue(arn))
 
 	out, err := d.Service.ListTagsForResourceWithContext(ctx, &docdb.ListTagsForResourceInput{
 		ResourceName: arn,
 	})
 	if err != nil {
-		return nil, ErrCode("failed to get tags", err)
+		return nil, spanError(span, ErrCode("failed to get tags", err))
 	}
 
-	log.Debugf("getting documentDB tags output: %+v", out)
+	common.Logger(ctx).Debugf("getting documentDB tags output: %+v", out)
 
 	return out.TagList, err
 }
 
-// CreateDBCluster creates a documentDB cluster
+// CreateDBCluster creates a documentDB cluster, encrypted with the default kms key if none is given
 func (d *DocDB) CreateDBCluster(ctx context.Context, input *docdb.CreateDBClusterInput) (*docdb.DBCluster, error) {
+	ctx, span := startSpan(ctx, "docdb.CreateDBCluster")
+	defer span.End()
+
 	if input == nil {
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("creating documentDB cluster: %s", aws.StringValue(input.DBClusterIdentifier))
+	if input.KmsKeyId == nil && d.DefaultKMSKeyId != "" {
+		input.KmsKeyId = aws.String(d.DefaultKMSKeyId)
+	}
+
+	common.Logger(ctx).Infof("creating documentDB cluster: %s", aws.StringValue(input.DBClusterIdentifier))
 
-	out, err := d.Service.CreateDBCluster(input)
+	out, err := d.Service.CreateDBClusterWithContext(ctx, input)
 	if err != nil {
-		return nil, ErrCode("failed to create cluster", err)
+		return nil, spanError(span, ErrCode("failed to create cluster", err))
 	}
 
-	log.Debugf("created documentDB cluster with output: %+v", out.DBCluster)
+	common.Logger(ctx).Debugf("created documentDB cluster with output: %+v", out.DBCluster)
 
 	return out.DBCluster, nil
 }
 
 // CreateDBInstance creates a documentDB instance
 func (d *DocDB) CreateDBInstance(ctx context.Context, input *docdb.CreateDBInstanceInput) (*docdb.DBInstance, error) {
+	ctx, span := startSpan(ctx, "docdb.CreateDBInstance")
+	defer span.End()
+
 	if input == nil {
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("creating documentDB instance: %s", aws.StringValue(input.DBInstanceIdentifier))
+	common.Logger(ctx).Infof("creating documentDB instance: %s", aws.StringValue(input.DBInstanceIdentifier))
 
-	out, err := d.Service.CreateDBInstance(input)
+	out, err := d.Service.CreateDBInstanceWithContext(ctx, input)
 	if err != nil {
-		return nil, ErrCode("failed to create instance", err)
+		return nil, spanError(span, ErrCode("failed to create instance", err))
 	}
 
-	log.Debugf("created documentDB instance with output: %+v", out.DBInstance)
+	common.Logger(ctx).Debugf("created documentDB instance with output: %+v", out.DBInstance)
 
 	return out.DBInstance, nil
 }
 
 // CreateDBSubnetGroup creates a documentDB DBSubnetGroup
 func (d *DocDB) CreateDBSubnetGroup(ctx context.Context, input *docdb.CreateDBSubnetGroupInput) (*docdb.DBSubnetGroup, error) {
+	ctx, span := startSpan(ctx, "docdb.CreateDBSubnetGroup")
+	defer span.End()
+
 	if input == nil {
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("creating documentDB DBSubnetGroup: %s", aws.StringValue(input.DBSubnetGroupName))
+	common.Logger(ctx).Infof("creating documentDB DBSubnetGroup: %s", aws.StringValue(input.DBSubnetGroupName))
 
-	out, err := d.Service.CreateDBSubnetGroup(input)
+	out, err := d.Service.CreateDBSubnetGroupWithContext(ctx, input)
 	if err != nil {
-		return nil, ErrCode("failed to create subnet group", err)
+		return nil, spanError(span, ErrCode("failed to create subnet group", err))
 	}
 
-	log.Debugf("created documentDB DBSubnetGroup with output: %+v", out.DBSubnetGroup)
+	common.Logger(ctx).Debugf("created documentDB DBSubnetGroup with output: %+v", out.DBSubnetGroup)
 
 	return out.DBSubnetGroup, nil
 }
 
 // DeleteDBCluster deletes a documentDB cluster
 func (d *DocDB) DeleteDBCluster(ctx context.Context, input *docdb.DeleteDBClusterInput) (*docdb.DeleteDBClusterOutput, error) {
+	ctx, span := startSpan(ctx, "docdb.DeleteDBCluster")
+	defer span.End()
+
 	if input == nil {
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("deleting documentDB cluster: %s", aws.StringValue(input.DBClusterIdentifier))
+	common.Logger(ctx).Infof("deleting documentDB cluster: %s", aws.StringValue(input.DBClusterIdentifier))
 
-	out, err := d.Service.DeleteDBCluster(input)
+	out, err := d.Service.DeleteDBClusterWithContext(ctx, input)
 	if err != nil {
-		return nil, ErrCode("failed to delete cluster", err)
+		return nil, spanError(span, ErrCode("failed to delete cluster", err))
 	}
 
-	log.Debugf("deleted documentDB cluster with output: %+v", out)
+	common.Logger(ctx).Debugf("deleted documentDB cluster with output: %+v", out)
 
 	return out, nil
 }
 
 // DeleteDBInstance deletes a documentDB instance
 func (d *DocDB) DeleteDBInstance(ctx context.Context, input *docdb.DeleteDBInstanceInput) (*docdb.DeleteDBInstanceOutput, error) {
+	ctx, span := startSpan(ctx, "docdb.DeleteDBInstance")
+	defer span.End()
+
 	if input == nil {
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("deleting documentDB instance: %s", aws.StringValue(input.DBInstanceIdentifier))
+	common.Logger(ctx).Infof("deleting documentDB instance: %s", aws.StringValue(input.DBInstanceIdentifier))
 
-	out, err := d.Service.DeleteDBInstance(input)
+	out, err := d.Service.DeleteDBInstanceWithContext(ctx, input)
 	if err != nil {
-		return nil, ErrCode("failed to delete instance", err)
+		return nil, spanError(span, ErrCode("failed to delete instance", err))
 	}
 
-	log.Debugf("deleted documentDB instance with output: %+v", out)
+	common.Logger(ctx).Debugf("deleted documentDB instance with output: %+v", out)
 
 	return out, nil
 }
 
 // ModifyDBCluster modifies a documentDB cluster
 func (d *DocDB) ModifyDBCluster(ctx context.Context, input *docdb.ModifyDBClusterInput) (*docdb.DBCluster, error) {
+	ctx, span := startSpan(ctx, "docdb.ModifyDBCluster")
+	defer span.End()
+
 	if input == nil {
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("modifying documentDB cluster: %s", aws.StringValue(input.DBClusterIdentifier))
+	common.Logger(ctx).Infof("modifying documentDB cluster: %s", aws.StringValue(input.DBClusterIdentifier))
 
-	out, err := d.Service.ModifyDBCluster(input)
+	out, err := d.Service.ModifyDBClusterWithContext(ctx, input)
 	if err != nil {
-		return nil, ErrCode("failed to modify cluster", err)
+		return nil, spanError(span, ErrCode("failed to modify cluster", err))
 	}
 
-	log.Debugf("modified documentDB cluster with output: %+v", out.DBCluster)
+	common.Logger(ctx).Debugf("modified documentDB cluster with output: %+v", out.DBCluster)
 
 	return out.DBCluster, nil
 }
 
 // ModifyDBInstance modifies a documentDB instance
 func (d *DocDB) ModifyDBInstance(ctx context.Context, input *docdb.ModifyDBInstanceInput) (*docdb.DBInstance, error) {
+	ctx, span := startSpan(ctx, "docdb.ModifyDBInstance")
+	defer span.End()
+
 	if input == nil {
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("modifying documentDB instance: %s", aws.StringValue(input.DBInstanceIdentifier))
+	common.Logger(ctx).Infof("modifying documentDB instance: %s", aws.StringValue(input.DBInstanceIdentifier))
 
-	out, err := d.Service.ModifyDBInstance(input)
+	out, err := d.Service.ModifyDBInstanceWithContext(ctx, input)
 	if err != nil {
-		return nil, ErrCode("failed to modify instance", err)
+		return nil, spanError(span, ErrCode("failed to modify instance", err))
 	}
 
-	log.Debugf("modified documentDB instance with output: %+v", out.DBInstance)
+	common.Logger(ctx).Debugf("modified documentDB instance with output: %+v", out.DBInstance)
 
 	return out.DBInstance, nil
 }
 
 func (d *DocDB) StartDBCluster(ctx context.Context, name string) error {
+	ctx, span := startSpan(ctx, "docdb.StartDBCluster")
+	defer span.End()
+
 	if name == "" {
 		return apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("starting documentDB cluster: %s", name)
+	common.Logger(ctx).Infof("starting documentDB cluster: %s", name)
 	inp := &docdb.StartDBClusterInput{
 		DBClusterIdentifier: aws.String(name),
 	}
 
 	if _, err := d.Service.StartDBClusterWithContext(ctx, inp); err != nil {
-		return ErrCode("starting instance", err)
+		return spanError(span, ErrCode("starting instance", err))
 	}
 
 	return nil
 }
 
 func (d *DocDB) StopDBCluster(ctx context.Context, name string) error {
+	ctx, span := startSpan(ctx, "docdb.StopDBCluster")
+	defer span.End()
+
 	if name == "" {
 		return apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("stoping documentDB cluster: %s", name)
+	common.Logger(ctx).Infof("stoping documentDB cluster: %s", name)
 	input := &docdb.StopDBClusterInput{
 		DBClusterIdentifier: aws.String(name),
 	}
 
 	if _, err := d.Service.StopDBClusterWithContext(ctx, input); err != nil {
-		return ErrCode("stoping instance", err)
+		return spanError(span, ErrCode("stoping instance", err))
 	}
 
 	return nil
 }
+
+// GetLatestDBClusterSnapshot gets the most recent available snapshot of a documentDB cluster, automated or manual
+func (d *DocDB) GetLatestDBClusterSnapshot(ctx context.Context, name string) (*docdb.DBClusterSnapshot, error) {
+	ctx, span := startSpan(ctx, "docdb.GetLatestDBClusterSnapshot")
+	defer span.End()
+
+	if name == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Debugf("getting latest snapshot of documentDB cluster %s", name)
+
+	var latest *docdb.DBClusterSnapshot
+	if err := d.Service.DescribeDBClusterSnapshotsPagesWithContext(ctx,
+		&docdb.DescribeDBClusterSnapshotsInput{DBClusterIdentifier: aws.String(name)},
+		func(page *docdb.DescribeDBClusterSnapshotsOutput, lastPage bool) bool {
+			for _, s := range page.DBClusterSnapshots {
+				if aws.StringValue(s.Status) != "available" {
+					continue
+				}
+
+				if latest == nil || aws.TimeValue(s.SnapshotCreateTime).After(aws.TimeValue(latest.SnapshotCreateTime)) {
+					latest = s
+				}
+			}
+
+			return true
+		}); err != nil {
+		return nil, spanError(span, ErrCode("failed to list snapshots", err))
+	}
+
+	if latest == nil {
+		msg := fmt.Sprintf("no available snapshot of %s found", name)
+		return nil, apierror.New(apierror.ErrNotFound, msg, nil)
+	}
+
+	common.Logger(ctx).Debugf("latest documentDB cluster snapshot: %+v", latest)
+
+	return latest, nil
+}
+
+// RestoreDBClusterFromSnapshot creates a documentDB cluster from a snapshot
+func (d *DocDB) RestoreDBClusterFromSnapshot(ctx context.Context, input *docdb.RestoreDBClusterFromSnapshotInput) (*docdb.DBCluster, error) {
+	ctx, span := startSpan(ctx, "docdb.RestoreDBClusterFromSnapshot")
+	defer span.End()
+
+	if input == nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Infof("restoring documentDB cluster %s from snapshot %s", aws.StringValue(input.DBClusterIdentifier), aws.StringValue(input.SnapshotIdentifier))
+
+	out, err := d.Service.RestoreDBClusterFromSnapshotWithContext(ctx, input)
+	if err != nil {
+		return nil, spanError(span, ErrCode("failed to restore cluster from snapshot", err))
+	}
+
+	common.Logger(ctx).Debugf("restored documentDB cluster with output: %+v", out.DBCluster)
+
+	return out.DBCluster, nil
+}
+
+// RestoreDBClusterToPointInTime creates a documentDB cluster from a point in time of another cluster
+func (d *DocDB) RestoreDBClusterToPointInTime(ctx context.Context, input *docdb.RestoreDBClusterToPointInTimeInput) (*docdb.DBCluster, error) {
+	ctx, span := startSpan(ctx, "docdb.RestoreDBClusterToPointInTime")
+	defer span.End()
+
+	if input == nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Infof("restoring documentDB cluster %s from cluster %s", aws.StringValue(input.DBClusterIdentifier), aws.StringValue(input.SourceDBClusterIdentifier))
+
+	out, err := d.Service.RestoreDBClusterToPointInTimeWithContext(ctx, input)
+	if err != nil {
+		return nil, spanError(span, ErrCode("failed to restore cluster to point in time", err))
+	}
+
+	common.Logger(ctx).Debugf("restored documentDB cluster with output: %+v", out.DBCluster)
+
+	return out.DBCluster, nil
+}
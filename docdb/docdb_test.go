@@ -0,0 +1,122 @@
+package docdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/docdb"
+	"github.com/aws/aws-sdk-go/service/docdb/docdbiface"
+)
+
+// mockDocDBClient is a fake docdb client
+type mockDocDBClient struct {
+	docdbiface.DocDBAPI
+	t *testing.T
+
+	createClusterInput *docdb.CreateDBClusterInput
+	snapshots          []*docdb.DBClusterSnapshot
+}
+
+func newMockDocDBClient(t *testing.T) *mockDocDBClient {
+	return &mockDocDBClient{t: t}
+}
+
+func (m *mockDocDBClient) CreateDBClusterWithContext(ctx aws.Context, input *docdb.CreateDBClusterInput, opts ...request.Option) (*docdb.CreateDBClusterOutput, error) {
+	m.createClusterInput = input
+	return &docdb.CreateDBClusterOutput{
+		DBCluster: &docdb.DBCluster{
+			DBClusterIdentifier: input.DBClusterIdentifier,
+			KmsKeyId:            input.KmsKeyId,
+		},
+	}, nil
+}
+
+func (m *mockDocDBClient) DescribeDBClusterSnapshotsPagesWithContext(ctx aws.Context, input *docdb.DescribeDBClusterSnapshotsInput, fn func(*docdb.DescribeDBClusterSnapshotsOutput, bool) bool, opts ...request.Option) error {
+	// one snapshot per page
+	for i, s := range m.snapshots {
+		if !fn(&docdb.DescribeDBClusterSnapshotsOutput{DBClusterSnapshots: []*docdb.DBClusterSnapshot{s}}, i == len(m.snapshots)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestNewSession(t *testing.T) {
+	client := New(WithDefaultKMSKeyId("arn:aws:kms:us-east-1:123456789012:key/abc"))
+	if client.DefaultKMSKeyId != "arn:aws:kms:us-east-1:123456789012:key/abc" {
+		t.Errorf("expected default kms key id to be set, got %s", client.DefaultKMSKeyId)
+	}
+}
+
+func TestCreateDBClusterDefaultKMSKey(t *testing.T) {
+	mock := newMockDocDBClient(t)
+	d := DocDB{Service: mock, DefaultKMSKeyId: "default-key"}
+
+	// uses the default key
+	out, err := d.CreateDBCluster(context.TODO(), &docdb.CreateDBClusterInput{
+		DBClusterIdentifier: aws.String("mydocdb"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if aws.StringValue(out.KmsKeyId) != "default-key" {
+		t.Errorf("expected default kms key, got %s", aws.StringValue(out.KmsKeyId))
+	}
+
+	// keeps the given key
+	out, err = d.CreateDBCluster(context.TODO(), &docdb.CreateDBClusterInput{
+		DBClusterIdentifier: aws.String("mydocdb"),
+		KmsKeyId:            aws.String("my-key"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if aws.StringValue(out.KmsKeyId) != "my-key" {
+		t.Errorf("expected given kms key, got %s", aws.StringValue(out.KmsKeyId))
+	}
+
+	// no default key
+	d = DocDB{Service: mock}
+	if _, err := d.CreateDBCluster(context.TODO(), &docdb.CreateDBClusterInput{DBClusterIdentifier: aws.String("mydocdb")}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if mock.createClusterInput.KmsKeyId != nil {
+		t.Errorf("expected no kms key, got %s", aws.StringValue(mock.createClusterInput.KmsKeyId))
+	}
+
+	if _, err := d.CreateDBCluster(context.TODO(), nil); err == nil {
+		t.Error("expected error for nil input, got nil")
+	}
+}
+
+func TestGetLatestDBClusterSnapshot(t *testing.T) {
+	now := time.Now()
+	mock := newMockDocDBClient(t)
+	d := DocDB{Service: mock}
+
+	if _, err := d.GetLatestDBClusterSnapshot(context.TODO(), "mydocdb"); err == nil {
+		t.Error("expected error for a cluster without snapshots, got nil")
+	}
+
+	mock.snapshots = []*docdb.DBClusterSnapshot{
+		{DBClusterSnapshotIdentifier: aws.String("old"), Status: aws.String("available"), SnapshotCreateTime: aws.Time(now.Add(-48 * time.Hour))},
+		{DBClusterSnapshotIdentifier: aws.String("latest"), Status: aws.String("available"), SnapshotCreateTime: aws.Time(now.Add(-time.Hour))},
+		{DBClusterSnapshotIdentifier: aws.String("creating"), Status: aws.String("creating"), SnapshotCreateTime: aws.Time(now)},
+		{DBClusterSnapshotIdentifier: aws.String("older"), Status: aws.String("available"), SnapshotCreateTime: aws.Time(now.Add(-24 * time.Hour))},
+	}
+
+	s, err := d.GetLatestDBClusterSnapshot(context.TODO(), "mydocdb")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if id := aws.StringValue(s.DBClusterSnapshotIdentifier); id != "latest" {
+		t.Errorf("expected the latest available snapshot, got %s", id)
+	}
+}
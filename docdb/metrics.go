@@ -0,0 +1,49 @@
+package docdb
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	callsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "docdb_api",
+		Subsystem: "docdb",
+		Name:      "calls_total",
+		Help:      "Number of calls to the AWS DocumentDB API by operation and AWS error code.",
+	}, []string{"operation", "code"})
+
+	callDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "docdb_api",
+		Subsystem: "docdb",
+		Name:      "call_duration_seconds",
+		Help:      "Duration of calls to the AWS DocumentDB API by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+// metricsHandler records the outcome and duration of every completed AWS DocumentDB API call
+var metricsHandler = request.NamedHandler{
+	Name: "docdb-api.metrics",
+	Fn: func(r *request.Request) {
+		callsTotal.WithLabelValues(r.Operation.Name, errorCode(r.Error)).Inc()
+		callDuration.WithLabelValues(r.Operation.Name).Observe(time.Since(r.Time).Seconds())
+	},
+}
+
+// errorCode returns the AWS error code for the metric labels, OK for successful calls
+func errorCode(err error) string {
+	if err == nil {
+		return "OK"
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+
+	return "Unknown"
+}
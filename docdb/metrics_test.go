@@ -0,0 +1,38 @@
+package docdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/docdb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	tests := []struct {
+		operation string
+		err       error
+		code      string
+	}{
+		{operation: "DescribeDBClusters", err: nil, code: "OK"},
+		{operation: "CreateDBCluster", err: awserr.New(docdb.ErrCodeDBClusterAlreadyExistsFault, "exists", nil), code: docdb.ErrCodeDBClusterAlreadyExistsFault},
+		{operation: "StopDBCluster", err: errors.New("boom"), code: "Unknown"},
+	}
+
+	for _, tt := range tests {
+		before := testutil.ToFloat64(callsTotal.WithLabelValues(tt.operation, tt.code))
+
+		metricsHandler.Fn(&request.Request{
+			Operation: &request.Operation{Name: tt.operation},
+			Error:     tt.err,
+			Time:      time.Now().Add(-1 * time.Second),
+		})
+
+		if got := testutil.ToFloat64(callsTotal.WithLabelValues(tt.operation, tt.code)); got != before+1 {
+			t.Errorf("expected %s/%s call count %f, got %f", tt.operation, tt.code, before+1, got)
+		}
+	}
+}
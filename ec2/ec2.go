@@ -0,0 +1,297 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	log "github.com/sirupsen/logrus"
+)
+
+// EC2 is a wrapper around the aws ec2 service
+type EC2 struct {
+	session *session.Session
+	Service ec2iface.EC2API
+}
+
+type EC2Option func(*EC2)
+
+func New(opts ...EC2Option) *EC2 {
+	client := EC2{}
+
+	for _, opt := range opts {
+		opt(&client)
+	}
+
+	if client.session != nil {
+		client.Service = ec2.New(client.session)
+	}
+
+	return &client
+}
+
+func WithSession(sess *session.Session) EC2Option {
+	return func(client *EC2) {
+		log.Debug("using aws session")
+		client.session = sess
+	}
+}
+
+func WithCredentials(key, secret, token, region string) EC2Option {
+	return func(client *EC2) {
+		log.Debugf("creating new session with key id %s in region %s", key, region)
+		sess := session.Must(session.NewSession(&aws.Config{
+			Credentials: credentials.NewStaticCredentials(key, secret, token),
+			Region:      aws.String(region),
+		}))
+		client.session = sess
+	}
+}
+
+// GetSubnets returns the details of the given subnets
+func (e *EC2) GetSubnets(ctx context.Context, ids []string) ([]*ec2.Subnet, error) {
+	ctx, span := startSpan(ctx, "ec2.GetSubnets")
+	defer span.End()
+
+	if len(ids) == 0 {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Infof("getting details about subnets %v", ids)
+
+	out, err := e.Service.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(ids),
+	})
+	if err != nil {
+		return nil, spanError(span, ErrCode("failed to describe subnets", err))
+	}
+
+	common.Logger(ctx).Debugf("got output from describe subnets: %+v", out)
+
+	return out.Subnets, nil
+}
+
+// ListSubnetsWithTags returns all subnets with the given tags
+func (e *EC2) ListSubnetsWithTags(ctx context.Context, tags map[string]string) ([]*ec2.Subnet, error) {
+	ctx, span := startSpan(ctx, "ec2.ListSubnetsWithTags")
+	defer span.End()
+
+	if len(tags) == 0 {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Infof("listing subnets with tags %v", tags)
+
+	subnets := []*ec2.Subnet{}
+	if err := e.Service.DescribeSubnetsPagesWithContext(ctx, &ec2.DescribeSubnetsInput{
+		Filters: tagFilters(tags),
+	}, func(out *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+		subnets = append(subnets, out.Subnets...)
+		return true
+	}); err != nil {
+		return nil, spanError(span, ErrCode("failed to list subnets", err))
+	}
+
+	return subnets, nil
+}
+
+// ListSecurityGroupsWithTags returns all security groups in the vpc with the given tags
+func (e *EC2) ListSecurityGroupsWithTags(ctx context.Context, vpcId string, tags map[string]string) ([]*ec2.SecurityGroup, error) {
+	ctx, span := startSpan(ctx, "ec2.ListSecurityGroupsWithTags")
+	defer span.End()
+
+	if vpcId == "" || len(tags) == 0 {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Infof("listing security groups in vpc %s with tags %v", vpcId, tags)
+
+	filters := append(tagFilters(tags), &ec2.Filter{
+		Name:   aws.String("vpc-id"),
+		Values: aws.StringSlice([]string{vpcId}),
+	})
+
+	groups := []*ec2.SecurityGroup{}
+	if err := e.Service.DescribeSecurityGroupsPagesWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: filters,
+	}, func(out *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+		groups = append(groups, out.SecurityGroups...)
+		return true
+	}); err != nil {
+		return nil, spanError(span, ErrCode("failed to list security groups", err))
+	}
+
+	return groups, nil
+}
+
+// tagFilters returns the describe filters matching all of the tags, in a stable order
+func tagFilters(tags map[string]string) []*ec2.Filter {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	filters := []*ec2.Filter{}
+	for _, k := range keys {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String(fmt.Sprintf("tag:%s", k)),
+			Values: aws.StringSlice([]string{tags[k]}),
+		})
+	}
+
+	return filters
+}
+
+// GetSecurityGroups returns the details of the given security groups
+func (e *EC2) GetSecurityGroups(ctx context.Context, ids []string) ([]*ec2.SecurityGroup, error) {
+	ctx, span := startSpan(ctx, "ec2.GetSecurityGroups")
+	defer span.End()
+
+	if len(ids) == 0 {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Infof("getting details about security groups %v", ids)
+
+	out, err := e.Service.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: aws.StringSlice(ids),
+	})
+	if err != nil {
+		return nil, spanError(span, ErrCode("failed to describe security groups", err))
+	}
+
+	common.Logger(ctx).Debugf("got output from describe security groups: %+v", out)
+
+	return out.SecurityGroups, nil
+}
+
+// CreateSecurityGroup creates a tagged security group in the vpc and returns its id
+func (e *EC2) CreateSecurityGroup(ctx context.Context, name, description, vpcId string, tags []*ec2.Tag) (string, error) {
+	ctx, span := startSpan(ctx, "ec2.CreateSecurityGroup")
+	defer span.End()
+
+	if name == "" || vpcId == "" {
+		return "", apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Infof("creating security group %s in vpc %s", name, vpcId)
+
+	input := &ec2.CreateSecurityGroupInput{
+		Description: aws.String(description),
+		GroupName:   aws.String(name),
+		VpcId:       aws.String(vpcId),
+	}
+
+	if len(tags) > 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String("security-group"),
+				Tags:         tags,
+			},
+		}
+	}
+
+	out, err := e.Service.CreateSecurityGroupWithContext(ctx, input)
+	if err != nil {
+		return "", spanError(span, ErrCode("failed to create security group", err))
+	}
+
+	return aws.StringValue(out.GroupId), nil
+}
+
+// DeleteSecurityGroup deletes the security group
+func (e *EC2) DeleteSecurityGroup(ctx context.Context, id string) error {
+	ctx, span := startSpan(ctx, "ec2.DeleteSecurityGroup")
+	defer span.End()
+
+	if id == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	common.Logger(ctx).Infof("deleting security group %s", id)
+
+	if _, err := e.Service.DeleteSecurityGroupWithContext(ctx, &ec2.DeleteSecurityGroupInput{
+		GroupId: aws.String(id),
+	}); err != nil {
+		return spanError(span, ErrCode("failed to delete security group", err))
+	}
+
+	return nil
+}
+
+// AuthorizeIngress allows tcp traffic to the port of the security group from the cidrs and source security groups
+func (e *EC2) AuthorizeIngress(ctx context.Context, id string, port int64, cidrs, sourceGroups []string) error {
+	ctx, span := startSpan(ctx, "ec2.AuthorizeIngress")
+	defer span.End()
+
+	if id == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	if len(cidrs) == 0 && len(sourceGroups) == 0 {
+		return nil
+	}
+
+	common.Logger(ctx).Infof("authorizing ingress to port %d of security group %s from %v %v", port, id, cidrs, sourceGroups)
+
+	if _, err := e.Service.AuthorizeSecurityGroupIngressWithContext(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(id),
+		IpPermissions: ingressPermissions(port, cidrs, sourceGroups),
+	}); err != nil {
+		return spanError(span, ErrCode("failed to authorize security group ingress", err))
+	}
+
+	return nil
+}
+
+// RevokeIngress removes tcp traffic to the port of the security group from the cidrs and source security groups
+func (e *EC2) RevokeIngress(ctx context.Context, id string, port int64, cidrs, sourceGroups []string) error {
+	ctx, span := startSpan(ctx, "ec2.RevokeIngress")
+	defer span.End()
+
+	if id == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", nil)
+	}
+
+	if len(cidrs) == 0 && len(sourceGroups) == 0 {
+		return nil
+	}
+
+	common.Logger(ctx).Infof("revoking ingress to port %d of security group %s from %v %v", port, id, cidrs, sourceGroups)
+
+	if _, err := e.Service.RevokeSecurityGroupIngressWithContext(ctx, &ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(id),
+		IpPermissions: ingressPermissions(port, cidrs, sourceGroups),
+	}); err != nil {
+		return spanError(span, ErrCode("failed to revoke security group ingress", err))
+	}
+
+	return nil
+}
+
+// ingressPermissions returns the tcp permission for the port from the cidrs and source security groups
+func ingressPermissions(port int64, cidrs, sourceGroups []string) []*ec2.IpPermission {
+	permission := &ec2.IpPermission{
+		FromPort:   aws.Int64(port),
+		IpProtocol: aws.String("tcp"),
+		ToPort:     aws.Int64(port),
+	}
+
+	for _, c := range cidrs {
+		permission.IpRanges = append(permission.IpRanges, &ec2.IpRange{CidrIp: aws.String(c)})
+	}
+
+	for _, g := range sourceGroups {
+		permission.UserIdGroupPairs = append(permission.UserIdGroupPairs, &ec2.UserIdGroupPair{GroupId: aws.String(g)})
+	}
+
+	return []*ec2.IpPermission{permission}
+}
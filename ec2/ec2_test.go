@@ -0,0 +1,320 @@
+package ec2
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// mockEC2Client is a fake ec2 client
+type mockEC2Client struct {
+	ec2iface.EC2API
+	t           *testing.T
+	err         error
+	filters     []*ec2.Filter
+	permissions []*ec2.IpPermission
+	created     *ec2.CreateSecurityGroupInput
+	deleted     string
+}
+
+func newmockEC2Client(t *testing.T, err error) *mockEC2Client {
+	return &mockEC2Client{
+		t:   t,
+		err: err,
+	}
+}
+
+var testSubnets = []*ec2.Subnet{
+	{SubnetId: aws.String("subnet-1"), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String("us-east-1a")},
+	{SubnetId: aws.String("subnet-2"), VpcId: aws.String("vpc-1"), AvailabilityZone: aws.String("us-east-1b")},
+}
+
+func (m *mockEC2Client) DescribeSubnetsWithContext(ctx context.Context, input *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	out := []*ec2.Subnet{}
+	for _, id := range aws.StringValueSlice(input.SubnetIds) {
+		for _, s := range testSubnets {
+			if aws.StringValue(s.SubnetId) == id {
+				out = append(out, s)
+			}
+		}
+	}
+
+	return &ec2.DescribeSubnetsOutput{Subnets: out}, nil
+}
+
+func (m *mockEC2Client) DescribeSubnetsPagesWithContext(ctx context.Context, input *ec2.DescribeSubnetsInput, fn func(*ec2.DescribeSubnetsOutput, bool) bool, opts ...request.Option) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.filters = input.Filters
+	fn(&ec2.DescribeSubnetsOutput{Subnets: testSubnets[:1]}, false)
+	fn(&ec2.DescribeSubnetsOutput{Subnets: testSubnets[1:]}, true)
+
+	return nil
+}
+
+func (m *mockEC2Client) DescribeSecurityGroupsPagesWithContext(ctx context.Context, input *ec2.DescribeSecurityGroupsInput, fn func(*ec2.DescribeSecurityGroupsOutput, bool) bool, opts ...request.Option) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.filters = input.Filters
+	fn(&ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{{GroupId: aws.String("sg-1")}}}, true)
+
+	return nil
+}
+
+func (m *mockEC2Client) DescribeSecurityGroupsWithContext(ctx context.Context, input *ec2.DescribeSecurityGroupsInput, opts ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	out := []*ec2.SecurityGroup{}
+	for _, id := range aws.StringValueSlice(input.GroupIds) {
+		out = append(out, &ec2.SecurityGroup{GroupId: aws.String(id)})
+	}
+
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: out}, nil
+}
+
+func (m *mockEC2Client) CreateSecurityGroupWithContext(ctx context.Context, input *ec2.CreateSecurityGroupInput, opts ...request.Option) (*ec2.CreateSecurityGroupOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.created = input
+	return &ec2.CreateSecurityGroupOutput{GroupId: aws.String("sg-new")}, nil
+}
+
+func (m *mockEC2Client) DeleteSecurityGroupWithContext(ctx context.Context, input *ec2.DeleteSecurityGroupInput, opts ...request.Option) (*ec2.DeleteSecurityGroupOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.deleted = aws.StringValue(input.GroupId)
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+func (m *mockEC2Client) AuthorizeSecurityGroupIngressWithContext(ctx context.Context, input *ec2.AuthorizeSecurityGroupIngressInput, opts ...request.Option) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.permissions = input.IpPermissions
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (m *mockEC2Client) RevokeSecurityGroupIngressWithContext(ctx context.Context, input *ec2.RevokeSecurityGroupIngressInput, opts ...request.Option) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.permissions = input.IpPermissions
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func TestNewSession(t *testing.T) {
+	client := New()
+	to := reflect.TypeOf(client).String()
+	if to != "*ec2.EC2" {
+		t.Errorf("expected type to be '*ec2.EC2', got %s", to)
+	}
+}
+
+func TestGetSubnets(t *testing.T) {
+	client := EC2{Service: newmockEC2Client(t, nil)}
+
+	out, err := client.GetSubnets(context.TODO(), []string{"subnet-2"})
+	if err != nil {
+		t.Errorf("expected nil error, got: %s", err)
+	}
+
+	if !reflect.DeepEqual(out, testSubnets[1:]) {
+		t.Errorf("expected %+v, got %+v", testSubnets[1:], out)
+	}
+
+	if _, err := client.GetSubnets(context.TODO(), nil); err == nil {
+		t.Error("expected error for empty input, got nil")
+	}
+
+	client.Service = newmockEC2Client(t, awserr.New("InvalidSubnetID.NotFound", "not found", nil))
+	if _, err := client.GetSubnets(context.TODO(), []string{"subnet-3"}); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestListSubnetsWithTags(t *testing.T) {
+	mock := newmockEC2Client(t, nil)
+	client := EC2{Service: mock}
+
+	out, err := client.ListSubnetsWithTags(context.TODO(), map[string]string{"tier": "database", "spinup:org": "localdev"})
+	if err != nil {
+		t.Errorf("expected nil error, got: %s", err)
+	}
+
+	if !reflect.DeepEqual(out, testSubnets) {
+		t.Errorf("expected %+v, got %+v", testSubnets, out)
+	}
+
+	expected := []*ec2.Filter{
+		{Name: aws.String("tag:spinup:org"), Values: aws.StringSlice([]string{"localdev"})},
+		{Name: aws.String("tag:tier"), Values: aws.StringSlice([]string{"database"})},
+	}
+	if !reflect.DeepEqual(mock.filters, expected) {
+		t.Errorf("expected filters %+v, got %+v", expected, mock.filters)
+	}
+
+	if _, err := client.ListSubnetsWithTags(context.TODO(), nil); err == nil {
+		t.Error("expected error for empty tags, got nil")
+	}
+
+	client.Service = newmockEC2Client(t, awserr.New("UnauthorizedOperation", "denied", nil))
+	if _, err := client.ListSubnetsWithTags(context.TODO(), map[string]string{"tier": "database"}); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestListSecurityGroupsWithTags(t *testing.T) {
+	mock := newmockEC2Client(t, nil)
+	client := EC2{Service: mock}
+
+	out, err := client.ListSecurityGroupsWithTags(context.TODO(), "vpc-1", map[string]string{"tier": "database"})
+	if err != nil {
+		t.Errorf("expected nil error, got: %s", err)
+	}
+
+	if len(out) != 1 || aws.StringValue(out[0].GroupId) != "sg-1" {
+		t.Errorf("expected security group sg-1, got %+v", out)
+	}
+
+	expected := []*ec2.Filter{
+		{Name: aws.String("tag:tier"), Values: aws.StringSlice([]string{"database"})},
+		{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{"vpc-1"})},
+	}
+	if !reflect.DeepEqual(mock.filters, expected) {
+		t.Errorf("expected filters %+v, got %+v", expected, mock.filters)
+	}
+
+	if _, err := client.ListSecurityGroupsWithTags(context.TODO(), "", map[string]string{"tier": "database"}); err == nil {
+		t.Error("expected error for empty vpc, got nil")
+	}
+}
+
+func TestGetSecurityGroups(t *testing.T) {
+	client := EC2{Service: newmockEC2Client(t, nil)}
+
+	out, err := client.GetSecurityGroups(context.TODO(), []string{"sg-1"})
+	if err != nil {
+		t.Errorf("expected nil error, got: %s", err)
+	}
+
+	if len(out) != 1 || aws.StringValue(out[0].GroupId) != "sg-1" {
+		t.Errorf("expected security group sg-1, got %+v", out)
+	}
+
+	if _, err := client.GetSecurityGroups(context.TODO(), nil); err == nil {
+		t.Error("expected error for empty input, got nil")
+	}
+}
+
+func TestCreateSecurityGroup(t *testing.T) {
+	mock := newmockEC2Client(t, nil)
+	client := EC2{Service: mock}
+
+	tags := []*ec2.Tag{{Key: aws.String("spinup:org"), Value: aws.String("localdev")}}
+	id, err := client.CreateSecurityGroup(context.TODO(), "spinup-localdev-docdb-mydocdb", "access", "vpc-1", tags)
+	if err != nil {
+		t.Errorf("expected nil error, got: %s", err)
+	}
+
+	if id != "sg-new" {
+		t.Errorf("expected id sg-new, got %s", id)
+	}
+
+	expected := &ec2.CreateSecurityGroupInput{
+		Description: aws.String("access"),
+		GroupName:   aws.String("spinup-localdev-docdb-mydocdb"),
+		VpcId:       aws.String("vpc-1"),
+		TagSpecifications: []*ec2.TagSpecification{
+			{ResourceType: aws.String("security-group"), Tags: tags},
+		},
+	}
+	if !reflect.DeepEqual(mock.created, expected) {
+		t.Errorf("expected input %+v, got %+v", expected, mock.created)
+	}
+
+	if _, err := client.CreateSecurityGroup(context.TODO(), "name", "access", "", nil); err == nil {
+		t.Error("expected error for empty vpc, got nil")
+	}
+
+	client.Service = newmockEC2Client(t, awserr.New("InvalidGroup.Duplicate", "exists", nil))
+	if _, err := client.CreateSecurityGroup(context.TODO(), "name", "access", "vpc-1", nil); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestDeleteSecurityGroup(t *testing.T) {
+	mock := newmockEC2Client(t, nil)
+	client := EC2{Service: mock}
+
+	if err := client.DeleteSecurityGroup(context.TODO(), "sg-1"); err != nil {
+		t.Errorf("expected nil error, got: %s", err)
+	}
+
+	if mock.deleted != "sg-1" {
+		t.Errorf("expected sg-1 to be deleted, got %s", mock.deleted)
+	}
+
+	if err := client.DeleteSecurityGroup(context.TODO(), ""); err == nil {
+		t.Error("expected error for empty id, got nil")
+	}
+}
+
+func TestIngress(t *testing.T) {
+	mock := newmockEC2Client(t, nil)
+	client := EC2{Service: mock}
+
+	expected := []*ec2.IpPermission{
+		{
+			FromPort:         aws.Int64(27017),
+			IpProtocol:       aws.String("tcp"),
+			ToPort:           aws.Int64(27017),
+			IpRanges:         []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/16")}},
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-app")}},
+		},
+	}
+
+	if err := client.AuthorizeIngress(context.TODO(), "sg-1", 27017, []string{"10.0.0.0/16"}, []string{"sg-app"}); err != nil {
+		t.Errorf("expected nil error, got: %s", err)
+	}
+
+	if !reflect.DeepEqual(mock.permissions, expected) {
+		t.Errorf("expected permissions %+v, got %+v", expected, mock.permissions)
+	}
+
+	mock.permissions = nil
+	if err := client.RevokeIngress(context.TODO(), "sg-1", 27017, []string{"10.0.0.0/16"}, []string{"sg-app"}); err != nil {
+		t.Errorf("expected nil error, got: %s", err)
+	}
+
+	if !reflect.DeepEqual(mock.permissions, expected) {
+		t.Errorf("expected permissions %+v, got %+v", expected, mock.permissions)
+	}
+
+	// nothing to change doesn't call aws
+	mock.permissions = nil
+	if err := client.AuthorizeIngress(context.TODO(), "sg-1", 27017, nil, nil); err != nil || mock.permissions != nil {
+		t.Errorf("expected no call without rules, got %v, %+v", err, mock.permissions)
+	}
+}
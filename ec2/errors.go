@@ -0,0 +1,60 @@
+package ec2
+
+import (
+	"github.com/YaleSpinup/apierror"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+func ErrCode(msg string, err error) error {
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok {
+		switch aerr.Code() {
+		case
+			// You are not authorized to perform this operation.
+			"UnauthorizedOperation",
+			"Forbidden":
+
+			return apierror.New(apierror.ErrForbidden, msg, aerr)
+		case
+			// The request rate or a resource limit was exceeded.
+			"RequestLimitExceeded",
+			"SecurityGroupLimitExceeded",
+			"RulesPerSecurityGroupLimitExceeded",
+			"LimitExceeded":
+
+			return apierror.New(apierror.ErrLimitExceeded, msg, aerr)
+		case
+			// The resource already exists.
+			"InvalidGroup.Duplicate",
+			"InvalidPermission.Duplicate",
+			// The resource is in use by another resource.
+			"DependencyViolation",
+			"InvalidGroup.InUse":
+
+			return apierror.New(apierror.ErrConflict, msg, aerr)
+		case
+			// The specified resource doesn't exist.
+			"InvalidSubnetID.NotFound",
+			"InvalidGroup.NotFound",
+			"InvalidGroupId.NotFound",
+			"InvalidVpcID.NotFound",
+			"InvalidPermission.NotFound",
+			"NotFound":
+
+			return apierror.New(apierror.ErrNotFound, msg, aerr)
+		case
+			// An internal error occurred.
+			"InternalError",
+			"ServiceUnavailable",
+			"Unavailable":
+
+			return apierror.New(apierror.ErrServiceUnavailable, msg, aerr)
+		default:
+			return apierror.New(apierror.ErrBadRequest, msg, aerr)
+		}
+	}
+
+	log.Warnf("uncaught error: %s, returning Internal Server Error", err)
+	return apierror.New(apierror.ErrInternalError, msg, err)
+}
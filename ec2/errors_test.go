@@ -0,0 +1,48 @@
+package ec2
+
+import (
+	"testing"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+)
+
+func TestErrCode(t *testing.T) {
+	apiErrorTestCases := map[string]string{
+		"":                            apierror.ErrBadRequest,
+		"UnauthorizedOperation":       apierror.ErrForbidden,
+		"RequestLimitExceeded":        apierror.ErrLimitExceeded,
+		"SecurityGroupLimitExceeded":  apierror.ErrLimitExceeded,
+		"InvalidGroup.Duplicate":      apierror.ErrConflict,
+		"InvalidPermission.Duplicate": apierror.ErrConflict,
+		"DependencyViolation":         apierror.ErrConflict,
+		"InvalidSubnetID.NotFound":    apierror.ErrNotFound,
+		"InvalidGroup.NotFound":       apierror.ErrNotFound,
+		"InvalidVpcID.NotFound":       apierror.ErrNotFound,
+		"InternalError":               apierror.ErrServiceUnavailable,
+		"InvalidParameterValue":       apierror.ErrBadRequest,
+		"InvalidSubnetID.Malformed":   apierror.ErrBadRequest,
+	}
+
+	for awsErr, apiErr := range apiErrorTestCases {
+		expected := apierror.New(apiErr, "test error", awserr.New(awsErr, awsErr, nil))
+		err := ErrCode("test error", awserr.New(awsErr, awsErr, nil))
+
+		var aerr apierror.Error
+		if !errors.As(err, &aerr) {
+			t.Errorf("expected aws error %s to be an apierror.Error %s, got %s", awsErr, apiErr, err)
+		}
+
+		if aerr.String() != expected.String() {
+			t.Errorf("expected error '%s', got '%s'", expected, aerr)
+		}
+	}
+
+	err := ErrCode("test error", errors.New("Unknown"))
+	if aerr, ok := errors.Cause(err).(apierror.Error); ok {
+		t.Logf("got apierror '%s'", aerr)
+	} else {
+		t.Errorf("expected unknown error to be an apierror.ErrInternalError, got %s", err)
+	}
+}
@@ -105,16 +105,26 @@ func (r *ResourceGroupsTaggingAPI) GetResourcesInOrg(ctx context.Context, org, r
 		})
 	}
 
-	out, err := r.Service.GetResourcesWithContext(ctx, &resourcegroupstaggingapi.GetResourcesInput{
+	input := &resourcegroupstaggingapi.GetResourcesInput{
 		ResourcesPerPage:    aws.Int64(100),
 		ResourceTypeFilters: aws.StringSlice([]string{"rds:cluster"}),
 		TagFilters:          filters,
-	})
-	if err != nil {
-		return nil, ErrCode("getting resources with tags", err)
 	}
 
-	log.Debugf("got output from get resources: %+v", out)
+	resources := []*resourcegroupstaggingapi.ResourceTagMapping{}
+	for {
+		out, err := r.Service.GetResourcesWithContext(ctx, input)
+		if err != nil {
+			return nil, ErrCode("getting resources with tags", err)
+		}
+
+		log.Debugf("got output from get resources: %+v", out)
 
-	return out.ResourceTagMappingList, nil
+		resources = append(resources, out.ResourceTagMappingList...)
+
+		if aws.StringValue(out.PaginationToken) == "" {
+			return resources, nil
+		}
+		input.PaginationToken = out.PaginationToken
+	}
 }
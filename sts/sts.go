@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/docdb-api/common"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
@@ -57,16 +58,16 @@ func (s *STS) AssumeRole(ctx context.Context, input *sts.AssumeRoleInput) (*sts.
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", nil)
 	}
 
-	log.Infof("assuming role '%s' with session name '%s'", aws.StringValue(input.RoleArn), aws.StringValue(input.RoleSessionName))
+	common.Logger(ctx).Infof("assuming role '%s' with session name '%s'", aws.StringValue(input.RoleArn), aws.StringValue(input.RoleSessionName))
 
-	log.Debugf("assuming role %s with input %+v", aws.StringValue(input.RoleArn), input)
+	common.Logger(ctx).Debugf("assuming role %s with input %+v", aws.StringValue(input.RoleArn), input)
 
 	out, err := s.Service.AssumeRoleWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debugf("got output from sts assume role (%s): %+v", aws.StringValue(input.RoleArn), out)
+	common.Logger(ctx).Debugf("got output from sts assume role (%s): %+v", aws.StringValue(input.RoleArn), out)
 
 	return out, nil
 }